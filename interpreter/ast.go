@@ -2,6 +2,8 @@ package interpreter
 
 import (
 	"bytes"
+	"fmt"
+	"math/big"
 	"reflect"
 	"strconv"
 	"strings"
@@ -15,6 +17,8 @@ import (
 type ASTNode interface {
 	String() string
 	Type() string
+	Pos() Position // the first character of the node
+	End() Position // just after the last character of the node
 }
 
 // ==================== Statement Interface
@@ -27,10 +31,39 @@ type Expression interface {
 	ASTNode
 }
 
-//
+// ==================== positions
+// a place in the source code, lines and columns start at 1
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// every node embeds its span, nodes the parser
+// didn't see in the source have the zero span
+type Span struct {
+	From Position
+	To   Position
+}
+
+func (s Span) Pos() Position {
+	return s.From
+}
+
+func (s Span) End() Position {
+	return s.To
+}
+
 // data types
-//
 type IdentifierLiteral struct {
+	Span
 	Key string
 }
 
@@ -43,6 +76,7 @@ func (i IdentifierLiteral) Type() string {
 
 // ==================== IntegerLiteral
 type IntegerLiteral struct {
+	Span
 	Key int
 }
 
@@ -53,8 +87,36 @@ func (n IntegerLiteral) Type() string {
 	return "IntegerLiteral"
 }
 
-//	=============== String
+// ==================== BigIntLiteral
+// an integer literal too large for IntegerLiteral
+type BigIntLiteral struct {
+	Span
+	Key *big.Int
+}
+
+func (b BigIntLiteral) String() string {
+	return b.Key.String()
+}
+func (b BigIntLiteral) Type() string {
+	return "BigIntLiteral"
+}
+
+// ==================== FloatLiteral
+type FloatLiteral struct {
+	Span
+	Key float64
+}
+
+func (f FloatLiteral) String() string {
+	return strconv.FormatFloat(f.Key, 'g', -1, 64)
+}
+func (f FloatLiteral) Type() string {
+	return "FloatLiteral"
+}
+
+// =============== String
 type StringLiteral struct {
+	Span
 	Key string
 }
 
@@ -67,6 +129,7 @@ func (s StringLiteral) Type() string {
 
 // ================= boolean
 type BooleanLiteral struct {
+	Span
 	Key bool
 }
 
@@ -81,8 +144,21 @@ func (b BooleanLiteral) Type() string {
 	return "BooleanLiteral"
 }
 
+// ================= nil
+type NilLiteral struct {
+	Span
+}
+
+func (n NilLiteral) String() string {
+	return NIL
+}
+func (n NilLiteral) Type() string {
+	return "NilLiteral"
+}
+
 // ================= array
 type ArrayLiteral struct {
+	Span
 	Elements []Expression
 }
 
@@ -103,8 +179,31 @@ func (a ArrayLiteral) Type() string {
 	return "ArrayLiteral"
 }
 
+// ================= hash
+type HashLiteral struct {
+	Span
+	Keys   []Expression
+	Values []Expression
+}
+
+func (h HashLiteral) String() string {
+	var out bytes.Buffer
+	pairs := []string{}
+	for i, k := range h.Keys {
+		pairs = append(pairs, k.String()+": "+h.Values[i].String())
+	}
+	out.WriteString(LBRACE)
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString(RBRACE)
+	return out.String()
+}
+func (h HashLiteral) Type() string {
+	return "HashLiteral"
+}
+
 // =========== Index Expression
 type IndexExpression struct {
+	Span
 	Left  Expression
 	Index Expression
 }
@@ -118,8 +217,11 @@ func (ie IndexExpression) Type() string {
 
 // ====== function
 type FunctionLiteral struct {
+	Span
+	Name       string // the name it is defined with, if any
 	Parameters []*IdentifierLiteral
 	ParaTypes  []string
+	ReturnType string // "" if not annotated
 	Execute    *BlockExpression
 	Hopes      *HopeBlock
 }
@@ -137,6 +239,9 @@ func (f FunctionLiteral) String() string {
 	out.WriteString(strings.Join(paras, ","))
 
 	out.WriteString(RPAREN)
+	if f.ReturnType != "" {
+		out.WriteString(" " + f.ReturnType + " ")
+	}
 	out.WriteString(f.Execute.String())
 
 	if f.Hopes != nil {
@@ -152,6 +257,7 @@ func (f FunctionLiteral) Type() string {
 }
 
 type HopeBlock struct {
+	Span
 	HopeExpressions []HopeExpression
 	NFuzzing        *IntegerLiteral
 }
@@ -175,6 +281,7 @@ func (hb HopeBlock) Type() string {
 }
 
 type HopeExpression struct {
+	Span
 	Parameters []Expression
 	Expected   Expression
 }
@@ -198,6 +305,7 @@ func (hp HopeExpression) Type() string {
 
 // =========== Call Expression
 type CallExpression struct {
+	Span
 	Function  Expression // Identifier or FunctionLiteral
 	Arguments []Expression
 }
@@ -223,6 +331,7 @@ func (ce CallExpression) Type() string {
 
 // ================== Unary Expression
 type UnaryExpression struct {
+	Span
 	Operator string
 	Right    Expression
 }
@@ -236,6 +345,7 @@ func (ue UnaryExpression) Type() string {
 
 // ================== Infix Expression
 type InfixExpression struct {
+	Span
 	Operator    string
 	Left, Right Expression
 }
@@ -253,6 +363,7 @@ func (ie InfixExpression) Type() string {
 
 // ================== AssignStatement
 type AssignExpression struct {
+	Span
 	Ident *IdentifierLiteral
 	Expr  Expression
 }
@@ -264,8 +375,23 @@ func (ae AssignExpression) Type() string {
 	return "AssignExpression"
 }
 
+// ================== a[i] = x
+type IndexAssignExpression struct {
+	Span
+	Target *IndexExpression
+	Expr   Expression
+}
+
+func (ia IndexAssignExpression) String() string {
+	return ia.Target.String() + " = " + ia.Expr.String()
+}
+func (ia IndexAssignExpression) Type() string {
+	return "IndexAssignExpression"
+}
+
 // define
 type DefineExpression struct {
+	Span
 	Ident *IdentifierLiteral
 	Expr  Expression
 }
@@ -279,6 +405,7 @@ func (de DefineExpression) Type() string {
 
 // ================= If Statement
 type IfExpression struct {
+	Span
 	conditions []Expression
 	executes   []*BlockExpression
 }
@@ -310,6 +437,7 @@ func (ie IfExpression) Type() string {
 
 // ================= Ternary Statement
 type TernaryExpression struct {
+	Span
 	condition   Expression
 	left, right Expression
 }
@@ -323,6 +451,7 @@ func (ts TernaryExpression) Type() string {
 
 // ================= While Statement
 type WhileExpression struct {
+	Span
 	Condition Expression
 	Execute   *BlockExpression
 }
@@ -335,6 +464,7 @@ func (we WhileExpression) Type() string {
 }
 
 type BlockExpression struct {
+	Span
 	Statements []Statement
 }
 
@@ -355,6 +485,7 @@ func (be BlockExpression) Type() string {
 
 // ==================== Program
 type Program struct {
+	Span
 	Statements []Statement
 }
 
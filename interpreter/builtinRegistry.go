@@ -0,0 +1,252 @@
+package interpreter
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+)
+
+// ====== builtin registry
+// the builtins a program can call: the standard ones in builtins
+// and the Go functions a host registers. OpGetBuiltin refers to
+// them by index, the standard ones come first in the order of builtinNames
+type Builtins struct {
+	names []string
+	fns   map[string]*Builtin
+	types map[string]*hoType // for the type checker
+}
+
+// the builtins of the ho command, nothing registers into them
+var standardBuiltins = NewBuiltins()
+
+func NewBuiltins() *Builtins {
+	b := &Builtins{fns: make(map[string]*Builtin), types: make(map[string]*hoType)}
+	for _, name := range builtinNames {
+		b.add(name, builtins[name], builtinTypes[name])
+	}
+	return b
+}
+
+func (b *Builtins) add(name string, fn *Builtin, t *hoType) {
+	b.names = append(b.names, name)
+	b.fns[name] = fn
+	b.types[name] = t
+}
+
+func (b *Builtins) get(idx int) *Builtin {
+	return b.fns[b.names[idx]]
+}
+
+// Register adds a builtin that works on Ho values directly,
+// it may panic with an error to fail the program
+func (b *Builtins) Register(name string, fn BuiltinFunction) error {
+	return b.register(name, &Builtin{Fn: fn}, anyType)
+}
+
+func (b *Builtins) register(name string, fn *Builtin, t *hoType) error {
+	if _, ok := b.fns[name]; ok {
+		return fmt.Errorf("builtin %s already exists", name)
+	}
+	// the index is a 1-byte operand
+	if len(b.names) > 255 {
+		return fmt.Errorf("too many builtins")
+	}
+	b.add(name, fn, t)
+	return nil
+}
+
+var (
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+	objectType = reflect.TypeOf((*Object)(nil)).Elem()
+	bigIntType = reflect.TypeOf((*big.Int)(nil))
+)
+
+// RegisterFunc adds a Go function as the builtin name, e.g.
+//
+//	b.RegisterFunc("now", func() int { return int(time.Now().Unix()) })
+//
+// The arguments are converted to the parameter types of fn, its result
+// by ToObject. fn may return a value, an error, or a value and an error,
+// a non-nil error stops the program with a runtime error
+func (b *Builtins) RegisterFunc(name string, fn interface{}) error {
+	if f, ok := fn.(func(...Object) Object); ok {
+		return b.Register(name, f)
+	}
+	rv := reflect.ValueOf(fn)
+	rt := rv.Type()
+	if rt.Kind() != reflect.Func {
+		return fmt.Errorf("builtin %s: want a function, got %T", name, fn)
+	}
+	if rt.IsVariadic() {
+		return fmt.Errorf("builtin %s: variadic functions are not supported", name)
+	}
+	hasValue, hasError := false, false
+	switch rt.NumOut() {
+	case 0:
+	case 1:
+		hasError = rt.Out(0) == errorType
+		hasValue = !hasError
+	case 2:
+		if rt.Out(1) != errorType {
+			return fmt.Errorf("builtin %s: the second result must be an error", name)
+		}
+		hasValue, hasError = true, true
+	default:
+		return fmt.Errorf("builtin %s: too many results", name)
+	}
+
+	paras := make([]*hoType, rt.NumIn())
+	for i := range paras {
+		paras[i] = goToHoType(rt.In(i))
+	}
+	result := nilType
+	if hasValue {
+		result = goToHoType(rt.Out(0))
+	}
+
+	wrapper := func(args ...Object) Object {
+		if len(args) != rt.NumIn() {
+			panic(fmt.Errorf("%s wants %d arguments, got %d", name, rt.NumIn(), len(args)))
+		}
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			v, err := fromObject(arg, rt.In(i))
+			if err != nil {
+				panic(fmt.Errorf("argument %d of %s %v", i+1, name, err))
+			}
+			in[i] = v
+		}
+		out := rv.Call(in)
+		if hasError {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				panic(fmt.Errorf("%s: %w", name, err))
+			}
+		}
+		if !hasValue {
+			return NullObj
+		}
+		obj, err := ToObject(out[0].Interface())
+		if err != nil {
+			panic(fmt.Errorf("%s: %v", name, err))
+		}
+		return obj
+	}
+	return b.register(name, &Builtin{Fn: wrapper}, funcType(result, paras...))
+}
+
+// the checker's view of a Go type
+func goToHoType(t reflect.Type) *hoType {
+	if t == bigIntType {
+		return intType
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return intType
+	case reflect.Float32, reflect.Float64:
+		return floatType
+	case reflect.String:
+		return stringType
+	case reflect.Bool:
+		return boolType
+	case reflect.Slice, reflect.Array:
+		return arrayType
+	case reflect.Map:
+		return hashType
+	}
+	return anyType
+}
+
+// fromObject converts obj to a value of type t,
+// the error reads after "argument 1 of f"
+func fromObject(obj Object, t reflect.Type) (reflect.Value, error) {
+	mismatch := func() (reflect.Value, error) {
+		return reflect.Value{}, fmt.Errorf("must be %s, got %s", t, typeName(obj))
+	}
+	switch {
+	case t == objectType:
+		return reflect.ValueOf(&obj).Elem(), nil
+	case t == bigIntType:
+		if !isInteger(obj) {
+			return mismatch()
+		}
+		return reflect.ValueOf(toBig(obj)), nil
+	}
+
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := obj.(*Integer)
+		if !ok || v.OverflowInt(int64(i.Value)) {
+			return mismatch()
+		}
+		v.SetInt(int64(i.Value))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if !isInteger(obj) {
+			return mismatch()
+		}
+		n := toBig(obj)
+		if n.Sign() < 0 || !n.IsUint64() || v.OverflowUint(n.Uint64()) {
+			return mismatch()
+		}
+		v.SetUint(n.Uint64())
+	case reflect.Float32, reflect.Float64:
+		if !isNumber(obj) {
+			return mismatch()
+		}
+		v.SetFloat(toFloat(obj))
+	case reflect.String:
+		s, ok := obj.(*String)
+		if !ok {
+			return mismatch()
+		}
+		v.SetString(s.Value)
+	case reflect.Bool:
+		b, ok := obj.(*Boolean)
+		if !ok {
+			return mismatch()
+		}
+		v.SetBool(b.Value)
+	case reflect.Slice:
+		arr, ok := obj.(*Array)
+		if !ok {
+			return mismatch()
+		}
+		v.Set(reflect.MakeSlice(t, len(arr.Elements), len(arr.Elements)))
+		for i, e := range arr.Elements {
+			ev, err := fromObject(e, t.Elem())
+			if err != nil {
+				return mismatch()
+			}
+			v.Index(i).Set(ev)
+		}
+	case reflect.Map:
+		hash, ok := obj.(*Hash)
+		if !ok {
+			return mismatch()
+		}
+		v.Set(reflect.MakeMapWithSize(t, len(hash.Pairs)))
+		for _, pair := range hash.Pairs {
+			kv, err := fromObject(pair.Key, t.Key())
+			if err != nil {
+				return mismatch()
+			}
+			vv, err := fromObject(pair.Value, t.Elem())
+			if err != nil {
+				return mismatch()
+			}
+			v.SetMapIndex(kv, vv)
+		}
+	case reflect.Interface:
+		if goValue := ToGo(obj); goValue != nil {
+			gv := reflect.ValueOf(goValue)
+			if !gv.Type().AssignableTo(t) {
+				return mismatch()
+			}
+			v.Set(gv)
+		}
+	default:
+		return mismatch()
+	}
+	return v, nil
+}
@@ -1,35 +1,171 @@
 package interpreter
 
-import "log"
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
 
 var builtins = map[string]*Builtin{
 	"len": {
 		Fn: func(args ...Object) Object {
 			if len(args) != 1 {
-				log.Panic("incorrect number of arguments in len function")
+				panic("incorrect number of arguments in len function")
 			}
 			arg := args[0]
 			switch arg := arg.(type) {
 			case *String:
-				return &Integer{Value: len(arg.Value)}
+				return newInt(len(arg.Value))
 			case *Array:
-				return &Integer{Value: len(arg.Elements)}
+				return newInt(len(arg.Elements))
+			case *Hash:
+				return newInt(len(arg.Pairs))
 			default:
-				log.Panic("wrong argument type in len function")
+				panic("wrong argument type in len function")
 			}
-			return &Integer{Value: 0}
 		},
 	},
 	"append": {
 		Fn: func(args ...Object) Object {
 			if len(args) != 2 {
-				log.Panic("incorrect number of arguments in push function")
+				panic("incorrect number of arguments in push function")
 			}
-			log.Printf("---------- append ----------")
-			log.Printf("%T %v %T %v", args[0], args[0], args[1], args[1])
 			arr := args[0].(*Array)
 			arr.Elements = append(arr.Elements, args[1])
 			return arr
 		},
 	},
+	"keys": {
+		Fn: func(args ...Object) Object {
+			hash := hashArgument("keys", 1, args)
+			keys := []Object{}
+			for _, pair := range hash.SortedPairs() {
+				keys = append(keys, pair.Key)
+			}
+			return &Array{Elements: keys}
+		},
+	},
+	"values": {
+		Fn: func(args ...Object) Object {
+			hash := hashArgument("values", 1, args)
+			values := []Object{}
+			for _, pair := range hash.SortedPairs() {
+				values = append(values, pair.Value)
+			}
+			return &Array{Elements: values}
+		},
+	},
+	"has": {
+		Fn: func(args ...Object) Object {
+			hash := hashArgument("has", 2, args)
+			_, ok := hash.Pairs[hashKey(args[1])]
+			return nativeBool(ok)
+		},
+	},
+	"delete": {
+		Fn: func(args ...Object) Object {
+			hash := hashArgument("delete", 2, args)
+			delete(hash.Pairs, hashKey(args[1]))
+			return hash
+		},
+	},
+	"int": {
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				panic("incorrect number of arguments in int function")
+			}
+			switch arg := args[0].(type) {
+			case *Integer, *BigInt:
+				return arg
+			case *Float:
+				if math.IsNaN(arg.Value) || math.IsInf(arg.Value, 0) {
+					panic(fmt.Sprintf("int: cannot convert %v", arg))
+				}
+				// towards zero, like Go
+				v, _ := big.NewFloat(arg.Value).Int(nil)
+				return newInteger(v)
+			case *String:
+				v, err := strconv.Atoi(strings.TrimSpace(arg.Value))
+				if err != nil {
+					panic(fmt.Sprintf("int: cannot convert %q", arg.Value))
+				}
+				return newInt(v)
+			case *Boolean:
+				if arg.Value {
+					return newInt(1)
+				}
+				return newInt(0)
+			}
+			panic("wrong argument type in int function")
+		},
+	},
+	"float": {
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				panic("incorrect number of arguments in float function")
+			}
+			switch arg := args[0].(type) {
+			case *Integer, *BigInt, *Float:
+				return &Float{Value: toFloat(arg)}
+			case *String:
+				v, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+				if err != nil {
+					panic(fmt.Sprintf("float: cannot convert %q", arg.Value))
+				}
+				return &Float{Value: v}
+			}
+			panic("wrong argument type in float function")
+		},
+	},
+	"string": {
+		Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				panic("incorrect number of arguments in string function")
+			}
+			return &String{Value: args[0].String()}
+		},
+	},
+}
+
+// builtins are referred to by their index in the bytecode
+var builtinNames = sortedBuiltinNames()
+
+func sortedBuiltinNames() []string {
+	names := []string{}
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// the first argument of keys, values, has and delete
+func hashArgument(name string, n int, args []Object) *Hash {
+	if len(args) != n {
+		panic(fmt.Sprintf("incorrect number of arguments in %s function", name))
+	}
+	hash, ok := args[0].(*Hash)
+	if !ok {
+		panic(fmt.Sprintf("wrong argument type in %s function", name))
+	}
+	return hash
+}
+
+func hashKey(obj Object) HashKey {
+	key, err := toHashKey(obj)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func toHashKey(obj Object) (HashKey, error) {
+	key, ok := obj.(Hashable)
+	if !ok {
+		return HashKey{}, fmt.Errorf("unusable as hash key: %s", obj.Type())
+	}
+	return key.HashKey(), nil
 }
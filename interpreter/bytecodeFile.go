@@ -0,0 +1,391 @@
+package interpreter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+)
+
+// ====== .hoc files
+// a compiled program on disk, numbers are varints
+// and floats 8 bytes big-endian:
+//
+//	magic "\x7fHOC", version, flags
+//	the builtins the program refers to by index
+//	constants: count, then a tag and the value of each
+//	instructions: length, bytes
+//	the line table, if flags has hocDebug
+//
+// a CompiledFunction constant holds its own instructions,
+// and with hocDebug its name and line table.
+// Readers refuse other versions, bump HocVersion whenever
+// the layout or the meaning of an opcode changes.
+const (
+	hocMagic   = "\x7fHOC"
+	HocVersion = 2 // 2 added OpTailCall
+
+	hocDebug = 1 << 0 // names and line tables for runtime errors
+)
+
+// constant tags
+const (
+	hocInteger = iota + 1
+	hocString
+	hocBoolean
+	hocCompiledFunction
+	hocFloat
+	hocBigInt
+	hocNull
+)
+
+var ErrNotHoc = errors.New("not a .hoc file")
+
+// IsHoc tells whether data starts like a .hoc file
+func IsHoc(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(hocMagic))
+}
+
+// ====== writing
+type hocWriter struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (hw *hocWriter) bytes(b []byte) {
+	if hw.err == nil {
+		_, hw.err = hw.w.Write(b)
+	}
+}
+
+func (hw *hocWriter) uint(v int) {
+	n := binary.PutUvarint(hw.buf[:], uint64(v))
+	hw.bytes(hw.buf[:n])
+}
+
+func (hw *hocWriter) int(v int64) {
+	n := binary.PutVarint(hw.buf[:], v)
+	hw.bytes(hw.buf[:n])
+}
+
+func (hw *hocWriter) string(s string) {
+	hw.uint(len(s))
+	hw.bytes([]byte(s))
+}
+
+func (hw *hocWriter) lines(lines []LineInfo) {
+	hw.uint(len(lines))
+	for _, l := range lines {
+		hw.uint(l.Offset)
+		hw.uint(l.Line)
+	}
+}
+
+func (hw *hocWriter) constant(obj Object, debug bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		hw.uint(hocInteger)
+		hw.int(int64(obj.Value))
+	case *String:
+		hw.uint(hocString)
+		hw.string(obj.Value)
+	case *Boolean:
+		hw.uint(hocBoolean)
+		if obj.Value {
+			hw.uint(1)
+		} else {
+			hw.uint(0)
+		}
+	case *Float:
+		hw.uint(hocFloat)
+		hw.uint64(math.Float64bits(obj.Value))
+	case *BigInt:
+		hw.uint(hocBigInt)
+		hw.string(obj.Value.String())
+	case *Null:
+		hw.uint(hocNull)
+	case *CompiledFunction:
+		hw.uint(hocCompiledFunction)
+		hw.uint(obj.NumLocals)
+		hw.uint(obj.NumParas)
+		hw.uint(len(obj.ParaTypes))
+		for _, typ := range obj.ParaTypes {
+			hw.string(typ)
+		}
+		hw.uint(len(obj.Instructions))
+		hw.bytes(obj.Instructions)
+		if debug {
+			hw.string(obj.Name)
+			hw.lines(obj.Lines)
+		}
+	default:
+		if hw.err == nil {
+			hw.err = fmt.Errorf("hoc: cannot write a %s constant", obj.Type())
+		}
+	}
+}
+
+func (hw *hocWriter) uint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	hw.bytes(b[:])
+}
+
+// WriteBytecode writes bc in the .hoc format,
+// without debug the runtime errors have no lines and function names
+func WriteBytecode(w io.Writer, bc Bytecode, debug bool) error {
+	hw := &hocWriter{w: bufio.NewWriter(w)}
+	flags := 0
+	if debug {
+		flags |= hocDebug
+	}
+	hw.bytes([]byte(hocMagic))
+	hw.uint(HocVersion)
+	hw.uint(flags)
+
+	hw.uint(len(builtinNames))
+	for _, name := range builtinNames {
+		hw.string(name)
+	}
+
+	hw.uint(len(bc.constants))
+	for _, obj := range bc.constants {
+		hw.constant(obj, debug)
+	}
+	hw.uint(len(bc.instructions))
+	hw.bytes(bc.instructions)
+	if debug {
+		hw.lines(bc.lines)
+	}
+
+	if hw.err != nil {
+		return hw.err
+	}
+	return hw.w.Flush()
+}
+
+// ====== reading
+// the whole file is read first, a length in it is checked
+// against what is left before anything is made that long
+type hocReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (hr *hocReader) fail(format string, args ...interface{}) {
+	if hr.err == nil {
+		hr.err = fmt.Errorf("hoc: "+format, args...)
+	}
+}
+
+func (hr *hocReader) bytes(n int) []byte {
+	if hr.err != nil {
+		return nil
+	}
+	if n > hr.r.Len() {
+		hr.fail("truncated file")
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(hr.r, b); err != nil {
+		hr.fail("truncated file")
+		return nil
+	}
+	return b
+}
+
+func (hr *hocReader) uint() int {
+	if hr.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(hr.r)
+	if err != nil {
+		hr.fail("truncated file")
+		return 0
+	}
+	if v > math.MaxInt32 {
+		hr.fail("%d is too large", v)
+		return 0
+	}
+	return int(v)
+}
+
+func (hr *hocReader) int() int64 {
+	if hr.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(hr.r)
+	if err != nil {
+		hr.fail("truncated file")
+	}
+	return v
+}
+
+func (hr *hocReader) uint64() uint64 {
+	b := hr.bytes(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (hr *hocReader) string() string {
+	return string(hr.bytes(hr.uint()))
+}
+
+func (hr *hocReader) lines() []LineInfo {
+	n := hr.uint()
+	lines := []LineInfo{}
+	for i := 0; i < n && hr.err == nil; i++ {
+		offset := hr.uint()
+		lines = append(lines, LineInfo{Offset: offset, Line: hr.uint()})
+	}
+	return lines
+}
+
+func (hr *hocReader) constant(debug bool) Object {
+	switch tag := hr.uint(); tag {
+	case hocInteger:
+		return newInt(int(hr.int()))
+	case hocString:
+		return &String{Value: hr.string()}
+	case hocBoolean:
+		return nativeBool(hr.uint() == 1)
+	case hocFloat:
+		return &Float{Value: math.Float64frombits(hr.uint64())}
+	case hocBigInt:
+		s := hr.string()
+		v, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			hr.fail("bad big integer %q", s)
+			return NullObj
+		}
+		return &BigInt{Value: v}
+	case hocNull:
+		return NullObj
+	case hocCompiledFunction:
+		fn := &CompiledFunction{NumLocals: hr.uint(), NumParas: hr.uint()}
+		n := hr.uint()
+		for i := 0; i < n && hr.err == nil; i++ {
+			fn.ParaTypes = append(fn.ParaTypes, hr.string())
+		}
+		fn.Instructions = hr.bytes(hr.uint())
+		if debug {
+			fn.Name = hr.string()
+			fn.Lines = hr.lines()
+		}
+		return fn
+	default:
+		hr.fail("unknown constant tag %d", tag)
+		return NullObj
+	}
+}
+
+// ReadBytecode reads a .hoc file written by WriteBytecode
+func ReadBytecode(r io.Reader) (Bytecode, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Bytecode{}, err
+	}
+	hr := &hocReader{r: bytes.NewReader(data)}
+	if magic := hr.bytes(len(hocMagic)); string(magic) != hocMagic {
+		return Bytecode{}, ErrNotHoc
+	}
+	if version := hr.uint(); hr.err == nil && version != HocVersion {
+		return Bytecode{}, fmt.Errorf("hoc: version %d, this ho reads version %d", version, HocVersion)
+	}
+	flags := hr.uint()
+	debug := flags&hocDebug != 0
+
+	// builtins are called by index, the indexes must mean the same
+	n := hr.uint()
+	for i := 0; i < n && hr.err == nil; i++ {
+		name := hr.string()
+		if i >= len(builtinNames) || builtinNames[i] != name {
+			hr.fail("unknown builtin %s", name)
+		}
+	}
+
+	bc := Bytecode{}
+	n = hr.uint()
+	for i := 0; i < n && hr.err == nil; i++ {
+		bc.constants = append(bc.constants, hr.constant(debug))
+	}
+	bc.instructions = hr.bytes(hr.uint())
+	if debug {
+		bc.lines = hr.lines()
+	}
+	if hr.err != nil {
+		return Bytecode{}, hr.err
+	}
+
+	if err := verifyHoc(bc); err != nil {
+		return Bytecode{}, err
+	}
+	return bc, nil
+}
+
+// the VM trusts its bytecode, check it like the compiler does
+func verifyHoc(bc Bytecode) (err error) {
+	// operands cut off at the end
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("hoc: broken instructions")
+		}
+	}()
+	for _, obj := range bc.constants {
+		if fn, ok := obj.(*CompiledFunction); ok {
+			if fn.NumLocals < fn.NumParas {
+				return fmt.Errorf("hoc: %s: %d locals for %d parameters", fn.DisplayName(), fn.NumLocals, fn.NumParas)
+			}
+			if _, err := verifyStack(fn.Instructions); err != nil {
+				return fmt.Errorf("hoc: %s: %v", fn.DisplayName(), err)
+			}
+			if err := verifyOperands(fn.Instructions, fn.NumLocals, len(bc.constants)); err != nil {
+				return fmt.Errorf("hoc: %s: %v", fn.DisplayName(), err)
+			}
+		}
+	}
+	if h, err := verifyStack(bc.instructions); err != nil || (h != 1 && h != -1) {
+		return fmt.Errorf("hoc: broken main instructions")
+	}
+	if err := verifyOperands(bc.instructions, 0, len(bc.constants)); err != nil {
+		return fmt.Errorf("hoc: <main>: %v", err)
+	}
+	return nil
+}
+
+// every constant, global, builtin and local an instruction names exists
+func verifyOperands(ins Instructions, numLocals, numConstants int) error {
+	for pc := 0; pc < len(ins); {
+		op := Opcode(ins[pc])
+		size := instructionSizes[op]
+		if size == 0 {
+			return fmt.Errorf("unknown opcode %d at pc=%d", op, pc)
+		}
+		idx, max := 0, 1
+		switch op {
+		case OpConstant:
+			idx, max = ins.readUint16(pc+1), numConstants
+		case OpConstantWide:
+			idx, max = ins.readUint32(pc+1), numConstants
+		case OpGetGlobal, OpSetGlobal:
+			idx, max = ins.readUint16(pc+1), VariableSize
+		case OpGetBuiltin:
+			idx, max = ins.readUint8(pc+1), len(builtinNames)
+		case OpGetLocal, OpSetLocal:
+			idx, max = ins.readUint8(pc+1), numLocals
+		}
+		if idx >= max {
+			def, _ := Lookup(op)
+			return fmt.Errorf("%s %d at pc=%d, there are %d", def.Name, idx, pc, max)
+		}
+		pc += size
+	}
+	return nil
+}
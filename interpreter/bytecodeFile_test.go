@@ -0,0 +1,136 @@
+package interpreter
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func compileString(t *testing.T, input string) Bytecode {
+	t.Helper()
+	parser := NewParser(NewLexer(strings.NewReader(input)))
+	node, diags := parser.Parse(nil)
+	if diags != nil {
+		t.Fatal(diags)
+	}
+	compiler := NewCompiler(true)
+	if err := compiler.Compile(node); err != nil {
+		t.Fatal(err)
+	}
+	return compiler.Bytecode()
+}
+
+func TestBytecodeFile_RoundTrip(t *testing.T) {
+	input := `
+	fib := func(n int) int {
+		n <= 2 ? n : fib(n-1) + fib(n-2)
+	}
+	half := func(x float) {
+		x / 2
+	}
+	h := {"a": 1.5, 2: 99999999999999999999999, true: nil}
+	results := [fib(10), half(3), h["a"], h[2], h[true], -7, "s", len("ab")]
+	results`
+	var buf bytes.Buffer
+	if err := WriteBytecode(&buf, compileString(t, input), true); err != nil {
+		t.Fatal(err)
+	}
+	bc, err := ReadBytecode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := NewVM(bc)
+	if err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := "[89, 1.5, 1.5, 99999999999999999999999, nil, -7, s, 2]"
+	if got := vm.LastPopped().String(); got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
+
+func TestBytecodeFile_Debug(t *testing.T) {
+	input := "f := func(x) {\n1 / x\n}\nf(0)"
+	tests := []struct {
+		debug bool
+		want  string
+	}{
+		{true, "runtime error at line 2 in f: division by zero\n\tat f (line 2)\n\tat <main> (line 4)"},
+		{false, "runtime error in <anonymous>: division by zero\n\tat <anonymous>\n\tat <main>"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WriteBytecode(&buf, compileString(t, input), tt.debug); err != nil {
+			t.Fatal(err)
+		}
+		bc, err := ReadBytecode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := NewVM(bc).Run(); err == nil || err.Error() != tt.want {
+			t.Errorf("debug=%v: want %q, got %v", tt.debug, tt.want, err)
+		}
+	}
+}
+
+func TestBytecodeFile_Broken(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteBytecode(&buf, compileString(t, "f := func(x) {\nx\n}\nf(1)"), true); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if !IsHoc(data) {
+		t.Fatal("want a .hoc file")
+	}
+
+	newer := append([]byte{}, data...)
+	newer[len(hocMagic)] = HocVersion + 1
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"source", []byte("x := 1"), "not a .hoc file"},
+		{"version", newer, "hoc: version 3, this ho reads version 2"},
+		{"truncated", data[:len(data)-3], "hoc: truncated file"},
+		// OpConstant 7 with no constants
+		{"constant", append([]byte(hocMagic), HocVersion, 0, 0, 0, 3, 0, 0, 7), "hoc: <main>: OpConstant 7 at pc=0, there are 0"},
+		{"local", append(append([]byte(hocMagic), HocVersion, 0, 0, 0, 2), Make(OpGetLocal, 0)...), "hoc: <main>: OpGetLocal 0 at pc=0, there are 0"},
+	}
+	for _, tt := range tests {
+		if _, err := ReadBytecode(bytes.NewReader(tt.data)); err == nil || err.Error() != tt.want {
+			t.Errorf("%s: want %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+// a length in the file is more than the data after it
+func TestBytecodeFile_HugeLength(t *testing.T) {
+	var buf bytes.Buffer
+	hw := &hocWriter{w: bufio.NewWriter(&buf)}
+	hw.bytes([]byte(hocMagic))
+	hw.uint(HocVersion)
+	hw.uint(0) // flags
+	hw.uint(0) // builtins
+	hw.uint(1) // constants
+	hw.uint(hocString)
+	hw.uint(math.MaxInt32)
+	hw.bytes([]byte("abc"))
+	if err := hw.w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := ReadBytecode(&buf)
+	runtime.ReadMemStats(&after)
+	if err == nil || err.Error() != "hoc: truncated file" {
+		t.Errorf("want hoc: truncated file, got %v", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("allocated %d bytes for a file of %d", allocated, buf.Len())
+	}
+}
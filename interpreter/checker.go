@@ -0,0 +1,509 @@
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ==================== types
+// the static type of an expression.
+// The checker is gradual: what it can't tell is any,
+// and any goes with everything.
+type hoType struct {
+	name   string
+	params []*hoType // func only
+	result *hoType   // func only
+}
+
+var (
+	anyType    = &hoType{name: "any"}
+	intType    = &hoType{name: "int"}
+	floatType  = &hoType{name: "float"}
+	stringType = &hoType{name: "string"}
+	boolType   = &hoType{name: "bool"}
+	nilType    = &hoType{name: "nil"}
+	arrayType  = &hoType{name: "array"}
+	hashType   = &hoType{name: "hash"}
+)
+
+func (t *hoType) String() string {
+	if t.name != "func" {
+		return t.name
+	}
+	params := []string{}
+	for _, p := range t.params {
+		params = append(params, p.String())
+	}
+	return "func(" + strings.Join(params, ", ") + ") " + t.result.String()
+}
+
+func funcType(result *hoType, params ...*hoType) *hoType {
+	return &hoType{name: "func", params: params, result: result}
+}
+
+// the type an annotation stands for, any if there is none
+func annotatedType(name string) *hoType {
+	switch name {
+	case "int":
+		return intType
+	case "float":
+		return floatType
+	case "string":
+		return stringType
+	case "bool":
+		return boolType
+	}
+	return anyType
+}
+
+func isNumeric(t *hoType) bool {
+	return t == intType || t == floatType
+}
+
+// a value of type from may be used where to is expected,
+// integers go where floats are expected like they do at runtime
+func assignable(to, from *hoType) bool {
+	switch {
+	case to == anyType || from == anyType:
+		return true
+	case to == floatType && from == intType:
+		return true
+	}
+	return to.name == from.name
+}
+
+// the type of a value that is either a or b
+func join(a, b *hoType) *hoType {
+	if a == b {
+		return a
+	}
+	return anyType
+}
+
+var builtinTypes = map[string]*hoType{
+	"len":    funcType(intType, anyType),
+	"append": funcType(arrayType, arrayType, anyType),
+	"keys":   funcType(arrayType, hashType),
+	"values": funcType(arrayType, hashType),
+	"has":    funcType(boolType, hashType, anyType),
+	"delete": funcType(hashType, hashType, anyType),
+	"int":    funcType(intType, anyType),
+	"float":  funcType(floatType, anyType),
+	"string": funcType(stringType, anyType),
+}
+
+// ==================== scopes
+// the checker sees the same scopes as the compiler:
+// the program and each function, blocks don't have their own
+type typeScope struct {
+	types map[string]*hoType
+	outer *typeScope
+}
+
+func newTypeScope(outer *typeScope) *typeScope {
+	return &typeScope{types: make(map[string]*hoType), outer: outer}
+}
+
+func (s *typeScope) lookup(name string) (*hoType, bool) {
+	for ; s != nil; s = s.outer {
+		if t, ok := s.types[name]; ok {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// ==================== checker
+// TypeChecker runs between the parser and the compiler and
+// reports what would go wrong at runtime because of types:
+// operands of operators, arguments of calls, conditions,
+// hope cases and annotated results.
+type TypeChecker struct {
+	builtins    *typeScope
+	scope       *typeScope
+	diagnostics []Diagnostic
+}
+
+func NewTypeChecker() *TypeChecker {
+	return newTypeCheckerWithBuiltins(standardBuiltins)
+}
+
+func newTypeCheckerWithBuiltins(builtins *Builtins) *TypeChecker {
+	builtinScope := newTypeScope(nil)
+	for name, t := range builtins.types {
+		builtinScope.types[name] = t
+	}
+	return &TypeChecker{builtins: builtinScope, scope: newTypeScope(builtinScope)}
+}
+
+// declare gives a global set by the host the type of its value,
+// a global that held another type becomes any
+func (tc *TypeChecker) declare(name string, obj Object) {
+	t := valueType(obj)
+	if old, ok := tc.scope.types[name]; ok {
+		t = join(old, t)
+	}
+	tc.scope.types[name] = t
+}
+
+// the type of a value, any for nil and functions
+func valueType(obj Object) *hoType {
+	switch {
+	case isInteger(obj):
+		return intType
+	case obj.Type() == FLOAT_OBJ:
+		return floatType
+	case obj.Type() == STRING_OBJ:
+		return stringType
+	case obj.Type() == BOOLEAN_OBJ:
+		return boolType
+	case obj.Type() == ARRAY_OBJ:
+		return arrayType
+	case obj.Type() == HASH_OBJ:
+		return hashType
+	}
+	return anyType
+}
+
+// the type of a value as the checker and the annotations write it,
+// for the runtime errors
+func typeName(obj Object) string {
+	switch t := valueType(obj); {
+	case t != anyType:
+		return t.name
+	case obj.Type() == NULL_OBJ:
+		return nilType.name
+	}
+	return "func"
+}
+
+// Check returns nil if it finds nothing wrong,
+// the types of globals are kept for the next Check
+func (tc *TypeChecker) Check(node ASTNode) []Diagnostic {
+	tc.diagnostics = nil
+	tc.check(node)
+	return tc.diagnostics
+}
+
+func (tc *TypeChecker) errorf(node ASTNode, format string, args ...interface{}) {
+	pos := node.Pos()
+	tc.diagnostics = append(tc.diagnostics, Diagnostic{Line: pos.Line, Column: pos.Column, Message: fmt.Sprintf(format, args...)})
+}
+
+// check returns the type of node
+func (tc *TypeChecker) check(node ASTNode) *hoType {
+	switch node := node.(type) {
+
+	case *Program:
+		return tc.checkBlock(node.Statements)
+
+	case *BlockExpression:
+		return tc.checkBlock(node.Statements)
+
+	// ---------------- leaves
+	case *IntegerLiteral, *BigIntLiteral:
+		return intType
+	case *FloatLiteral:
+		return floatType
+	case *StringLiteral:
+		return stringType
+	case *BooleanLiteral:
+		return boolType
+	case *NilLiteral:
+		return nilType
+
+	case *IdentifierLiteral:
+		// undefined variables are the compiler's business
+		if t, ok := tc.scope.lookup(node.Key); ok {
+			return t
+		}
+		return anyType
+
+	case *ArrayLiteral:
+		for _, elem := range node.Elements {
+			tc.check(elem)
+		}
+		return arrayType
+
+	case *HashLiteral:
+		for i, key := range node.Keys {
+			switch t := tc.check(key); t {
+			case floatType, arrayType, hashType, nilType:
+				tc.errorf(key, "unusable as hash key: %s", t)
+			}
+			tc.check(node.Values[i])
+		}
+		return hashType
+
+	case *FunctionLiteral:
+		return tc.checkFunction(node)
+
+	// ---------------- variables
+	case *DefineExpression:
+		// bind a function before its body is checked, so that it can call itself
+		if fn, ok := node.Expr.(*FunctionLiteral); ok {
+			tc.scope.types[node.Ident.Key] = tc.signature(fn)
+		}
+		t := tc.check(node.Expr)
+		if t == nilType {
+			// nil is a placeholder for a value to come
+			t = anyType
+		}
+		tc.scope.types[node.Ident.Key] = t
+		return nilType
+
+	case *AssignExpression:
+		t := tc.check(node.Expr)
+		// a variable that holds values of several types is any from now on
+		if old, ok := tc.scope.lookup(node.Ident.Key); ok && old != t {
+			tc.rebind(node.Ident.Key, anyType)
+		}
+		return nilType
+
+	case *IndexAssignExpression:
+		tc.checkIndex(node.Target)
+		tc.check(node.Expr)
+		return nilType
+
+	// ---------------- operators
+	case *UnaryExpression:
+		right := tc.check(node.Right)
+		switch node.Operator {
+		case "-":
+			if right != anyType && !isNumeric(right) {
+				tc.errorf(node, "operator - not supported for %s", right)
+				return anyType
+			}
+			return right
+		case "!":
+			if right != anyType && right != boolType {
+				tc.errorf(node, "operator ! not supported for %s", right)
+			}
+			return boolType
+		}
+		return anyType
+
+	case *InfixExpression:
+		return tc.checkInfix(node)
+
+	case *TernaryExpression:
+		tc.checkCondition(node.condition)
+		return join(tc.check(node.left), tc.check(node.right))
+
+	case *IndexExpression:
+		return tc.checkIndex(node)
+
+	case *CallExpression:
+		return tc.checkCall(node)
+
+	// ---------------- control flow
+	case *IfExpression:
+		var t *hoType
+		hasElse := false
+		for i, cnd := range node.conditions {
+			// else is parsed as "else if true", the branches after it are never taken
+			b, isElse := cnd.(*BooleanLiteral)
+			isElse = isElse && b.Key
+			if !isElse {
+				tc.checkCondition(cnd)
+			}
+			bt := tc.check(node.executes[i])
+			if t == nil {
+				t = bt
+			} else {
+				t = join(t, bt)
+			}
+			if isElse {
+				hasElse = true
+				break
+			}
+		}
+		if !hasElse {
+			t = join(t, nilType)
+		}
+		return t
+
+	case *WhileExpression:
+		tc.checkCondition(node.Condition)
+		tc.check(node.Execute)
+		return nilType
+	}
+	return anyType
+}
+
+// the type of the last statement, nil for an empty block
+func (tc *TypeChecker) checkBlock(stmts []Statement) *hoType {
+	t := nilType
+	for _, stmt := range stmts {
+		t = tc.check(stmt)
+	}
+	return t
+}
+
+// an assignment changes the binding where the variable lives
+func (tc *TypeChecker) rebind(name string, t *hoType) {
+	for s := tc.scope; s != nil; s = s.outer {
+		if _, ok := s.types[name]; ok {
+			s.types[name] = t
+			return
+		}
+	}
+}
+
+func (tc *TypeChecker) checkCondition(cnd Expression) {
+	if t := tc.check(cnd); t != anyType && t != boolType {
+		tc.errorf(cnd, "condition must be bool, got %s", t)
+	}
+}
+
+// the type of fn as far as its annotations tell
+func (tc *TypeChecker) signature(fn *FunctionLiteral) *hoType {
+	params := []*hoType{}
+	for i := range fn.Parameters {
+		name := ""
+		if i < len(fn.ParaTypes) {
+			name = fn.ParaTypes[i]
+		}
+		params = append(params, annotatedType(name))
+	}
+	return funcType(annotatedType(fn.ReturnType), params...)
+}
+
+func (tc *TypeChecker) checkFunction(fn *FunctionLiteral) *hoType {
+	sig := tc.signature(fn)
+	name := fn.Name
+	if name == "" {
+		name = "<anonymous>"
+	}
+
+	outer := tc.scope
+	tc.scope = newTypeScope(outer)
+	for i, para := range fn.Parameters {
+		tc.scope.types[para.Key] = sig.params[i]
+	}
+	result := tc.check(fn.Execute)
+	tc.scope = outer
+
+	if fn.ReturnType != "" {
+		if !assignable(sig.result, result) {
+			pos := fn.Execute.Pos()
+			if n := len(fn.Execute.Statements); n > 0 {
+				pos = fn.Execute.Statements[n-1].Pos()
+			}
+			tc.diagnostics = append(tc.diagnostics, Diagnostic{Line: pos.Line, Column: pos.Column,
+				Message: fmt.Sprintf("%s returns %s, but is annotated %s", name, result, sig.result)})
+		}
+		result = sig.result
+	}
+	t := funcType(result, sig.params...)
+
+	if fn.Hopes != nil {
+		for i, hope := range fn.Hopes.HopeExpressions {
+			if len(hope.Parameters) != len(sig.params) {
+				tc.errorf(hope, "hope case %d of %s has %d arguments, want %d", i+1, name, len(hope.Parameters), len(sig.params))
+				continue
+			}
+			for j, arg := range hope.Parameters {
+				if at := tc.check(arg); !assignable(sig.params[j], at) {
+					tc.errorf(arg, "hope case %d of %s: argument %d must be %s, got %s", i+1, name, j+1, sig.params[j], at)
+				}
+			}
+			// integers and floats are compared by value
+			want := tc.check(hope.Expected)
+			if !assignable(result, want) && !(isNumeric(result) && isNumeric(want)) {
+				tc.errorf(hope.Expected, "hope case %d of %s expects %s, but it returns %s", i+1, name, want, result)
+			}
+		}
+	}
+	return t
+}
+
+func (tc *TypeChecker) checkInfix(node *InfixExpression) *hoType {
+	left := tc.check(node.Left)
+	right := tc.check(node.Right)
+	mismatch := func() *hoType {
+		tc.errorf(node, "type mismatch: %s %s %s", left, node.Operator, right)
+		return anyType
+	}
+
+	switch node.Operator {
+	case "==", "!=":
+		// values of different types are just not equal
+		return boolType
+
+	case "<", ">", "<=", ">=":
+		if !numericOperand(left) || !numericOperand(right) {
+			return mismatch()
+		}
+		return boolType
+
+	case "+", "-", "*", "/", "%":
+		if node.Operator == "+" && (left == stringType || right == stringType) {
+			if (left != stringType && left != anyType) || (right != stringType && right != anyType) {
+				return mismatch()
+			}
+			return stringType
+		}
+		if !numericOperand(left) || !numericOperand(right) {
+			return mismatch()
+		}
+		switch {
+		case left == anyType || right == anyType:
+			return anyType
+		case left == floatType || right == floatType:
+			return floatType
+		}
+		return intType
+	}
+	return anyType
+}
+
+func numericOperand(t *hoType) bool {
+	return t == anyType || isNumeric(t)
+}
+
+func (tc *TypeChecker) checkIndex(node *IndexExpression) *hoType {
+	left := tc.check(node.Left)
+	index := tc.check(node.Index)
+	switch left {
+	case arrayType:
+		if index != anyType && index != intType {
+			tc.errorf(node.Index, "array index must be int, got %s", index)
+		}
+	case hashType:
+		switch index {
+		case floatType, arrayType, hashType, nilType:
+			tc.errorf(node.Index, "unusable as hash key: %s", index)
+		}
+	case anyType:
+	default:
+		tc.errorf(node, "index operator not supported: %s", left)
+	}
+	return anyType
+}
+
+func (tc *TypeChecker) checkCall(node *CallExpression) *hoType {
+	fn := tc.check(node.Function)
+	args := []*hoType{}
+	for _, arg := range node.Arguments {
+		args = append(args, tc.check(arg))
+	}
+	if fn == anyType {
+		return anyType
+	}
+	if fn.name != "func" {
+		tc.errorf(node, "calling non-function %s", fn)
+		return anyType
+	}
+	name := node.Function.String()
+	if len(args) != len(fn.params) {
+		tc.errorf(node, "%s wants %d arguments, got %d", name, len(fn.params), len(args))
+		return fn.result
+	}
+	for i, at := range args {
+		if !assignable(fn.params[i], at) {
+			tc.errorf(node.Arguments[i], "argument %d of %s must be %s, got %s", i+1, name, fn.params[i], at)
+		}
+	}
+	return fn.result
+}
@@ -0,0 +1,141 @@
+package interpreter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func checkTypes(t *testing.T, input string) []Diagnostic {
+	t.Helper()
+	parser := NewParser(NewLexer(strings.NewReader(input)))
+	node, diags := parser.Parse(nil)
+	if diags != nil {
+		t.Fatal(diags)
+	}
+	return NewTypeChecker().Check(node)
+}
+
+func TestTypeChecker_Errors(t *testing.T) {
+	input := `add := func(x int, y int) int {
+	x + y
+} hope {
+	1, 2 -> 3
+	1 -> 2
+	"a", 2 -> 3
+	1, 2 -> "x"
+}
+name := func(s string) int {
+	s + "!"
+}
+if 1 {
+	2
+}
+add(1, true)
+z := "a" - 1
+n := 3
+n(1)
+-"a"
+[1][true]
+{1.5: 1}
+f := func(n) {
+	f(n)
+}
+f(1, 2)
+1 < "b"
+!2
+len(1, 2)`
+	want := []Diagnostic{
+		{5, 2, "hope case 2 of add has 1 arguments, want 2"},
+		{6, 2, "hope case 3 of add: argument 1 must be int, got string"},
+		{7, 10, "hope case 4 of add expects string, but it returns int"},
+		{10, 2, "name returns string, but is annotated int"},
+		{12, 4, "condition must be bool, got int"},
+		{15, 8, "argument 2 of add must be int, got bool"},
+		{16, 6, "type mismatch: string - int"},
+		{18, 1, "calling non-function int"},
+		{19, 1, "operator - not supported for string"},
+		{20, 5, "array index must be int, got bool"},
+		{21, 2, "unusable as hash key: float"},
+		{25, 1, "f wants 1 arguments, got 2"},
+		{26, 1, "type mismatch: int < string"},
+		{27, 1, "operator ! not supported for int"},
+		{28, 1, "len wants 1 arguments, got 2"},
+	}
+	if got := checkTypes(t, input); !reflect.DeepEqual(got, want) {
+		t.Errorf("want\n%v\ngot\n%v", want, got)
+	}
+}
+
+// what can't be told statically is any, which goes with everything
+func TestTypeChecker_Gradual(t *testing.T) {
+	tests := []string{
+		`fib := func(n int) int {
+			n <= 2 ? n : fib(n-1) + fib(n-2)
+		} hope {
+			10 -> 89
+		}`,
+		`half := func(x float) {
+			x / 2
+		} hope {
+			3 -> 1.5
+			4 -> 2
+		}
+		half(1)`,
+		`found := nil
+		i := 0
+		while i < 3 {
+			if i == 1 {
+				found = i
+			}
+			i = i + 1
+		}
+		found + 1`,
+		`x := 1
+		x = "a"
+		x + "b"`,
+		`h := {"a": [1, 2]}
+		h["a"][0] + 1
+		keys(h)[0] + "b"`,
+		`apply := func(f, x) {
+			f(x)
+		}
+		apply(func(y) { y * 2 }, 3) + 1`,
+		`s := "a" + string(1)
+		len(s) + int("2") * float(3)`,
+		`1 == "1"
+		nil != 2`,
+	}
+	for _, input := range tests {
+		if diags := checkTypes(t, input); diags != nil {
+			t.Errorf("%q: want no diagnostics, got %v", input, diags)
+		}
+	}
+}
+
+func TestTypeChecker_Inference(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"1 + 2", "int"},
+		{"1 + 2.0", "float"},
+		{`"a" + "b"`, "string"},
+		{"1 < 2", "bool"},
+		{"x := 1\nx", "int"},
+		{"c := true\nif c {\n1\n}", "any"},
+		{"if true {\n1\n} else {\n2\n}", "int"},
+		{"f := func(a int) {\na * 2\n}\nf", "func(int) int"},
+		{"f := func(a, b) string {\na\n}\nf", "func(any, any) string"},
+		{"[1][0]", "any"},
+		{"while false {\n}", "nil"},
+	}
+	for _, tt := range tests {
+		parser := NewParser(NewLexer(strings.NewReader(tt.input)))
+		node, _ := parser.Parse(nil)
+		tc := NewTypeChecker()
+		if got := tc.check(node).String(); got != tt.want {
+			t.Errorf("%q: want %s, got %s", tt.input, tt.want, got)
+		}
+	}
+}
@@ -1,10 +1,16 @@
 package interpreter
 
+import (
+	"encoding/binary"
+	"fmt"
+)
+
 type Opcode byte
 
 const (
 	OpConstant Opcode = iota
 	OpPop
+	OpNull // push nil, the value of statements without a value
 
 	// operators
 	OpAdd
@@ -37,9 +43,174 @@ const (
 	OpCall
 	OpReturnValue
 	OpHope
+
+	// array and hash
+	OpArray
+	OpHash
+	OpIndex
+	OpSetIndex
+
+	OpGetBuiltin
+
+	// OpConstant for the constants past the 65536th
+	OpConstantWide
+
+	// OpCall whose result is returned, the callee takes the caller's frame
+	OpTailCall
 )
 
+// ====== definitions
+// the name and operand widths in bytes of an opcode,
+// the compiler, the VM, the verifier and the disassembler all decode by this
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+// Size is the length of the whole instruction
+func (d *Definition) Size() int {
+	size := 1
+	for _, w := range d.OperandWidths {
+		size += w
+	}
+	return size
+}
+
+var definitions = []*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpPop:      {"OpPop", []int{}},
+	OpNull:     {"OpNull", []int{}},
+
+	OpAdd:   {"OpAdd", []int{}},
+	OpSub:   {"OpSub", []int{}},
+	OpMult:  {"OpMult", []int{}},
+	OpDiv:   {"OpDiv", []int{}},
+	OpMod:   {"OpMod", []int{}},
+	OpMinus: {"OpMinus", []int{}},
+	OpBang:  {"OpBang", []int{}},
+	OpLt:    {"OpLt", []int{}},
+	OpGt:    {"OpGt", []int{}},
+	OpLte:   {"OpLte", []int{}},
+	OpGte:   {"OpGte", []int{}},
+	OpEq:    {"OpEq", []int{}},
+	OpNeq:   {"OpNeq", []int{}},
+
+	OpJump:        {"OpJump", []int{2}},
+	OpJumpIfFalse: {"OpJumpIfFalse", []int{2}},
+
+	OpGetGlobal: {"OpGetGlobal", []int{2}},
+	OpSetGlobal: {"OpSetGlobal", []int{2}},
+	OpGetLocal:  {"OpGetLocal", []int{1}},
+	OpSetLocal:  {"OpSetLocal", []int{1}},
+
+	OpCall:        {"OpCall", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpHope:        {"OpHope", []int{1}},
+
+	OpArray:    {"OpArray", []int{2}},
+	OpHash:     {"OpHash", []int{2}},
+	OpIndex:    {"OpIndex", []int{}},
+	OpSetIndex: {"OpSetIndex", []int{}},
+
+	OpGetBuiltin: {"OpGetBuiltin", []int{1}},
+
+	OpConstantWide: {"OpConstantWide", []int{4}},
+
+	OpTailCall: {"OpTailCall", []int{1}},
+}
+
+// the size of every instruction, 0 for an unknown opcode,
+// so the VM doesn't look up a Definition for each one it runs
+var instructionSizes = func() (sizes [256]int) {
+	for op, def := range definitions {
+		if def != nil {
+			sizes[op] = def.Size()
+		}
+	}
+	return sizes
+}()
+
+func Lookup(op Opcode) (*Definition, error) {
+	if int(op) >= len(definitions) || definitions[op] == nil {
+		return nil, fmt.Errorf("unknown opcode %d", op)
+	}
+	return definitions[op], nil
+}
+
+// Make encodes one instruction, operands big-endian.
+// An operand too big for its width is cut off, the compiler checks them
+func Make(op Opcode, operands ...int) Instructions {
+	def, err := Lookup(op)
+	if err != nil {
+		return Instructions{}
+	}
+	ins := make(Instructions, def.Size())
+	ins[0] = byte(op)
+	offset := 1
+	for i, w := range def.OperandWidths {
+		switch w {
+		case 4:
+			binary.BigEndian.PutUint32(ins[offset:], uint32(operands[i]))
+		case 2:
+			binary.BigEndian.PutUint16(ins[offset:], uint16(operands[i]))
+		case 1:
+			ins[offset] = byte(operands[i])
+		}
+		offset += w
+	}
+	return ins
+}
+
+// ReadOperands decodes the operands after the opcode,
+// it returns them and how many bytes they take
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+	for i, w := range def.OperandWidths {
+		switch w {
+		case 4:
+			operands[i] = ins.readUint32(offset)
+		case 2:
+			operands[i] = ins.readUint16(offset)
+		case 1:
+			operands[i] = ins.readUint8(offset)
+		}
+		offset += w
+	}
+	return operands, offset
+}
+
 const (
-	GlobalScope = "Global"
-	LocalScope  = "Local"
+	GlobalScope  = "Global"
+	LocalScope   = "Local"
+	BuiltinScope = "Builtin"
 )
+
+// the Ho spelling of an infix operator, for error messages
+func operatorSymbol(op Opcode) string {
+	switch op {
+	case OpAdd:
+		return "+"
+	case OpSub:
+		return "-"
+	case OpMult:
+		return "*"
+	case OpDiv:
+		return "/"
+	case OpMod:
+		return "%"
+	case OpLt:
+		return "<"
+	case OpGt:
+		return ">"
+	case OpLte:
+		return "<="
+	case OpGte:
+		return ">="
+	case OpEq:
+		return "=="
+	case OpNeq:
+		return "!="
+	}
+	return "?"
+}
@@ -0,0 +1,108 @@
+package interpreter
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMake(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		want     []byte
+	}{
+		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
+		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
+		{OpConstantWide, []int{70000}, []byte{byte(OpConstantWide), 0, 1, 17, 112}},
+		{OpAdd, []int{}, []byte{byte(OpAdd)}},
+		{Opcode(255), []int{}, []byte{}},
+	}
+	for _, tt := range tests {
+		got := Make(tt.op, tt.operands...)
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("Make(%d, %v): want %v, got %v", tt.op, tt.operands, tt.want, got)
+		}
+	}
+}
+
+func TestDefinitions(t *testing.T) {
+	for op := OpConstant; int(op) < len(definitions); op++ {
+		def, err := Lookup(op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		operands := make([]int, len(def.OperandWidths))
+		for i := range operands {
+			operands[i] = i + 1
+		}
+		ins := Make(op, operands...)
+		got, n := ReadOperands(def, ins[1:])
+		if n != def.Size()-1 || len(ins) != def.Size() {
+			t.Errorf("%s: size %d, read %d bytes of %d", def.Name, def.Size(), n, len(ins))
+		}
+		for i := range operands {
+			if got[i] != operands[i] {
+				t.Errorf("%s: want operands %v, got %v", def.Name, operands, got)
+			}
+		}
+	}
+}
+
+func TestInstructions_String(t *testing.T) {
+	ins := Instructions{}
+	for _, i := range []Instructions{
+		Make(OpConstant, 1),
+		Make(OpGetLocal, 2),
+		Make(OpCall, 1),
+		Make(OpAdd),
+		Make(OpJump, 300),
+		{200},
+		{byte(OpConstant), 1},
+	} {
+		ins = append(ins, i...)
+	}
+	want := `0000 OpConstant 1
+0003 OpGetLocal 2
+0005 OpCall 1
+0007 OpAdd
+0008 OpJump 300
+0011 ERROR: unknown opcode 200
+0012 ERROR: OpConstant cut off
+`
+	if got := ins.String(); got != want {
+		t.Errorf("want\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	input := `add := func(x int, y) {
+	x + y
+}
+add(1, "a")
+len([])`
+	var out bytes.Buffer
+	if err := Disassemble(&out, compileString(t, input)); err != nil {
+		t.Fatal(err)
+	}
+	want := `<main>:
+   1 0000 OpConstant 0         ; func add
+     0003 OpSetGlobal 0
+   4 0006 OpGetGlobal 0
+     0009 OpConstant 1         ; 1
+     0012 OpConstant 2         ; "a"
+     0015 OpCall 2
+     0017 OpPop
+   5 0018 OpGetBuiltin 6       ; len
+     0020 OpArray 0
+     0023 OpCall 1
+
+func add(int, _), constant 0, 2 locals:
+   2 0000 OpGetLocal 0
+     0002 OpGetLocal 1
+     0004 OpAdd
+   1 0005 OpReturnValue
+`
+	if got := out.String(); got != want {
+		t.Errorf("want\n%s\ngot\n%s", want, got)
+	}
+}
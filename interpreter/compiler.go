@@ -5,17 +5,16 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/fs"
-	"log"
+	"math"
 	"os"
+	"strings"
 )
 
 type CompilationScope struct {
 	instructions Instructions
-
-	// to back patch
-	jumpPos        []int
-	jumpIfFalsePos int
+	lines        []LineInfo
 }
 
 type Compiler struct {
@@ -24,25 +23,53 @@ type Compiler struct {
 	constants   []Object
 	symbolTable *SymbalTable
 
+	// the index of each Integer, String and Boolean constant by its value,
+	// a literal that is used again gets the constant of the first one
+	interned map[interface{}]int
+
+	// the first operand that doesn't fit its instruction
+	err error
+
+	// the calls whose result the function returns, they become OpTailCall
+	tailCalls map[*CallExpression]bool
+
 	// for convience, when generate byte code
 	operator2code map[string]Opcode
 
 	productive bool
 
+	// the random arguments of fuzzing are reported here
+	out io.Writer
+
+	// fold constants and leave out dead code, see optimizer.go
+	optimize bool
+
+	// the source line of the node being compiled
+	line int
+
+	// skip the hopes of functions tested by an earlier run,
+	// remembered in testedFunctions.json
+	cacheHopes      bool
 	lastFuncHash    map[string][16]byte
 	currentFuncHash map[string][16]byte
 }
 
 func NewCompiler(productive bool) *Compiler {
-	lastFuncHash := make(map[string][16]byte)
+	c := NewCompilerWithState(productive, NewGlobalSymbolTable(standardBuiltins), make([]Object, 0, 1024))
 	data, err := os.ReadFile("./testedFunctions.json")
 	if err == nil {
-		json.Unmarshal(data, &lastFuncHash)
+		json.Unmarshal(data, &c.lastFuncHash)
 	}
+	c.cacheHopes = true
+	return c
+}
+
+// NewCompilerWithState compiles on top of the globals in symbolTable
+// and the constants compiled before, as the REPL does.
+// It runs every hope and leaves testedFunctions.json alone
+func NewCompilerWithState(productive bool, symbolTable *SymbalTable, constants []Object) *Compiler {
 	mainScope := CompilationScope{
-		instructions:   make(Instructions, 0),
-		jumpPos:        make([]int, 0),
-		jumpIfFalsePos: 0,
+		instructions: make(Instructions, 0),
 	}
 
 	operator2code := map[string]Opcode{PLUS: OpAdd,
@@ -60,32 +87,65 @@ func NewCompiler(productive bool) *Compiler {
 		NEQ: OpNeq,
 	}
 
+	interned := make(map[interface{}]int)
+	for i, obj := range constants {
+		if key, ok := internKey(obj); ok {
+			if _, seen := interned[key]; !seen {
+				interned[key] = i
+			}
+		}
+	}
+
 	return &Compiler{
 		scopes:          []CompilationScope{mainScope},
-		constants:       make([]Object, 0, 1024),
-		symbolTable:     NewSymbolTable(),
+		constants:       constants,
+		interned:        interned,
+		tailCalls:       make(map[*CallExpression]bool),
+		symbolTable:     symbolTable,
 		operator2code:   operator2code,
-		lastFuncHash:    lastFuncHash,
+		lastFuncHash:    make(map[string][16]byte),
 		currentFuncHash: make(map[string][16]byte),
 		productive:      productive,
+		out:             os.Stdout,
 	}
 }
 func (c *Compiler) Compile(node ASTNode) error {
+	if line := node.Pos().Line; line > 0 {
+		defer c.setLine(c.setLine(line))
+	}
+
 	switch node := node.(type) {
 
 	case *Program:
-		for _, stmt := range node.Statements {
-			c.Compile(stmt)
+		base := len(c.constants)
+		// the value of the last statement is the final result
+		if err := c.compileBlock(node.Statements, true); err != nil {
+			return err
+		}
+		if c.err != nil {
+			return c.err
+		}
+		if c.optimize {
+			threadJumps(c.currentInstructions())
+			c.dropUnusedConstants(base)
+		}
+		height, err := verifyStack(c.currentInstructions())
+		if err != nil {
+			return err
+		}
+		// -1: it never ends, a while true loop when optimizing
+		if height != 1 && height != -1 {
+			return fmt.Errorf("stack verifier: program leaves %d values", height)
 		}
 		// write file
 		// name, hash pair
-		data, _ := json.Marshal(c.currentFuncHash)
-		os.WriteFile("testedFunctions.json", data, fs.ModePerm)
+		if c.cacheHopes {
+			data, _ := json.Marshal(c.currentFuncHash)
+			os.WriteFile("testedFunctions.json", data, fs.ModePerm)
+		}
 
 	case *BlockExpression:
-		for _, stmt := range node.Statements {
-			c.Compile(stmt)
-		}
+		return c.compileBlock(node.Statements, true)
 
 	case *FunctionLiteral:
 		c.enterScope()
@@ -93,33 +153,58 @@ func (c *Compiler) Compile(node ASTNode) error {
 		for _, para := range node.Parameters {
 			c.addVariable(para.Key)
 		}
+		c.markTailCalls(node.Execute)
 
-		c.Compile(node.Execute)
+		if err := c.Compile(node.Execute); err != nil {
+			return err
+		}
 		c.emit(OpReturnValue)
-		log.Println("compiler functionliteral ---->", c.currentInstructions(), c.symbolTable.size, len(node.Parameters))
+		if c.err != nil {
+			return c.err
+		}
+		if c.optimize {
+			threadJumps(c.currentInstructions())
+		}
+		if _, err := verifyStack(c.currentInstructions()); err != nil {
+			return err
+		}
+		debugLog.Println("compiler functionliteral ---->", c.currentInstructions(), c.symbolTable.size, len(node.Parameters))
 		// compiledFn := &CompiledFunction{ is wrong!!!
 
 		compiledFn := CompiledFunction{
 			Instructions: c.currentInstructions(),
 			NumLocals:    c.symbolTable.size,
 			NumParas:     len(node.Parameters),
+			ParaTypes:    node.ParaTypes,
+			Name:         node.Name,
+			Lines:        c.scopes[len(c.scopes)-1].lines,
+			LocalNames:   c.symbolTable.names(),
 		}
-		log.Println("compiler functionliteral ---->", compiledFn)
+		debugLog.Println("compiler functionliteral ---->", compiledFn)
 		c.leaveScope()
-		idx := c.addConstant(&compiledFn)
-		c.emit(OpConstant, idx)
+		c.emitConstant(&compiledFn)
 
 	case *CallExpression:
-		c.Compile(node.Function)
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
 		for _, para := range node.Arguments {
-			c.Compile(para)
+			if err := c.Compile(para); err != nil {
+				return err
+			}
+		}
+		if c.tailCalls[node] {
+			c.emit(OpTailCall, len(node.Arguments))
+		} else {
+			c.emit(OpCall, len(node.Arguments))
 		}
-		c.emit(OpCall, len(node.Arguments))
 
 	case *DefineExpression:
 		symbol := c.addVariable(node.Ident.Key) // return symbol
 
-		c.Compile(node.Expr)
+		if err := c.Compile(node.Expr); err != nil {
+			return err
+		}
 
 		if symbol.Scope == GlobalScope {
 			c.emit(OpSetGlobal, symbol.Index)
@@ -137,26 +222,38 @@ func (c *Compiler) Compile(node ASTNode) error {
 				return nil
 			}
 			for i, hopeExpr := range fn.Hopes.HopeExpressions {
+				c.setLine(hopeExpr.Pos().Line)
 				if symbol.Scope == GlobalScope {
 					c.emit(OpGetGlobal, symbol.Index)
 				} else if symbol.Scope == LocalScope {
 					c.emit(OpGetLocal, symbol.Index)
 				}
 				for _, para := range hopeExpr.Parameters {
-					c.Compile(para)
+					if err := c.Compile(para); err != nil {
+						return err
+					}
 				}
 				c.emit(OpCall, len(fn.Parameters))
-				c.Compile(hopeExpr.Expected)
+				if err := c.Compile(hopeExpr.Expected); err != nil {
+					return err
+				}
 				// 1 means the length of the expected answer
 				// for now, function returns only one value
 				c.emit(OpHope, i+1)
 			}
 			// add fuzzing
+			c.setLine(node.Pos().Line)
 			if fn.Hopes.NFuzzing != nil &&
 				fn.Hopes.NFuzzing.Key > 0 &&
 				len(fn.Parameters) > 0 &&
 				len(fn.ParaTypes) == len(fn.Parameters) {
 
+				// the random arguments are made by type
+				for j, typ := range fn.ParaTypes {
+					if typ == "" {
+						return fmt.Errorf("line %d: fuzzing needs every parameter annotated, %s isn't", c.line, fn.Parameters[j].Key)
+					}
+				}
 				for i := 0; i < fn.Hopes.NFuzzing.Key; i++ {
 					if symbol.Scope == GlobalScope {
 						c.emit(OpGetGlobal, symbol.Index)
@@ -180,50 +277,185 @@ func (c *Compiler) Compile(node ASTNode) error {
 						// }
 
 						obj := randomObject(fn.ParaTypes[j])
-						fmt.Printf("%d-th random obj in %d-th fuzzing = %v\n", j, i, obj)
-						idx := c.addConstant(obj)
-						c.emit(OpConstant, idx)
+						fmt.Fprintf(c.out, "%d-th random obj in %d-th fuzzing = %v\n", j, i, obj)
+						c.emitConstant(obj)
 					}
 					c.emit(OpCall, len(fn.Parameters))
+					// fuzzing only checks that the call doesn't crash
+					c.emit(OpPop)
 				}
 			}
 			// return nil
 		}
 
 	case *AssignExpression:
-		c.Compile(node.Expr)
-		symbol := c.getVariable(node.Ident.Key) // return symbol
+		if err := c.Compile(node.Expr); err != nil {
+			return err
+		}
+		symbol, err := c.getVariable(node.Ident.Key) // return symbol
+		if err != nil {
+			return err
+		}
 		if symbol.Scope == GlobalScope {
 			c.emit(OpSetGlobal, symbol.Index)
 		} else if symbol.Scope == LocalScope {
 			c.emit(OpSetLocal, symbol.Index)
 		}
 
+	case *IndexAssignExpression:
+		if err := c.Compile(node.Target.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Target.Index); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Expr); err != nil {
+			return err
+		}
+		c.emit(OpSetIndex)
+
+	case *ArrayLiteral:
+		for _, elem := range node.Elements {
+			if err := c.Compile(elem); err != nil {
+				return err
+			}
+		}
+		c.emit(OpArray, len(node.Elements))
+
+	case *HashLiteral:
+		for i, key := range node.Keys {
+			if err := c.Compile(key); err != nil {
+				return err
+			}
+			if err := c.Compile(node.Values[i]); err != nil {
+				return err
+			}
+		}
+		c.emit(OpHash, len(node.Keys))
+
+	case *IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(OpIndex)
+
 	case *IfExpression:
+		endPos := []int{}
+		hasElse := false
 		for i, cnd := range node.conditions {
-
-			c.Compile(cnd)
-			c.occupy(OpJumpIfFalse)
-			c.Compile(node.executes[i])
-			if i == len(node.conditions)-1 { // the last block
-				c.backPatch(OpJump, len(c.currentInstructions()))
-			} else {
-				c.occupy(OpJump)
+			if hasElse {
+				// the branches after one always taken
+				if err := c.discard(func() error { return c.compileBlock(node.executes[i].Statements, true) }); err != nil {
+					return err
+				}
+				continue
+			}
+			// else is parsed as "else if true", it needs no test
+			if b, ok := cnd.(*BooleanLiteral); ok && b.Key {
+				if err := c.compileBlock(node.executes[i].Statements, true); err != nil {
+					return err
+				}
+				hasElse = true
+				continue
 			}
-			c.backPatch(OpJumpIfFalse, len(c.currentInstructions()))
+			if value, ok := c.foldCondition(cnd); ok {
+				if !value {
+					if err := c.discard(func() error { return c.compileBlock(node.executes[i].Statements, true) }); err != nil {
+						return err
+					}
+					continue
+				}
+				if err := c.compileBlock(node.executes[i].Statements, true); err != nil {
+					return err
+				}
+				hasElse = true
+				continue
+			}
+			if err := c.Compile(cnd); err != nil {
+				return err
+			}
+			jumpIfFalsePos := c.occupy(OpJumpIfFalse)
+			if err := c.compileBlock(node.executes[i].Statements, true); err != nil {
+				return err
+			}
+			endPos = append(endPos, c.occupy(OpJump))
+			c.backPatch(jumpIfFalsePos, len(c.currentInstructions()))
+		}
+		// no branch is taken, an if without else is nil
+		if !hasElse {
+			c.emit(OpNull)
+		}
+		for _, pos := range endPos {
+			c.backPatch(pos, len(c.currentInstructions()))
+		}
 
+	case *TernaryExpression:
+		if value, ok := c.foldCondition(node.condition); ok {
+			live, dead := node.left, node.right
+			if !value {
+				live, dead = dead, live
+			}
+			if err := c.discard(func() error { return c.Compile(dead) }); err != nil {
+				return err
+			}
+			return c.Compile(live)
+		}
+		if err := c.Compile(node.condition); err != nil {
+			return err
+		}
+		jumpIfFalsePos := c.occupy(OpJumpIfFalse)
+		if err := c.Compile(node.left); err != nil {
+			return err
+		}
+		jumpPos := c.occupy(OpJump)
+		c.backPatch(jumpIfFalsePos, len(c.currentInstructions()))
+		if err := c.Compile(node.right); err != nil {
+			return err
 		}
+		c.backPatch(jumpPos, len(c.currentInstructions()))
+
 	case *WhileExpression:
+		value, folded := c.foldCondition(node.Condition)
+		if folded && !value {
+			if err := c.discard(func() error { return c.compileBlock(node.Execute.Statements, false) }); err != nil {
+				return err
+			}
+			c.emit(OpNull)
+			break
+		}
 		cndIdx := len(c.currentInstructions())
-		c.Compile(node.Condition)
-		c.occupy(OpJumpIfFalse)
-		c.Compile(node.Execute)
+		jumpIfFalsePos := -1
+		if !folded {
+			if err := c.Compile(node.Condition); err != nil {
+				return err
+			}
+			jumpIfFalsePos = c.occupy(OpJumpIfFalse)
+		}
+		// the body is run for its effects only
+		if err := c.compileBlock(node.Execute.Statements, false); err != nil {
+			return err
+		}
 		c.emit(OpJump, cndIdx)
-		c.backPatch(OpJumpIfFalse, len(c.currentInstructions()))
+		if jumpIfFalsePos >= 0 {
+			c.backPatch(jumpIfFalsePos, len(c.currentInstructions()))
+		}
+		// a while loop is nil
+		c.emit(OpNull)
 
 	case *InfixExpression:
-		c.Compile(node.Left)
-		c.Compile(node.Right)
+		if obj, ok := c.foldExpression(node); ok {
+			c.emitFolded(obj)
+			break
+		}
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
 		code, ok := c.operator2code[node.Operator]
 		if !ok {
 			return fmt.Errorf("illegal operator infix expression")
@@ -231,7 +463,13 @@ func (c *Compiler) Compile(node ASTNode) error {
 		c.emit(code)
 
 	case *UnaryExpression:
-		c.Compile(node.Right)
+		if obj, ok := c.foldExpression(node); ok {
+			c.emitFolded(obj)
+			break
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
 
 		switch node.Operator {
 		case BANG:
@@ -243,101 +481,146 @@ func (c *Compiler) Compile(node ASTNode) error {
 		}
 
 	case *IntegerLiteral:
-		obj := &Integer{Value: node.Key}
-		idx := c.addConstant(obj)
-		// fmt.Println("integerLiteral debug: ", obj, idx)
-		c.emit(OpConstant, idx)
+		c.emitConstant(&Integer{Value: node.Key})
 
 	case *IdentifierLiteral:
-		symbol := c.getVariable(node.Key)
+		symbol, err := c.getVariable(node.Key)
+		if err != nil {
+			return err
+		}
 		if symbol.Scope == GlobalScope {
 			c.emit(OpGetGlobal, symbol.Index)
 		} else if symbol.Scope == LocalScope {
 			c.emit(OpGetLocal, symbol.Index)
+		} else if symbol.Scope == BuiltinScope {
+			c.emit(OpGetBuiltin, symbol.Index)
 		}
 
+	case *BigIntLiteral:
+		c.emitConstant(&BigInt{Value: node.Key})
+
+	case *FloatLiteral:
+		c.emitConstant(&Float{Value: node.Key})
+
 	case *BooleanLiteral:
-		obj := &Boolean{Value: node.Key}
-		idx := c.addConstant(obj)
-		c.emit(OpConstant, idx)
+		c.emitConstant(&Boolean{Value: node.Key})
+
+	case *NilLiteral:
+		c.emit(OpNull)
 
 	case *StringLiteral:
-		obj := &String{Value: node.Key}
-		idx := c.addConstant(obj)
-		c.emit(OpConstant, idx)
+		c.emitConstant(&String{Value: node.Key})
 	}
-	return nil
+	return c.err
 }
 
 func (c *Compiler) emit(op Opcode, operands ...int) {
-	// make an instruction
-
-	ins := Instructions{0: byte(op)}
-	switch op {
-	case OpConstant, OpSetGlobal, OpGetGlobal, OpJump: // only one width-2 operand, the constant index
-		operand := uint16(operands[0])
-		ins = append(ins, byte(operand>>8))
-		ins = append(ins, byte(operand))
-	case OpGetLocal, OpSetLocal, OpCall, OpHope:
-		operand := byte(operands[0])
-		ins = append(ins, operand)
-
-	// no-operand opcode
-	case OpPop:
-	case OpAdd, OpSub, OpMult, OpDiv, OpMod:
-	case OpMinus, OpBang:
-	case OpReturnValue:
-	}
+	c.checkOperands(op, operands)
+	ins := Make(op, operands...)
 	// add it to the list
+	c.markLine()
 	c.scopes[len(c.scopes)-1].instructions = append(c.scopes[len(c.scopes)-1].instructions, ins...)
 
 	// fmt.Println("emit debug", c.scopes[len(c.scopes)-1].instructions)
 	// fmt.Println("scope = ", len(c.scopes)-1)
 	// fmt.Println("constants = ", c.constants)
-	log.Println()
 }
 
 // it reserves operand for an op, particular, Jump
-// this space will be filled later
-func (c *Compiler) occupy(op Opcode) {
+// this space will be filled later by backPatch
+// it returns the position of the operand
+func (c *Compiler) occupy(op Opcode) int {
+	c.markLine()
 	scp := c.scopes[len(c.scopes)-1]
 
-	switch op {
-	case OpJump:
-		scp.instructions = append(scp.instructions, byte(OpJump))
-		scp.instructions = append(scp.instructions, make([]byte, 2)...)
-		scp.jumpPos = append(scp.jumpPos, len(scp.instructions)-2)
-	case OpJumpIfFalse:
-		scp.instructions = append(scp.instructions, byte(OpJumpIfFalse))
-		scp.instructions = append(scp.instructions, make([]byte, 2)...)
-		scp.jumpIfFalsePos = len(scp.instructions) - 2
-	}
+	scp.instructions = append(scp.instructions, Make(op, 0)...)
 	// important
 	// slice must be assigned back
 	c.scopes[len(c.scopes)-1] = scp
+	return len(scp.instructions) - 2
 }
-func (c *Compiler) backPatch(op Opcode, operands ...int) {
+
+// fill the operand reserved at pos with the jump target
+func (c *Compiler) backPatch(pos int, target int) {
+	if target > math.MaxUint16 && c.err == nil {
+		c.err = fmt.Errorf("line %d: jump to %d, a function can't be longer than %d bytes", c.line, target, math.MaxUint16)
+	}
 	scp := c.scopes[len(c.scopes)-1]
-	switch op {
-	case OpJump:
-		operand := uint16(operands[0])
-		for _, pos := range scp.jumpPos {
-			binary.BigEndian.PutUint16(scp.instructions[pos:], operand)
-		}
-		// clear
-		scp.jumpPos = scp.jumpPos[:0]
-	case OpJumpIfFalse:
-		operand := uint16(operands[0])
-		binary.BigEndian.PutUint16(scp.instructions[scp.jumpIfFalsePos:], operand)
+	binary.BigEndian.PutUint16(scp.instructions[pos:], uint16(target))
+}
+
+// it sets the line of the following instructions and returns the previous one
+func (c *Compiler) setLine(line int) int {
+	prev := c.line
+	c.line = line
+	return prev
+}
+
+// the next instruction starts at the current line
+func (c *Compiler) markLine() {
+	scp := &c.scopes[len(c.scopes)-1]
+	if c.line == 0 {
+		return
+	}
+	if n := len(scp.lines); n > 0 && scp.lines[n-1].Line == c.line {
+		return
+	}
+	scp.lines = append(scp.lines, LineInfo{Offset: len(scp.instructions), Line: c.line})
+}
+
+// every statement leaves exactly one value on the stack, except
+// define and the assigns, which leave nothing.
+// If keep is true, the block leaves the value of its last statement
+// (nil for an empty block), otherwise it leaves nothing.
+func (c *Compiler) compileBlock(stmts []Statement, keep bool) error {
+	for i, stmt := range stmts {
+		if err := c.Compile(stmt); err != nil {
+			return err
+		}
+		last := keep && i == len(stmts)-1
+		// the pop belongs to the line of the statement
+		prev := c.setLine(stmt.Pos().Line)
+		switch stmt.(type) {
+		case *DefineExpression, *AssignExpression, *IndexAssignExpression:
+			if last {
+				c.emit(OpNull)
+			}
+		default:
+			if !last {
+				c.emit(OpPop)
+			}
+		}
+		c.setLine(prev)
+	}
+	if keep && len(stmts) == 0 {
+		c.emit(OpNull)
+	}
+	return nil
+}
+
+// the calls a function body ends with, in the last statement
+// of a block and in the branches of if and ?:
+func (c *Compiler) markTailCalls(node ASTNode) {
+	switch node := node.(type) {
+	case *BlockExpression:
+		if n := len(node.Statements); n > 0 {
+			c.markTailCalls(node.Statements[n-1])
+		}
+	case *IfExpression:
+		for _, block := range node.executes {
+			c.markTailCalls(block)
+		}
+	case *TernaryExpression:
+		c.markTailCalls(node.left)
+		c.markTailCalls(node.right)
+	case *CallExpression:
+		c.tailCalls[node] = true
 	}
-	c.scopes[len(c.scopes)-1] = scp
 }
 
 func (c *Compiler) enterScope() {
 	scope := CompilationScope{
-		instructions:   make(Instructions, 0),
-		jumpPos:        make([]int, 0),
-		jumpIfFalsePos: 0,
+		instructions: make(Instructions, 0),
 	}
 	c.scopes = append(c.scopes, scope)
 
@@ -356,17 +639,65 @@ func (c *Compiler) addVariable(name string) *Symbol {
 	return symbol
 }
 
-func (c *Compiler) getVariable(name string) *Symbol {
+func (c *Compiler) getVariable(name string) (*Symbol, error) {
 	symbol, ok := c.symbolTable.Resolve(name)
 	if !ok {
-		panic("viriable undefined!")
+		return nil, fmt.Errorf("line %d: undefined variable %s", c.line, name)
 	}
-	return symbol
+	return symbol, nil
 }
 
 func (c *Compiler) addConstant(obj Object) int {
+	key, ok := internKey(obj)
+	if ok {
+		if idx, seen := c.interned[key]; seen {
+			return idx
+		}
+	}
 	c.constants = append(c.constants, obj)
-	return len(c.constants) - 1
+	idx := len(c.constants) - 1
+	if ok {
+		c.interned[key] = idx
+	}
+	return idx
+}
+
+// the VM never changes these, so equal ones can be shared.
+// The types of the keys keep 1, "1" and true apart
+func internKey(obj Object) (interface{}, bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return obj.Value, true
+	case *String:
+		return obj.Value, true
+	case *Boolean:
+		return obj.Value, true
+	}
+	return nil, false
+}
+
+// the constants past OpConstant's 2 bytes take OpConstantWide
+func (c *Compiler) emitConstant(obj Object) {
+	idx := c.addConstant(obj)
+	if idx > math.MaxUint16 {
+		c.emit(OpConstantWide, idx)
+	} else {
+		c.emit(OpConstant, idx)
+	}
+}
+
+// an operand that Make would cut off is an error, not a wrong program
+func (c *Compiler) checkOperands(op Opcode, operands []int) {
+	def, err := Lookup(op)
+	if err != nil || c.err != nil {
+		return
+	}
+	for i, w := range def.OperandWidths {
+		if max := 1<<(8*w) - 1; operands[i] < 0 || operands[i] > max {
+			c.err = fmt.Errorf("line %d: %s %d is out of range, at most %d", c.line, def.Name, operands[i], max)
+			return
+		}
+	}
 }
 
 func (c *Compiler) currentInstructions() Instructions {
@@ -386,73 +717,38 @@ func (c *Compiler) isFunctionTested(name string, node *FunctionLiteral) bool {
 type Bytecode struct {
 	instructions Instructions
 	constants    []Object
+	lines        []LineInfo
+	globalNames  []string // by index, for the debugger
 }
 
 func (c *Compiler) Bytecode() Bytecode {
 	return Bytecode{
 		instructions: c.currentInstructions(),
 		constants:    c.constants,
+		lines:        c.scopes[len(c.scopes)-1].lines,
+		globalNames:  c.symbolTable.names(),
 	}
 }
 
+// Compile checks and compiles the source of a file, like ho run does.
+// What is wrong with the source comes back as Diagnostics
+func Compile(file string, src string, productive bool) (Bytecode, error) {
+	parser := NewParser(NewFileLexer(file, strings.NewReader(src)))
+	node, diags := parser.Parse(nil)
+	if len(diags) == 0 {
+		diags = NewTypeChecker().Check(node)
+	}
+	if len(diags) > 0 {
+		return Bytecode{}, Diagnostics(diags)
+	}
+	compiler := NewCompiler(productive)
+	if err := compiler.Compile(node); err != nil {
+		return Bytecode{}, err
+	}
+	return compiler.Bytecode(), nil
+}
+
 // debug
 func (c *Compiler) show() {
-	pc := 0
-	log.Println("compiler.show --- > scope=", len(c.scopes)-1)
-	for pc < len(c.currentInstructions()) {
-		log.Println("compiler --- > pc = ", pc)
-		op := Opcode(c.currentInstructions()[pc])
-		switch op {
-		case OpConstant:
-			log.Println("compiler --- > constant ", c.currentInstructions()[pc:pc+3])
-			pc += 3
-		case OpPop:
-			log.Println("compiler --- > pop")
-			pc++
-
-		case OpAdd, OpSub, OpMult, OpDiv, OpMod, OpLt, OpGt, OpLte, OpGte, OpEq, OpNeq:
-			log.Println("compiler --- > add sub lt ...")
-			pc++
-
-		case OpMinus:
-			log.Println("compiler --- > minus")
-			pc++
-
-		case OpBang:
-			log.Println("compiler --- > bang")
-			pc++
-
-		case OpJump:
-			log.Println("compiler --- > jump  ", c.currentInstructions()[pc:pc+3])
-			pc += 3
-
-		case OpJumpIfFalse:
-
-			log.Println("compiler --- > jumpIfFalse", c.currentInstructions()[pc:pc+3])
-			pc += 3
-
-		case OpSetGlobal:
-			log.Println("compiler --- > setGlobal  ", c.currentInstructions()[pc:pc+3])
-			pc += 3
-
-		case OpGetGlobal:
-			log.Println("compiler --- > getGlobal  ", c.currentInstructions()[pc:pc+3])
-			pc += 3
-
-		case OpSetLocal:
-			log.Println("compiler --- > setLocal  ", c.currentInstructions()[pc:pc+2])
-			pc += 2
-
-		case OpGetLocal:
-			log.Println("compiler --- > getLocal  ", c.currentInstructions()[pc:pc+2])
-			pc += 2
-
-		case OpCall:
-			log.Println("compiler --- > call  ", c.currentInstructions()[pc:pc+2])
-			pc += 1
-		default:
-			return
-		}
-		// debug
-	}
+	debugLog.Printf("compiler.show --- > scope=%d\n%s", len(c.scopes)-1, c.currentInstructions())
 }
@@ -0,0 +1,289 @@
+package interpreter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCompiler_Interning(t *testing.T) {
+	bc := compileString(t, `a := 1
+b := 1 + 1
+s := "x" + "x"
+t := "1"
+f := true == true
+g := 1.5 + 1.5
+[a, b, s, t, f, g]`)
+
+	var got []string
+	for _, c := range bc.constants {
+		got = append(got, describeValue(c))
+	}
+	// floats aren't shared
+	expected := `1 "x" "1" true 1.5 1.5`
+	if strings.Join(got, " ") != expected {
+		t.Errorf("constants %s, want %s", strings.Join(got, " "), expected)
+	}
+
+	// the constants compiled before are shared too, as in the REPL
+	symbolTable := NewGlobalSymbolTable(standardBuiltins)
+	c := NewCompilerWithState(true, symbolTable, bc.constants)
+	node, _ := NewParser(NewLexer(strings.NewReader(`"x" + "y"`))).Parse(nil)
+	if err := c.Compile(node); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(c.Bytecode().constants); n != len(bc.constants)+1 {
+		t.Errorf("%d constants, want only \"y\" added to %d", n, len(bc.constants))
+	}
+}
+
+// negating a shared constant must not change it
+func TestCompiler_InternedConstantsDontChange(t *testing.T) {
+	vm := runVM(t, `a := 1
+b := -1
+c := !true
+d := true
+[a, b, c, d, -1, 1]`)
+	if got := vm.LastPopped().String(); got != "[1, -1, false, true, -1, 1]" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestCompiler_WideConstant(t *testing.T) {
+	constants := make([]Object, 70000)
+	for i := range constants {
+		constants[i] = &Float{Value: float64(i)}
+	}
+	c := NewCompilerWithState(true, NewGlobalSymbolTable(standardBuiltins), constants)
+	node, _ := NewParser(NewLexer(strings.NewReader(`f := func(x) { x + 1 }
+f(41)`))).Parse(nil)
+	if err := c.Compile(node); err != nil {
+		t.Fatal(err)
+	}
+	bc := c.Bytecode()
+	if !strings.Contains(bc.instructions.String(), "OpConstantWide 70001") {
+		t.Errorf("no wide constant in\n%s", bc.instructions)
+	}
+	vm := NewVM(bc)
+	if err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := vm.LastPopped().String(); got != "42" {
+		t.Errorf("got %s, want 42", got)
+	}
+}
+
+func TestCompiler_OperandOverflow(t *testing.T) {
+	args := make([]string, 300)
+	for i := range args {
+		args[i] = "1"
+	}
+	locals := ""
+	for i := 0; i < 300; i++ {
+		locals += fmt.Sprintf("x%d := %d\n", i, i)
+	}
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"f := func() { 1 }\nf(" + strings.Join(args, ", ") + ")", "line 2: OpCall 300 is out of range, at most 255"},
+		{"f := func() {\n" + locals + "x299\n}\nf()", "line 258: OpSetLocal 256 is out of range, at most 255"},
+	}
+	for _, tt := range tests {
+		node, diags := NewParser(NewLexer(strings.NewReader(tt.input))).Parse(nil)
+		if diags != nil {
+			t.Fatal(diags)
+		}
+		err := NewCompilerWithState(true, NewGlobalSymbolTable(standardBuiltins), nil).Compile(node)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("got %v, want %s", err, tt.expected)
+		}
+	}
+}
+
+// compiles with the optimizer, hopes included
+func compileOptimized(t *testing.T, input string) Bytecode {
+	t.Helper()
+	node, diags := NewParser(NewLexer(strings.NewReader(input))).Parse(nil)
+	if diags != nil {
+		t.Fatal(diags)
+	}
+	c := NewCompilerWithState(false, NewGlobalSymbolTable(standardBuiltins), nil)
+	c.SetOptimize(true)
+	if err := c.Compile(node); err != nil {
+		t.Fatal(err)
+	}
+	return c.Bytecode()
+}
+
+func TestOptimizer_Fold(t *testing.T) {
+	tests := []struct {
+		input     string
+		constants string
+	}{
+		{"2 + 3 * 4", "14"},
+		{"x := 10\n(1 + 2) * x", "10 3"},
+		{`"a" + "b" == "ab"`, "true"},
+		{"-(2 - 5) + 0.5", "3.5"},
+		{"!(1 < 2)", "false"},
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		// left for the run time to fail
+		{"1 / 0", "1 0"},
+		{`1 + "a"`, `1 "a"`},
+	}
+	for _, tt := range tests {
+		bc := compileOptimized(t, tt.input)
+		var got []string
+		for _, c := range bc.constants {
+			got = append(got, describeValue(c))
+		}
+		if strings.Join(got, " ") != tt.constants {
+			t.Errorf("%q: constants %s, want %s", tt.input, strings.Join(got, " "), tt.constants)
+		}
+	}
+}
+
+func TestOptimizer_DeadBranches(t *testing.T) {
+	tests := []struct {
+		input     string
+		constants string
+		jumps     bool
+	}{
+		{`if false { "a" } else { "b" }`, `"b"`, false},
+		{`if 1 > 2 { "a" } else if true { "b" } else { "c" }`, `"b"`, false},
+		{`if 1 < 2 { "a" }`, `"a"`, false},
+		{`x := 1
+if x > 0 { "a" } else if 2 < 1 { "b" }`, `1 0 "a"`, true},
+		{`1 == 1 ? "a" : "b"`, `"a"`, false},
+		{`while false { f := func() { "dead" } }`, ``, false},
+		// not a boolean, the VM decides
+		{`if 1 { "a" }`, `1 "a"`, true},
+	}
+	for _, tt := range tests {
+		bc := compileOptimized(t, tt.input)
+		var got []string
+		for _, c := range bc.constants {
+			got = append(got, describeValue(c))
+		}
+		if strings.Join(got, " ") != tt.constants {
+			t.Errorf("%q: constants %s, want %s", tt.input, strings.Join(got, " "), tt.constants)
+		}
+		if jumps := strings.Contains(bc.instructions.String(), "OpJump"); jumps != tt.jumps {
+			t.Errorf("%q: jumps %v in\n%s", tt.input, jumps, bc.instructions)
+		}
+	}
+}
+
+// no jump lands on a jump
+func TestOptimizer_ThreadJumps(t *testing.T) {
+	bc := compileOptimized(t, `f := func(a) {
+	if a > 0 {
+		if a > 1 { "big" } else { "one" }
+	} else {
+		"none"
+	}
+}
+[f(0), f(1), f(2)]`)
+	all := []Instructions{bc.instructions}
+	for _, c := range bc.constants {
+		if fn, ok := c.(*CompiledFunction); ok {
+			all = append(all, fn.Instructions)
+		}
+	}
+	for _, ins := range all {
+		for pc := 0; pc < len(ins); {
+			op := Opcode(ins[pc])
+			if op == OpJump || op == OpJumpIfFalse {
+				if target := ins.readUint16(pc + 1); target < len(ins) && Opcode(ins[target]) == OpJump {
+					t.Errorf("%d jumps to a jump at %d in\n%s", pc, target, ins)
+				}
+			}
+			def, _ := Lookup(op)
+			pc += def.Size()
+		}
+	}
+	vm := NewVM(bc)
+	if err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := vm.LastPopped().String(); got != "[none, one, big]" {
+		t.Errorf("got %s", got)
+	}
+}
+
+// the optimized program has the same result
+func TestOptimizer_SameResults(t *testing.T) {
+	tests := []string{
+		"2 + 3 * 4",
+		`limit := 10 * 10
+total := 0
+i := 0
+while i < limit {
+	if 1 > 2 { total = total - 1 } else { total = total + 2 * 3 }
+	i = i + 1
+}
+total`,
+		`f := func(n) { n < 2 ? n : f(n - 1) + f(n - 2) }
+[f(10), true ? "yes" : "no", !false, -(-1.5), 7 % 3]`,
+		`x := 1 < 2
+if x { "a" } else { "b" }`,
+		`if false { y := 1 } else { 2 }`,
+		`while 1 > 2 { 1 }`,
+	}
+	for _, input := range tests {
+		want := runVM(t, input).LastPopped().String()
+		vm := NewVM(compileOptimized(t, input))
+		if err := vm.Run(); err != nil {
+			t.Fatalf("%q: %v", input, err)
+		}
+		if got := vm.LastPopped().String(); got != want {
+			t.Errorf("%q: optimized %s, want %s", input, got, want)
+		}
+	}
+
+	// still fails when it runs
+	vm := NewVM(compileOptimized(t, "x := 1\n10 / (1 - 1)"))
+	if err := vm.Run(); err == nil || !strings.Contains(err.Error(), "division by zero") {
+		t.Errorf("got %v, want division by zero", err)
+	}
+}
+
+// a top-level while true loop never reaches the end of the program
+func TestOptimizer_InfiniteLoop(t *testing.T) {
+	bc := compileOptimized(t, `x := 0
+while true { x = x + 1 }`)
+	if strings.Contains(bc.instructions.String(), "OpJumpIfFalse") {
+		t.Errorf("the condition is still tested in\n%s", bc.instructions)
+	}
+	vm := NewVM(bc)
+	vm.SetLimits(Limits{MaxSteps: 1000})
+	err := vm.Run()
+	var limit *LimitError
+	if !errors.As(err, &limit) || limit.Limit != "steps" {
+		t.Errorf("got %v, want the steps limit", err)
+	}
+}
+
+// the hopes test the optimized function
+func TestOptimizer_Hopes(t *testing.T) {
+	vm := NewVM(compileOptimized(t, `scale := func(x) {
+	if 2 > 1 { x * (2 + 3) } else { x }
+} hope {
+	2 -> 10
+	1 -> 4
+}
+scale(3)`))
+	var out bytes.Buffer
+	vm.out = &out
+	if err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "want 4, got 5 in the 2-th test case\n" {
+		t.Errorf("hopes printed %q", got)
+	}
+	if got := vm.LastPopped().String(); got != "15" {
+		t.Errorf("got %s, want 15", got)
+	}
+}
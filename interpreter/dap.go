@@ -0,0 +1,373 @@
+package interpreter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ====== Debug Adapter Protocol
+// ServeDAP lets an editor drive a Debugger, it speaks the Debug Adapter
+// Protocol over in and out: JSON messages behind a Content-Length header.
+// A Ho program has one thread, and no pause request.
+// The program runs while a request waits, the next one is read after it stops.
+//
+// variablesReference 1 is the globals, 2+i the locals of stack frame i
+const (
+	dapThread  = 1
+	dapGlobals = 1
+	dapLocals  = 2
+)
+
+type dapServer struct {
+	in  *bufio.Reader
+	out io.Writer
+	seq int
+
+	d       *Debugger
+	program string
+
+	breakpoints []int // set before the launch
+	stopOnEntry bool
+	launched    bool
+	configured  bool
+
+	writeErr error
+}
+
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type dapBody map[string]interface{}
+
+// the arguments of the requests, the fields Ho uses
+type dapArguments struct {
+	Program     string `json:"program"`
+	StopOnEntry bool   `json:"stopOnEntry"`
+	NoHopes     bool   `json:"noHopes"`
+	Breakpoints []struct {
+		Line int `json:"line"`
+	} `json:"breakpoints"`
+	FrameID            int    `json:"frameId"`
+	VariablesReference int    `json:"variablesReference"`
+	Expression         string `json:"expression"`
+}
+
+// ServeDAP serves one debug session, until the client disconnects or in ends
+func ServeDAP(in io.Reader, out io.Writer) error {
+	s := &dapServer{in: bufio.NewReader(in), out: out}
+	for {
+		req, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var args dapArguments
+		if len(req.Arguments) > 0 {
+			if err := json.Unmarshal(req.Arguments, &args); err != nil {
+				s.fail(req, err.Error())
+				continue
+			}
+		}
+		if req.Command == "disconnect" || req.Command == "terminate" {
+			s.respond(req, nil)
+			return s.err()
+		}
+		s.handle(req, args)
+		if err := s.err(); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *dapServer) handle(req dapRequest, args dapArguments) {
+	d := s.d
+	switch req.Command {
+	case "initialize":
+		s.respond(req, dapBody{"supportsConfigurationDoneRequest": true, "supportsEvaluateForHovers": true})
+		s.event("initialized", nil)
+	case "launch":
+		if err := s.launch(args); err != nil {
+			s.fail(req, err.Error())
+			return
+		}
+		s.respond(req, nil)
+		s.start()
+	case "setBreakpoints":
+		s.breakpoints = s.breakpoints[:0]
+		lines := breakableLines(s.bytecode())
+		result := []dapBody{}
+		for _, bp := range args.Breakpoints {
+			s.breakpoints = append(s.breakpoints, bp.Line)
+			// unknown until the launch
+			verified := d == nil || lines[bp.Line]
+			result = append(result, dapBody{"verified": verified, "line": bp.Line})
+		}
+		if d != nil {
+			for _, line := range d.Breakpoints() {
+				d.ClearBreakpoint(line)
+			}
+			for _, line := range s.breakpoints {
+				d.SetBreakpoint(line)
+			}
+		}
+		s.respond(req, dapBody{"breakpoints": result})
+	case "configurationDone":
+		s.configured = true
+		s.respond(req, nil)
+		s.start()
+	case "threads":
+		s.respond(req, dapBody{"threads": []dapBody{{"id": dapThread, "name": "main"}}})
+	case "stackTrace":
+		frames := []dapBody{}
+		if d != nil && d.Paused() {
+			source := dapBody{"name": filepath.Base(s.program), "path": s.program}
+			for i, entry := range d.CallStack() {
+				frames = append(frames, dapBody{"id": i, "name": entry.Function, "line": entry.Line, "column": 1, "source": source})
+			}
+		}
+		s.respond(req, dapBody{"stackFrames": frames, "totalFrames": len(frames)})
+	case "scopes":
+		scopes := []dapBody{}
+		if d != nil && d.Paused() && args.FrameID < len(d.vm.frames)-1 {
+			scopes = append(scopes, dapBody{"name": "Locals", "variablesReference": dapLocals + args.FrameID, "expensive": false})
+		}
+		scopes = append(scopes, dapBody{"name": "Globals", "variablesReference": dapGlobals, "expensive": false})
+		s.respond(req, dapBody{"scopes": scopes})
+	case "variables":
+		var vars []Variable
+		if d != nil && d.Paused() {
+			if args.VariablesReference == dapGlobals {
+				vars = d.Globals()
+			} else {
+				vars = d.Locals(args.VariablesReference - dapLocals)
+			}
+		}
+		result := []dapBody{}
+		for _, v := range vars {
+			result = append(result, dapBody{"name": v.Name, "value": describeValue(v.Value), "variablesReference": 0})
+		}
+		s.respond(req, dapBody{"variables": result})
+	case "evaluate":
+		if d == nil {
+			s.fail(req, "the program is not paused")
+			return
+		}
+		result, err := d.Evaluate(args.FrameID, args.Expression)
+		if err != nil {
+			s.fail(req, err.Error())
+			return
+		}
+		s.respond(req, dapBody{"result": describeValue(result), "variablesReference": 0})
+	case "continue", "next", "stepIn", "stepOut":
+		if d == nil || !d.Paused() {
+			s.fail(req, "the program is not paused")
+			return
+		}
+		if req.Command == "continue" {
+			s.respond(req, dapBody{"allThreadsContinued": true})
+		} else {
+			s.respond(req, nil)
+		}
+		switch req.Command {
+		case "continue":
+			s.stopped(d.Continue())
+		case "next":
+			s.stopped(d.StepOver())
+		case "stepIn":
+			s.stopped(d.StepInto())
+		case "stepOut":
+			s.stopped(d.StepOut())
+		}
+	default:
+		s.fail(req, "unsupported request "+req.Command)
+	}
+}
+
+// compiles the program, or reads it if it's a .hoc file
+func (s *dapServer) launch(args dapArguments) error {
+	if s.launched {
+		return fmt.Errorf("already launched")
+	}
+	input, err := os.ReadFile(args.Program)
+	if err != nil {
+		return err
+	}
+	var bc Bytecode
+	if IsHoc(input) {
+		bc, err = ReadBytecode(bytes.NewReader(input))
+	} else {
+		bc, err = Compile(args.Program, string(input), args.NoHopes)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", args.Program, err)
+	}
+
+	s.d = NewDebugger(bc)
+	s.d.SetOutput(dapOutput{s})
+	for _, line := range s.breakpoints {
+		s.d.SetBreakpoint(line)
+	}
+	s.program, s.stopOnEntry, s.launched = args.Program, args.StopOnEntry, true
+	return nil
+}
+
+// the program starts once it is launched and configured
+func (s *dapServer) start() {
+	if s.launched && s.configured && !s.d.started {
+		s.stopped(s.d.Start(s.stopOnEntry))
+	}
+}
+
+func (s *dapServer) stopped(stop Stop) {
+	if stop.Reason != StopExit {
+		reason, text := stop.Reason, ""
+		if stop.Reason == StopHope {
+			reason, text = "exception", stop.Hope
+		}
+		s.event("stopped", dapBody{"reason": reason, "threadId": dapThread, "text": text, "allThreadsStopped": true})
+		return
+	}
+	code := 0
+	if stop.Err != nil {
+		code = 1
+		s.event("output", dapBody{"category": "stderr", "output": stop.Err.Error() + "\n"})
+	} else {
+		s.event("output", dapBody{"category": "stdout", "output": describeValue(stop.Result) + "\n"})
+	}
+	s.event("exited", dapBody{"exitCode": code})
+	s.event("terminated", nil)
+}
+
+func (s *dapServer) bytecode() Bytecode {
+	if s.d == nil {
+		return Bytecode{}
+	}
+	return s.d.bc
+}
+
+// the lines a breakpoint can stop at, in main and the functions
+func breakableLines(bc Bytecode) map[int]bool {
+	lines := map[int]bool{}
+	for _, info := range bc.lines {
+		lines[info.Line] = true
+	}
+	for _, c := range bc.constants {
+		if fn, ok := c.(*CompiledFunction); ok {
+			for _, info := range fn.Lines {
+				lines[info.Line] = true
+			}
+		}
+	}
+	return lines
+}
+
+// ------ messages
+
+func (s *dapServer) read() (dapRequest, error) {
+	length := -1
+	for {
+		line, err := s.in.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && line == "" && length < 0 {
+				return dapRequest{}, io.EOF
+			}
+			return dapRequest{}, fmt.Errorf("dap: %v", err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if value := strings.TrimPrefix(line, "Content-Length:"); value != line {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return dapRequest{}, fmt.Errorf("dap: bad header %q", line)
+			}
+		}
+	}
+	if length < 0 {
+		return dapRequest{}, fmt.Errorf("dap: no Content-Length")
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(s.in, data); err != nil {
+		return dapRequest{}, fmt.Errorf("dap: %v", err)
+	}
+	var req dapRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return dapRequest{}, fmt.Errorf("dap: %v", err)
+	}
+	return req, nil
+}
+
+func (s *dapServer) respond(req dapRequest, body interface{}) {
+	s.send(&dapResponse{Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body})
+}
+
+func (s *dapServer) fail(req dapRequest, message string) {
+	s.send(&dapResponse{Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: message})
+}
+
+func (s *dapServer) event(event string, body interface{}) {
+	s.send(&dapEvent{Type: "event", Event: event, Body: body})
+}
+
+// the first write error stops the session
+func (s *dapServer) send(msg interface{}) {
+	if s.writeErr != nil {
+		return
+	}
+	s.seq++
+	switch msg := msg.(type) {
+	case *dapResponse:
+		msg.Seq = s.seq
+	case *dapEvent:
+		msg.Seq = s.seq
+	}
+	data, err := json.Marshal(msg)
+	if err == nil {
+		_, err = fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	}
+	s.writeErr = err
+}
+
+func (s *dapServer) err() error {
+	return s.writeErr
+}
+
+// the failed hopes go to the client as output events
+type dapOutput struct {
+	s *dapServer
+}
+
+func (o dapOutput) Write(p []byte) (int, error) {
+	o.s.event("output", dapBody{"category": "stdout", "output": string(p)})
+	return len(p), nil
+}
@@ -0,0 +1,96 @@
+package interpreter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServeDAP(t *testing.T) {
+	program := filepath.Join(t.TempDir(), "sum.ho")
+	if err := os.WriteFile(program, []byte(debugProgram), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var in bytes.Buffer
+	requests := []string{
+		`"initialize", "arguments": {"adapterID": "ho"}`,
+		`"setBreakpoints", "arguments": {"source": {"path": "sum.ho"}, "breakpoints": [{"line": 3}]}`,
+		fmt.Sprintf(`"launch", "arguments": {"program": %q, "noHopes": true}`, program),
+		`"setBreakpoints", "arguments": {"source": {"path": "sum.ho"}, "breakpoints": [{"line": 3}, {"line": 100}]}`,
+		`"configurationDone"`,
+		`"stackTrace", "arguments": {"threadId": 1}`,
+		`"scopes", "arguments": {"frameId": 0}`,
+		`"variables", "arguments": {"variablesReference": 2}`,
+		`"evaluate", "arguments": {"expression": "x + y + 1", "frameId": 0}`,
+		`"evaluate", "arguments": {"expression": "nope", "frameId": 0}`,
+		`"setBreakpoints", "arguments": {"source": {"path": "sum.ho"}, "breakpoints": []}`,
+		`"next", "arguments": {"threadId": 1}`,
+		`"continue", "arguments": {"threadId": 1}`,
+		`"disconnect"`,
+	}
+	for i, req := range requests {
+		body := fmt.Sprintf(`{"seq": %d, "type": "request", "command": %s}`, i+1, req)
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+	var out bytes.Buffer
+	if err := ServeDAP(&in, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	// the messages without their seq, one per line
+	var got []string
+	r := bufio.NewReader(&out)
+	for {
+		header, err := r.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		var length int
+		if _, err := fmt.Sscanf(header, "Content-Length: %d\r\n", &length); err != nil {
+			t.Fatalf("bad header %q", header)
+		}
+		r.ReadString('\n')
+		data := make([]byte, length)
+		io.ReadFull(r, data)
+		var msg map[string]interface{}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		delete(msg, "seq")
+		text, _ := json.Marshal(msg)
+		got = append(got, string(text))
+	}
+
+	expected := []string{
+		`{"body":{"supportsConfigurationDoneRequest":true,"supportsEvaluateForHovers":true},"command":"initialize","request_seq":1,"success":true,"type":"response"}`,
+		`{"event":"initialized","type":"event"}`,
+		`{"body":{"breakpoints":[{"line":3,"verified":true}]},"command":"setBreakpoints","request_seq":2,"success":true,"type":"response"}`,
+		`{"command":"launch","request_seq":3,"success":true,"type":"response"}`,
+		`{"body":{"breakpoints":[{"line":3,"verified":true},{"line":100,"verified":false}]},"command":"setBreakpoints","request_seq":4,"success":true,"type":"response"}`,
+		`{"command":"configurationDone","request_seq":5,"success":true,"type":"response"}`,
+		`{"body":{"allThreadsStopped":true,"reason":"breakpoint","text":"","threadId":1},"event":"stopped","type":"event"}`,
+		`{"body":{"stackFrames":[{"column":1,"id":0,"line":3,"name":"add","source":{"name":"sum.ho","path":"` + program + `"}},{"column":1,"id":1,"line":8,"name":"\u003cmain\u003e","source":{"name":"sum.ho","path":"` + program + `"}}],"totalFrames":2},"command":"stackTrace","request_seq":6,"success":true,"type":"response"}`,
+		`{"body":{"scopes":[{"expensive":false,"name":"Locals","variablesReference":2},{"expensive":false,"name":"Globals","variablesReference":1}]},"command":"scopes","request_seq":7,"success":true,"type":"response"}`,
+		`{"body":{"variables":[{"name":"x","value":"0","variablesReference":0},{"name":"y","value":"0","variablesReference":0},{"name":"sum","value":"-","variablesReference":0}]},"command":"variables","request_seq":8,"success":true,"type":"response"}`,
+		`{"body":{"result":"1","variablesReference":0},"command":"evaluate","request_seq":9,"success":true,"type":"response"}`,
+		`{"command":"evaluate","message":"line 1: undefined variable nope","request_seq":10,"success":false,"type":"response"}`,
+		`{"body":{"breakpoints":[]},"command":"setBreakpoints","request_seq":11,"success":true,"type":"response"}`,
+		`{"command":"next","request_seq":12,"success":true,"type":"response"}`,
+		`{"body":{"allThreadsStopped":true,"reason":"step","text":"","threadId":1},"event":"stopped","type":"event"}`,
+		`{"body":{"allThreadsContinued":true},"command":"continue","request_seq":13,"success":true,"type":"response"}`,
+		`{"body":{"category":"stdout","output":"1\n"},"event":"output","type":"event"}`,
+		`{"body":{"exitCode":0},"event":"exited","type":"event"}`,
+		`{"event":"terminated","type":"event"}`,
+		`{"command":"disconnect","request_seq":14,"success":true,"type":"response"}`,
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+}
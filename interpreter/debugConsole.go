@@ -0,0 +1,150 @@
+package interpreter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ====== debugger console
+// the terminal front-end of ho debug, a command per line:
+//
+//	b 12      break at line 12      d 12      delete it
+//	c         continue              s         step into
+//	n         step over             o         step out
+//	l         locals                g         globals
+//	bt        call stack            p expr    print expr in the paused frame
+//	q         quit                  h         help
+const (
+	debugPrompt = "(ho) "
+	debugHelp   = `b N     break at line N        d N     delete the breakpoint at line N
+c       continue               s       step into
+n       step over              o       step out
+l       locals                 g       globals
+bt      call stack             p expr  print expr in the paused frame
+q       quit                   h       this help`
+)
+
+// StartDebugger debugs the program compiled from source, file names it in the stops.
+// An empty line repeats the last step
+func StartDebugger(d *Debugger, file string, source string, in io.Reader, out io.Writer) {
+	lines := strings.Split(source, "\n")
+	scanner := bufio.NewScanner(in)
+	d.SetOutput(out)
+	fmt.Fprintf(out, "debugging %s, h for help\n", file)
+
+	last := ""
+	for {
+		fmt.Fprint(out, debugPrompt)
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+		cmd := strings.TrimSpace(scanner.Text())
+		if cmd == "" {
+			cmd = last
+		}
+		name, arg := cmd, ""
+		if i := strings.IndexByte(cmd, ' '); i >= 0 {
+			name, arg = cmd[:i], strings.TrimSpace(cmd[i+1:])
+		}
+
+		var stop Stop
+		stepped := true
+		switch name {
+		case "c":
+			stop = d.Continue()
+		case "s":
+			stop = d.StepInto()
+		case "n":
+			stop = d.StepOver()
+		case "o":
+			stop = d.StepOut()
+		default:
+			stepped = false
+		}
+		if stepped {
+			last = name
+			printStop(out, file, lines, stop)
+			continue
+		}
+
+		switch name {
+		case "":
+		case "b", "d":
+			line, err := strconv.Atoi(arg)
+			if err != nil || line < 1 {
+				fmt.Fprintf(out, "%s wants a line number\n", name)
+				break
+			}
+			if name == "b" {
+				d.SetBreakpoint(line)
+			} else {
+				d.ClearBreakpoint(line)
+			}
+			fmt.Fprintln(out, "breakpoints:", d.Breakpoints())
+		case "l", "g", "bt", "p":
+			if !d.Paused() {
+				fmt.Fprintln(out, "the program is not paused")
+				break
+			}
+			switch name {
+			case "l":
+				printVariables(out, d.Locals(0))
+			case "g":
+				printVariables(out, d.Globals())
+			case "bt":
+				for i, entry := range d.CallStack() {
+					fmt.Fprintf(out, "#%d %s\n", i, entry)
+				}
+			case "p":
+				result, err := d.Evaluate(0, arg)
+				if err != nil {
+					fmt.Fprintln(out, err)
+				} else {
+					fmt.Fprintln(out, describeValue(result))
+				}
+			}
+		case "q":
+			return
+		case "h":
+			fmt.Fprintln(out, debugHelp)
+		default:
+			fmt.Fprintf(out, "unknown command %s, h for help\n", name)
+		}
+	}
+}
+
+// where and why the program stopped, with the source line
+//
+//	hope at add.ho:5 in <main>: want 3, got -1 in the 2-th test case
+//	   5  1, 2 -> 3
+func printStop(out io.Writer, file string, lines []string, stop Stop) {
+	if stop.Reason == StopExit {
+		if stop.Err != nil {
+			fmt.Fprintln(out, stop.Err)
+		} else {
+			fmt.Fprintln(out, "exited with", describeValue(stop.Result))
+		}
+		return
+	}
+	fmt.Fprintf(out, "%s at %s:%d in %s", stop.Reason, file, stop.Line, stop.Function)
+	if stop.Hope != "" {
+		fmt.Fprint(out, ": ", stop.Hope)
+	}
+	fmt.Fprintln(out)
+	if stop.Line > 0 && stop.Line <= len(lines) {
+		fmt.Fprintf(out, "%4d  %s\n", stop.Line, strings.TrimSpace(lines[stop.Line-1]))
+	}
+}
+
+func printVariables(out io.Writer, vars []Variable) {
+	if len(vars) == 0 {
+		fmt.Fprintln(out, "none")
+	}
+	for _, v := range vars {
+		fmt.Fprintln(out, v)
+	}
+}
@@ -0,0 +1,16 @@
+package interpreter
+
+import (
+	"io"
+	"log"
+)
+
+// debugLog is where the lexer, parser and compiler trace what they do.
+// It writes nothing until SetDebugOutput, a program embedding Ho
+// doesn't want its own log filled by them
+var debugLog = log.New(io.Discard, "", log.LstdFlags)
+
+// SetDebugOutput sends the trace of the parser and compiler to w
+func SetDebugOutput(w io.Writer) {
+	debugLog.SetOutput(w)
+}
@@ -0,0 +1,303 @@
+package interpreter
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ====== debugger
+// A Debugger runs a program on a VM of its own and pauses it at
+// breakpoints, after steps and before a hope that is going to fail.
+// The VM runs in a goroutine and waits inside Step while paused,
+// so the paused state can be read from the caller's goroutine:
+//
+//	d := NewDebugger(bc)
+//	d.SetBreakpoint(3)
+//	for stop := d.Start(false); stop.Reason != StopExit; stop = d.Continue() {
+//		fmt.Println(stop.Line, d.Locals(0))
+//	}
+//
+// Pauses happen at the first instruction of a line, the line table
+// of the bytecode must be there; a stripped .hoc only stops at exit
+type Debugger struct {
+	vm          *VM
+	bc          Bytecode
+	breakpoints map[int]bool
+
+	mode  stepMode
+	depth int // the call depth the last command was given at
+
+	commands chan stepMode
+	stops    chan Stop
+	last     Stop
+	started  bool
+}
+
+type stepMode int
+
+const (
+	stepContinue stepMode = iota
+	stepInto
+	stepOver
+	stepOut
+)
+
+// why the program paused
+const (
+	StopEntry      = "entry"
+	StopBreakpoint = "breakpoint"
+	StopStep       = "step"
+	StopHope       = "hope"
+	StopExit       = "exit"
+)
+
+// Stop is where and why the program paused
+type Stop struct {
+	Reason   string
+	Function string
+	Line     int
+	Hope     string // the failing hope, for StopHope
+	Result   Object // the final result, for StopExit
+	Err      error  // what went wrong, for StopExit
+}
+
+// Variable is a name and its value in a paused program
+type Variable struct {
+	Name  string
+	Value Object
+}
+
+func (v Variable) String() string {
+	return v.Name + " = " + describeValue(v.Value)
+}
+
+func NewDebugger(bc Bytecode) *Debugger {
+	d := &Debugger{
+		vm:          NewVM(bc),
+		bc:          bc,
+		breakpoints: make(map[int]bool),
+		commands:    make(chan stepMode),
+		stops:       make(chan Stop),
+	}
+	d.vm.SetTracer(d)
+	return d
+}
+
+// SetOutput sets where failed hopes are reported, os.Stdout by default
+func (d *Debugger) SetOutput(w io.Writer) {
+	d.vm.out = w
+}
+
+// breakpoints may only change before Start and while paused
+func (d *Debugger) SetBreakpoint(line int) {
+	d.breakpoints[line] = true
+}
+
+func (d *Debugger) ClearBreakpoint(line int) {
+	delete(d.breakpoints, line)
+}
+
+// Breakpoints are the lines with a breakpoint, in order
+func (d *Debugger) Breakpoints() []int {
+	lines := []int{}
+	for line := range d.breakpoints {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// Start runs the program until the first pause,
+// with stopOnEntry before its first line
+func (d *Debugger) Start(stopOnEntry bool) Stop {
+	if d.started {
+		return d.last
+	}
+	d.started = true
+	d.mode = stepContinue
+	if stopOnEntry {
+		d.mode = stepInto
+	}
+	go func() {
+		err := d.vm.Run()
+		stop := Stop{Reason: StopExit, Function: "<main>", Err: err}
+		if err == nil {
+			stop.Result = d.vm.LastPopped()
+		}
+		d.stops <- stop
+	}()
+	return d.wait()
+}
+
+func (d *Debugger) Continue() Stop { return d.resume(stepContinue) }
+
+// StepInto stops at the next line, in a function it calls too
+func (d *Debugger) StepInto() Stop { return d.resume(stepInto) }
+
+// StepOver stops at the next line of this function or its callers
+func (d *Debugger) StepOver() Stop { return d.resume(stepOver) }
+
+// StepOut stops after the current function returns
+func (d *Debugger) StepOut() Stop { return d.resume(stepOut) }
+
+func (d *Debugger) resume(mode stepMode) Stop {
+	if !d.started {
+		return d.Start(false)
+	}
+	if d.last.Reason == StopExit {
+		return d.last
+	}
+	d.commands <- mode
+	return d.wait()
+}
+
+// Paused tells if the program started and hasn't exited yet
+func (d *Debugger) Paused() bool {
+	return d.started && d.last.Reason != StopExit
+}
+
+func (d *Debugger) wait() Stop {
+	d.last = <-d.stops
+	return d.last
+}
+
+// Step implements Tracer, it runs on the VM's goroutine
+func (d *Debugger) Step(fn *CompiledFunction, pc int, stack []Object) {
+	depth := len(d.vm.frames)
+	reason := ""
+	hope := ""
+	if Opcode(fn.Instructions[pc]) == OpHope && len(stack) >= 2 {
+		got, expected := stack[len(stack)-2], stack[len(stack)-1]
+		if !hopeEqual(expected, got) {
+			reason = StopHope
+			hope = fmt.Sprintf("want %v, got %v in the %d-th test case", expected, got, fn.Instructions.readUint8(pc+1))
+		}
+	}
+	if reason == "" && isLineStart(fn.Lines, pc) {
+		switch {
+		case d.breakpoints[lineAt(fn.Lines, pc)]:
+			reason = StopBreakpoint
+		case d.mode == stepInto,
+			d.mode == stepOver && depth <= d.depth:
+			reason = StopStep
+			if d.depth == 0 { // not paused yet
+				reason = StopEntry
+			}
+		}
+	}
+	// right after the return, the rest of the caller's line is still to run
+	if reason == "" && d.mode == stepOut && depth < d.depth {
+		reason = StopStep
+	}
+	if reason == "" {
+		return
+	}
+
+	d.stops <- Stop{Reason: reason, Function: fn.DisplayName(), Line: lineAt(fn.Lines, pc), Hope: hope}
+	d.mode = <-d.commands
+	d.depth = depth
+}
+
+// the first instruction of a line in the line table
+func isLineStart(lines []LineInfo, pc int) bool {
+	i := sort.Search(len(lines), func(i int) bool { return lines[i].Offset >= pc })
+	return i < len(lines) && lines[i].Offset == pc
+}
+
+// ====== inspection, while paused
+
+// CallStack is the paused call stack, the innermost call first
+func (d *Debugger) CallStack() []StackEntry {
+	stack := []StackEntry{}
+	for i := len(d.vm.frames) - 1; i >= 0; i-- {
+		stack = append(stack, StackEntry{Function: d.vm.frames[i].fn.DisplayName(), Line: d.frameLine(i)})
+	}
+	return stack
+}
+
+// the line frames[i] is at, callers wait at their OpCall
+func (d *Debugger) frameLine(i int) int {
+	f := d.vm.frames[i]
+	if i == len(d.vm.frames)-1 {
+		return d.last.Line
+	}
+	return lineAt(f.fn.Lines, d.vm.frames[i+1].ip-definitions[OpCall].Size())
+}
+
+// Locals are the parameters and locals of a frame of the call stack,
+// 0 is the innermost. The main program has none, its variables are globals
+func (d *Debugger) Locals(frame int) []Variable {
+	i := len(d.vm.frames) - 1 - frame
+	if i <= 0 || i >= len(d.vm.frames) {
+		return nil
+	}
+	f := d.vm.frames[i]
+	vars := []Variable{}
+	for slot := 0; slot < f.fn.NumLocals; slot++ {
+		name := fmt.Sprintf("local%d", slot)
+		if slot < len(f.fn.LocalNames) {
+			if f.fn.LocalNames[slot] == "" {
+				continue
+			}
+			name = f.fn.LocalNames[slot]
+		}
+		vars = append(vars, Variable{Name: name, Value: d.vm.stack[f.bp+slot]})
+	}
+	return vars
+}
+
+// Globals are the globals set so far
+func (d *Debugger) Globals() []Variable {
+	vars := []Variable{}
+	for i, name := range d.bc.globalNames {
+		if name != "" && d.vm.globals[i] != nil {
+			vars = append(vars, Variable{Name: name, Value: d.vm.globals[i]})
+		}
+	}
+	return vars
+}
+
+// Evaluate runs expr as if it were written in a frame of the call stack.
+// It sees the locals of the frame and the globals, it can call functions.
+// The variables it assigns are lost, changes to arrays and hashes are not
+func (d *Debugger) Evaluate(frame int, expr string) (Object, error) {
+	if !d.Paused() {
+		return nil, fmt.Errorf("the program is not paused")
+	}
+	parser := NewParser(NewLexer(strings.NewReader(expr)))
+	node, diags := parser.Parse(nil)
+	if len(diags) > 0 {
+		return nil, Diagnostics(diags)
+	}
+
+	// the globals keep their slots, the locals get the ones after them
+	symbolTable := NewGlobalSymbolTable(d.vm.builtins)
+	globals := make([]Object, VariableSize)
+	for i, name := range d.bc.globalNames {
+		symbol := symbolTable.Define(fmt.Sprintf("%d", i))
+		if name != "" {
+			symbolTable.store[name] = symbol
+		}
+		globals[i] = d.vm.globals[i]
+	}
+	for _, v := range d.Locals(frame) {
+		if symbolTable.size >= len(globals) {
+			break
+		}
+		globals[symbolTable.Define(v.Name).Index] = v.Value
+	}
+
+	constants := append([]Object{}, d.vm.constants...)
+	compiler := NewCompilerWithState(true, symbolTable, constants)
+	if err := compiler.Compile(node); err != nil {
+		return nil, err
+	}
+	vm := NewVMWithGlobals(compiler.Bytecode(), globals)
+	vm.builtins = d.vm.builtins
+	if err := vm.Run(); err != nil {
+		return nil, err
+	}
+	return vm.LastPopped(), nil
+}
@@ -0,0 +1,157 @@
+package interpreter
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const debugProgram = `total := 0
+add := func(x int, y int) int {
+	sum := x + y
+	sum
+}
+i := 0
+while i < 2 {
+	total = add(total, i)
+	i = i + 1
+}
+total`
+
+func TestDebugger_Breakpoints(t *testing.T) {
+	d := NewDebugger(compileString(t, debugProgram))
+	d.SetBreakpoint(4)
+
+	stop := d.Start(false)
+	if stop.Reason != StopBreakpoint || stop.Line != 4 || stop.Function != "add" {
+		t.Fatalf("got %+v, want a breakpoint at line 4 in add", stop)
+	}
+	if got := fmt.Sprint(d.Locals(0)); got != "[x = 0 y = 0 sum = 0]" {
+		t.Errorf("locals %s", got)
+	}
+	if got := fmt.Sprint(d.Globals()); got != "[total = 0 add = func add i = 0]" {
+		t.Errorf("globals %s", got)
+	}
+	if got := fmt.Sprint(d.CallStack()); got != "[add (line 4) <main> (line 8)]" {
+		t.Errorf("call stack %s", got)
+	}
+	result, err := d.Evaluate(0, "sum + total * 10 + add(i, 100)")
+	if err != nil || describeValue(result) != "100" {
+		t.Errorf("evaluate got %v, %v", result, err)
+	}
+
+	stop = d.Continue()
+	if stop.Reason != StopBreakpoint || fmt.Sprint(d.Locals(0)) != "[x = 0 y = 1 sum = 1]" {
+		t.Fatalf("got %+v with locals %v", stop, d.Locals(0))
+	}
+	d.ClearBreakpoint(4)
+	stop = d.Continue()
+	if stop.Reason != StopExit || stop.Err != nil || describeValue(stop.Result) != "1" {
+		t.Fatalf("got %+v, want the exit with 1", stop)
+	}
+	if d.Paused() || d.Continue().Reason != StopExit {
+		t.Errorf("the program goes on after the exit")
+	}
+}
+
+func TestDebugger_Stepping(t *testing.T) {
+	d := NewDebugger(compileString(t, debugProgram))
+
+	var got []string
+	step := func(name string, stop Stop) {
+		got = append(got, fmt.Sprintf("%s %s %s:%d", name, stop.Reason, stop.Function, stop.Line))
+	}
+	step("start", d.Start(true))
+	step("n", d.StepOver())
+	step("n", d.StepOver())
+	step("n", d.StepOver())
+	step("n", d.StepOver())
+	step("s", d.StepInto())
+	step("s", d.StepInto())
+	step("o", d.StepOut())
+	step("n", d.StepOver())
+
+	expected := []string{
+		"start entry <main>:1",
+		"n step <main>:2",
+		"n step <main>:6",
+		"n step <main>:7",
+		"n step <main>:8",
+		"s step add:3",
+		"s step add:4",
+		"o step <main>:8",
+		"n step <main>:9",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestDebugger_Hope(t *testing.T) {
+	input := `add := func(x int, y int) int {
+	x - y
+} hope {
+	1, 1 -> 0
+	1, 2 -> 3
+}
+add(2, 2)`
+	parser := NewParser(NewLexer(strings.NewReader(input)))
+	node, diags := parser.Parse(nil)
+	if diags != nil {
+		t.Fatal(diags)
+	}
+	compiler := NewCompilerWithState(false, NewGlobalSymbolTable(standardBuiltins), nil)
+	if err := compiler.Compile(node); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDebugger(compiler.Bytecode())
+	var out bytes.Buffer
+	d.SetOutput(&out)
+
+	stop := d.Start(false)
+	if stop.Reason != StopHope || stop.Line != 5 || stop.Hope != "want 3, got -1 in the 2-th test case" {
+		t.Fatalf("got %+v, want the second hope to fail", stop)
+	}
+	if result, err := d.Evaluate(0, "add(1, 2)"); err != nil || describeValue(result) != "-1" {
+		t.Errorf("evaluate got %v, %v", result, err)
+	}
+	stop = d.Continue()
+	if stop.Reason != StopExit || describeValue(stop.Result) != "0" {
+		t.Fatalf("got %+v, want the exit with 0", stop)
+	}
+	if out.String() != "want 3, got -1 in the 2-th test case\n" {
+		t.Errorf("output %q", out.String())
+	}
+}
+
+func TestDebugConsole(t *testing.T) {
+	d := NewDebugger(compileString(t, debugProgram))
+	in := strings.NewReader("b 3\nl\nc\nl\np x + y\nbt\n\nd 3\nn\nx\nc\n")
+	var out bytes.Buffer
+	StartDebugger(d, "sum.ho", debugProgram, in, &out)
+
+	expected := `debugging sum.ho, h for help
+(ho) breakpoints: [3]
+(ho) the program is not paused
+(ho) breakpoint at sum.ho:3 in add
+   3  sum := x + y
+(ho) x = 0
+y = 0
+sum = -
+(ho) 0
+(ho) #0 add (line 3)
+#1 <main> (line 8)
+(ho) breakpoint at sum.ho:3 in add
+   3  sum := x + y
+(ho) breakpoints: []
+(ho) step at sum.ho:4 in add
+   4  sum
+(ho) unknown command x, h for help
+(ho) exited with 1
+(ho) 
+`
+	if out.String() != expected {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), expected)
+	}
+}
@@ -0,0 +1,30 @@
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// a problem in the source code, found before it runs.
+// The parser collects them and goes on with the next statement,
+// so that one typo doesn't hide the next one.
+type Diagnostic struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (d *Diagnostic) Error() string {
+	return fmt.Sprintf("line %d:%d: %s", d.Line, d.Column, d.Message)
+}
+
+// all the problems in a program, one per line
+type Diagnostics []Diagnostic
+
+func (ds Diagnostics) Error() string {
+	lines := make([]string, len(ds))
+	for i := range ds {
+		lines[i] = ds[i].Error()
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,92 @@
+package interpreter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ====== disassembler
+// Disassemble writes the main program and then every function
+// among the constants: offsets, operands, the constants they load
+// and, when the bytecode has them, the source lines
+func Disassemble(w io.Writer, bc Bytecode) error {
+	var out bytes.Buffer
+	disassembleFunction(&out, "<main>", bc.instructions, bc.lines, bc.constants)
+	for idx, obj := range bc.constants {
+		fn, ok := obj.(*CompiledFunction)
+		if !ok {
+			continue
+		}
+		paras := make([]string, fn.NumParas)
+		for i := range paras {
+			paras[i] = "_"
+			if i < len(fn.ParaTypes) && fn.ParaTypes[i] != "" {
+				paras[i] = fn.ParaTypes[i]
+			}
+		}
+		header := fmt.Sprintf("func %s(%s), constant %d, %d locals",
+			fn.DisplayName(), strings.Join(paras, ", "), idx, fn.NumLocals)
+		out.WriteString("\n")
+		disassembleFunction(&out, header, fn.Instructions, fn.Lines, bc.constants)
+	}
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+func disassembleFunction(out *bytes.Buffer, header string, ins Instructions, lines []LineInfo, constants []Object) {
+	out.WriteString(header + ":\n")
+	last := -1
+	ins.disassemble(out, func(pc int) (string, string) {
+		// the line in front of the first instruction of each line
+		prefix := "     "
+		if line := lineAt(lines, pc); lines != nil && line != last {
+			prefix = fmt.Sprintf("%4d ", line)
+			last = line
+		}
+		return prefix, describeOperand(ins, pc, constants)
+	})
+}
+
+// the constant or builtin an instruction loads
+func describeOperand(ins Instructions, pc int, constants []Object) string {
+	switch Opcode(ins[pc]) {
+	case OpConstant:
+		if pc+2 < len(ins) {
+			return describeConstant(ins.readUint16(pc+1), constants)
+		}
+	case OpConstantWide:
+		if pc+4 < len(ins) {
+			return describeConstant(ins.readUint32(pc+1), constants)
+		}
+	case OpGetBuiltin:
+		if pc+1 < len(ins) {
+			if idx := ins.readUint8(pc + 1); idx < len(builtinNames) {
+				return builtinNames[idx]
+			}
+			return "no such builtin"
+		}
+	}
+	return ""
+}
+
+func describeConstant(idx int, constants []Object) string {
+	if idx < len(constants) {
+		return describeValue(constants[idx])
+	}
+	return "no such constant"
+}
+
+// strings quoted, so that "1" and 1 look different
+func describeValue(obj Object) string {
+	switch obj := obj.(type) {
+	case nil: // a local not set yet
+		return "-"
+	case *String:
+		return fmt.Sprintf("%q", obj.Value)
+	case *CompiledFunction:
+		return "func " + obj.DisplayName()
+	}
+	return obj.String()
+}
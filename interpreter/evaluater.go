@@ -1,29 +1,42 @@
 package interpreter
 
 import (
-	"log"
+	"fmt"
+	"math"
+	"math/big"
 )
 
 type Evaluater struct {
-	c   chan Statement
-	env *Environment
+	c        chan Statement
+	env      *Environment
+	builtins *Builtins
+
+	// the functions called and not returned yet, with the line
+	// each was called from, for the stack of a RuntimeError
+	calls []StackEntry
 }
 
 func NewEvaluater(c chan Statement) *Evaluater {
+	return NewEvaluaterWithBuiltins(c, standardBuiltins)
+}
+
+// NewEvaluaterWithBuiltins evaluates with the Go functions registered in builtins
+func NewEvaluaterWithBuiltins(c chan Statement, builtins *Builtins) *Evaluater {
 	return &Evaluater{c: c,
-		env: NewEnvironment(nil)}
+		env:      NewEnvironment(nil),
+		builtins: builtins}
 }
 func (e *Evaluater) Eval() Object {
-	var result Object
+	var result Object = NullObj
 	debugs := make([]Object, 0)
 	for node := range e.c {
-		log.Printf("receive item from channel ===========>> (%T, %v)", node, node)
+		debugLog.Printf("receive item from channel ===========>> (%T, %v)", node, node)
 		result = e.eval(node)
 		debugs = append(debugs, result)
 	}
 	// show all results
 	for i, item := range debugs {
-		log.Println("-- ", i+1, item.Type(), item.String(), "--")
+		debugLog.Println("-- ", i+1, item.Type(), item.String(), "--")
 		// log.Println(i, item)
 
 	}
@@ -38,14 +51,20 @@ func (e *Evaluater) eval(node Statement) Object {
 		if obj, err := e.env.Get(node.Key); err == nil {
 			result = obj
 		} else {
-			if bt, ok := builtins[node.Key]; ok {
+			if bt, ok := e.builtins.fns[node.Key]; ok {
 				result = bt
 			} else {
-				log.Panicln("eval IdentifierLiteral : ", err)
+				debugLog.Panicln("eval IdentifierLiteral : ", err)
 			}
 		}
 	case *IntegerLiteral:
 		result = &Integer{Value: node.Key}
+	case *NilLiteral:
+		result = NullObj
+	case *BigIntLiteral:
+		result = &BigInt{Value: node.Key}
+	case *FloatLiteral:
+		result = &Float{Value: node.Key}
 	case *StringLiteral:
 		result = &String{Value: node.Key}
 	case *BooleanLiteral:
@@ -58,53 +77,80 @@ func (e *Evaluater) eval(node Statement) Object {
 		// log.Println("Function literal", node)
 		paras := node.Parameters
 		body := node.Execute
-		result = &Function{Parameters: paras, Body: body, Env: e.env}
+		result = &Function{Parameters: paras, ParaTypes: node.ParaTypes, Body: body, Env: e.env, Name: node.Name}
 	case *ArrayLiteral:
 		result = e.evalArray(node)
+	case *HashLiteral:
+		result = e.evalHash(node)
+	case *IndexAssignExpression:
+		result = e.evalIndexAssign(node)
 
 	case *IndexExpression:
-		log.Println("index expression")
+		debugLog.Println("index expression")
 		left := e.eval(node.Left)
 		idx := e.eval(node.Index)
 		result = e.evalIndex(left, idx)
 	case *TernaryExpression:
 		result = e.evalTernary(node)
 	case *IfExpression:
-		log.Println("evalStatement ---> if", node)
+		debugLog.Println("evalStatement ---> if", node)
 
 		result = e.evalIf(node)
 	case *WhileExpression:
-		log.Println("evalStatement ---> While", node)
+		debugLog.Println("evalStatement ---> While", node)
 		result = e.evalWhile(node)
 
 	case *CallExpression:
 		fn := e.eval(node.Function)         // return function object
 		args := e.evalExprs(node.Arguments) // expressions
-		log.Println("------- fn args ---------")
-		log.Println(fn, "  ,  ", args)
-		result = e.applyFunction(fn, args)
+		debugLog.Println("------- fn args ---------")
+		debugLog.Println(fn, "  ,  ", args)
+		result = e.applyFunction(fn, args, node.Pos().Line)
 
 	case *UnaryExpression: // ! -
 		obj := e.eval(node.Right)
 		switch {
 		case node.Operator == MINUS && obj.Type() == INTEGER_OBJ:
-			return &Integer{Value: -obj.(*Integer).Value}
+			return negInt(obj.(*Integer).Value)
+
+		case node.Operator == MINUS && obj.Type() == BIGINT_OBJ:
+			return newInteger(new(big.Int).Neg(obj.(*BigInt).Value))
+
+		case node.Operator == MINUS && obj.Type() == FLOAT_OBJ:
+			return &Float{Value: -obj.(*Float).Value}
 
 		case node.Operator == BANG && obj.Type() == BOOLEAN_OBJ:
 			return &Boolean{Value: !obj.(*Boolean).Value}
 
 		default:
-			log.Panic("--- illegal unary expression ---")
-			log.Printf("%T, %v", node.Right, node.Right)
-			log.Printf("unary obj: %v", obj)
+			debugLog.Panic("--- illegal unary expression ---")
+			debugLog.Printf("%T, %v", node.Right, node.Right)
+			debugLog.Printf("unary obj: %v", obj)
 
 		}
 	case *InfixExpression:
 
 		left := e.eval(node.Left)
 		right := e.eval(node.Right)
-		log.Println("---- infix expression ----")
-		log.Printf("op= %v, left = %T %v, right = %T %v", node.Operator, left, left, right, right)
+		debugLog.Println("---- infix expression ----")
+		debugLog.Printf("op= %v, left = %T %v, right = %T %v", node.Operator, left, left, right, right)
+		if isNumber(left) && isNumber(right) &&
+			(left.Type() == FLOAT_OBJ || right.Type() == FLOAT_OBJ) {
+			return e.evalInfixExpressionFloat(node.Operator, toFloat(left), toFloat(right))
+		}
+		if isInteger(left) && isInteger(right) &&
+			(left.Type() == BIGINT_OBJ || right.Type() == BIGINT_OBJ) {
+			return e.evalInfixExpressionBigInt(node.Operator, toBig(left), toBig(right))
+		}
+		if left.Type() != right.Type() || left.Type() == NULL_OBJ {
+			// values of different types are never equal, nil only equals nil
+			switch node.Operator {
+			case EQ:
+				return &Boolean{Value: left.Type() == right.Type()}
+			case NEQ:
+				return &Boolean{Value: left.Type() != right.Type()}
+			}
+		}
 		if left.Type() == right.Type() {
 			switch left.Type() {
 			case INTEGER_OBJ:
@@ -119,10 +165,10 @@ func (e *Evaluater) eval(node Statement) Object {
 				return e.evalInfixExpressionBoolean(node.Operator, l, r)
 			}
 		} else {
-			log.Panic("different types in an expression")
+			debugLog.Panic("different types in an expression")
 		}
 	default:
-		log.Printf("evalStatement ---> illegal type - - %T", node)
+		debugLog.Printf("evalStatement ---> illegal type - - %T", node)
 		result = e.eval(node)
 		// case *TernaryStatement:
 		// 	log.Println("evaluater ---> Ternary")
@@ -134,20 +180,21 @@ func (e *Evaluater) eval(node Statement) Object {
 func (e *Evaluater) evalDefine(node *DefineExpression) Object {
 	obj := e.eval(node.Expr)
 	e.env.Set(node.Ident.Key, obj)
-	log.Printf("after Define \n")
+	debugLog.Printf("after Define \n")
 	for k, v := range e.env.store {
-		log.Println(k, v)
+		debugLog.Println(k, v)
 	}
-	log.Printf("store end \n")
-	return obj
+	debugLog.Printf("store end \n")
+	// a definition is a statement, it has no value
+	return NullObj
 }
 func (e *Evaluater) evalAssign(node *AssignExpression) Object {
 	if _, err := e.env.Get(node.Ident.Key); err != nil {
-		log.Panic(err)
+		debugLog.Panic(err)
 	}
 	obj := e.eval(node.Expr)
 	e.env.Set(node.Ident.Key, obj)
-	return obj
+	return NullObj
 }
 
 func (e *Evaluater) evalArray(node *ArrayLiteral) Object {
@@ -156,9 +203,18 @@ func (e *Evaluater) evalArray(node *ArrayLiteral) Object {
 	return array
 }
 
+func (e *Evaluater) evalHash(node *HashLiteral) Object {
+	hash := NewHash()
+	for i, k := range node.Keys {
+		key := e.eval(k)
+		hash.Pairs[hashKey(key)] = HashPair{Key: key, Value: e.eval(node.Values[i])}
+	}
+	return hash
+}
+
 func (e *Evaluater) evalIndex(left, idx Object) Object {
 	var result Object
-	log.Printf("evalIndex : (%v , %v)", left, idx)
+	debugLog.Printf("evalIndex : (%v , %v)", left, idx)
 	switch {
 	case left.Type() == ARRAY_OBJ && idx.Type() == INTEGER_OBJ:
 		elmts := left.(*Array).Elements
@@ -166,26 +222,57 @@ func (e *Evaluater) evalIndex(left, idx Object) Object {
 		if l := len(elmts); i >= 0 && i < l {
 			result = elmts[i]
 		} else {
-			log.Panicf("array index out of range! expect [%d, %d), got %d\n", 0, l, i)
+			debugLog.Panicf("array index out of range! expect [%d, %d), got %d\n", 0, l, i)
+		}
+	case left.Type() == HASH_OBJ:
+		// a missing key is nil
+		result = NullObj
+		if pair, ok := left.(*Hash).Pairs[hashKey(idx)]; ok {
+			result = pair.Value
 		}
+	default:
+		debugLog.Panicf("index operator not supported: %s[%s]", left.Type(), idx.Type())
 	}
 	return result
 }
+
+func (e *Evaluater) evalIndexAssign(node *IndexAssignExpression) Object {
+	left := e.eval(node.Target.Left)
+	idx := e.eval(node.Target.Index)
+	obj := e.eval(node.Expr)
+	switch {
+	case left.Type() == ARRAY_OBJ && idx.Type() == INTEGER_OBJ:
+		elmts := left.(*Array).Elements
+		i := idx.(*Integer).Value
+		if l := len(elmts); i >= 0 && i < l {
+			elmts[i] = obj
+		} else {
+			debugLog.Panicf("array index out of range! expect [%d, %d), got %d\n", 0, l, i)
+		}
+	case left.Type() == HASH_OBJ:
+		left.(*Hash).Pairs[hashKey(idx)] = HashPair{Key: idx, Value: obj}
+	default:
+		debugLog.Panicf("index assignment not supported: %s[%s]", left.Type(), idx.Type())
+	}
+	return NullObj
+}
+
+// an if without a taken branch is nil
 func (e *Evaluater) evalIf(node *IfExpression) Object {
 	for i, cnd := range node.conditions {
 		if e.isTure(cnd) {
 			return e.evalBlock(node.executes[i])
 		}
 	}
-	return nil
+	return NullObj
 }
 
+// a while loop is run for its effects, it is nil
 func (e *Evaluater) evalWhile(node *WhileExpression) Object {
-	var result Object
 	for e.isTure(node.Condition) {
-		result = e.evalBlock(node.Execute)
+		e.evalBlock(node.Execute)
 	}
-	return result
+	return NullObj
 }
 func (e *Evaluater) evalTernary(node *TernaryExpression) Object {
 	if e.isTure(node.condition) {
@@ -194,8 +281,9 @@ func (e *Evaluater) evalTernary(node *TernaryExpression) Object {
 	return e.eval(node.right)
 }
 
+// a block is its last statement, an empty block is nil
 func (e *Evaluater) evalBlock(node *BlockExpression) Object {
-	var result Object
+	var result Object = NullObj
 	for _, stmt := range node.Statements {
 		result = e.eval(stmt)
 	}
@@ -206,13 +294,13 @@ func (e *Evaluater) evalInfixExpressionInteger(op string, l, r int) Object {
 
 	switch op {
 	case "+":
-		return &Integer{Value: l + r}
+		return addInt(l, r)
 	case "-":
-		return &Integer{Value: l - r}
+		return subInt(l, r)
 	case "/":
-		return &Integer{Value: l / r}
+		return divInt(l, r)
 	case "*":
-		return &Integer{Value: l * r}
+		return mulInt(l, r)
 	case "%":
 		return &Integer{Value: l % r}
 	case ">":
@@ -228,7 +316,69 @@ func (e *Evaluater) evalInfixExpressionInteger(op string, l, r int) Object {
 	case "!=":
 		return &Boolean{Value: l != r}
 	default:
-		log.Panic("illegal operator for integer")
+		debugLog.Panic("illegal operator for integer")
+	}
+	return nil
+}
+
+func (e *Evaluater) evalInfixExpressionBigInt(op string, l, r *big.Int) Object {
+
+	switch op {
+	case "+":
+		return newInteger(new(big.Int).Add(l, r))
+	case "-":
+		return newInteger(new(big.Int).Sub(l, r))
+	case "/":
+		return newInteger(new(big.Int).Quo(l, r))
+	case "*":
+		return newInteger(new(big.Int).Mul(l, r))
+	case "%":
+		return newInteger(new(big.Int).Rem(l, r))
+	case ">":
+		return &Boolean{Value: l.Cmp(r) > 0}
+	case "<":
+		return &Boolean{Value: l.Cmp(r) < 0}
+	case ">=":
+		return &Boolean{Value: l.Cmp(r) >= 0}
+	case "<=":
+		return &Boolean{Value: l.Cmp(r) <= 0}
+	case "==":
+		return &Boolean{Value: l.Cmp(r) == 0}
+	case "!=":
+		return &Boolean{Value: l.Cmp(r) != 0}
+	default:
+		debugLog.Panic("illegal operator for integer")
+	}
+	return nil
+}
+
+func (e *Evaluater) evalInfixExpressionFloat(op string, l, r float64) Object {
+
+	switch op {
+	case "+":
+		return &Float{Value: l + r}
+	case "-":
+		return &Float{Value: l - r}
+	case "/":
+		return &Float{Value: l / r}
+	case "*":
+		return &Float{Value: l * r}
+	case "%":
+		return &Float{Value: math.Mod(l, r)}
+	case ">":
+		return &Boolean{Value: l > r}
+	case "<":
+		return &Boolean{Value: l < r}
+	case ">=":
+		return &Boolean{Value: l >= r}
+	case "<=":
+		return &Boolean{Value: l <= r}
+	case "==":
+		return &Boolean{Value: l == r}
+	case "!=":
+		return &Boolean{Value: l != r}
+	default:
+		debugLog.Panic("illegal operator for float")
 	}
 	return nil
 }
@@ -240,7 +390,7 @@ func (e *Evaluater) evalInfixExpressionBoolean(op string, l, r bool) Object {
 	case "!=":
 		return &Boolean{Value: l != r}
 	default:
-		log.Panic("illegal operator for integer")
+		debugLog.Panic("illegal operator for integer")
 	}
 	return nil
 }
@@ -250,44 +400,85 @@ func (e *Evaluater) evalInfixExpressionString(op, l, r string) Object {
 	case "+":
 		return &String{Value: l + r}
 	default:
-		log.Panic("illegal operator for string")
+		debugLog.Panic("illegal operator for string")
 	}
 	return nil
 }
 
 func (e *Evaluater) evalExprs(exprs []Expression) []Object {
 	objs := []Object{}
-	log.Println("---- eval Exprs - - -")
-	log.Println(exprs)
+	debugLog.Println("---- eval Exprs - - -")
+	debugLog.Println(exprs)
 	for _, expr := range exprs {
 		objs = append(objs, e.eval(expr))
 	}
-	log.Println(objs)
+	debugLog.Println(objs)
 
 	return objs
 }
 
-func (e *Evaluater) applyFunction(fn Object, args []Object) Object {
+func (e *Evaluater) applyFunction(fn Object, args []Object, line int) Object {
 	var obj Object
-	log.Println("--------- apply function --------")
-	log.Println("fn ", fn, " - ", "args ", args)
+	debugLog.Println("--------- apply function --------")
+	debugLog.Println("fn ", fn, " - ", "args ", args)
 	switch fn := fn.(type) {
 	case *Function:
+		name := fn.Name
+		if name == "" {
+			name = "<anonymous>"
+		}
+		if err := checkArguments(name, len(fn.Parameters), fn.ParaTypes, args); err != nil {
+			debugLog.Panic(err)
+		}
+		e.calls = append(e.calls, StackEntry{Function: name, Line: line})
 		e.env = NewFunctionEnvirontment(e.env, fn, args)
 		for k, v := range e.env.store {
-			log.Println(k, v)
+			debugLog.Println(k, v)
 		}
 		obj = e.evalBlock(fn.Body)
 		e.env = e.env.outter
+		e.calls = e.calls[:len(e.calls)-1]
 	case *Builtin:
-		log.Printf("%T %v", fn, fn)
+		debugLog.Printf("%T %v", fn, fn)
 
-		obj = fn.Fn(args...)
+		obj = e.callBuiltin(fn, args, line)
 
 	}
 	return obj
 }
 
+// a builtin that fails, a registered Go function returning an error,
+// panics with the *RuntimeError the VM would return
+func (e *Evaluater) callBuiltin(fn *Builtin, args []Object, line int) Object {
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(error)
+			if !ok {
+				err = fmt.Errorf("%v", r)
+			}
+			panic(e.runtimeError(line, err))
+		}
+	}()
+	return fn.Fn(args...)
+}
+
+// err at line of the innermost function, with the Ho call stack
+func (e *Evaluater) runtimeError(line int, err error) *RuntimeError {
+	stack := []StackEntry{}
+	for i := len(e.calls) - 1; i >= 0; i-- {
+		stack = append(stack, StackEntry{Function: e.calls[i].Function, Line: line})
+		line = e.calls[i].Line
+	}
+	stack = append(stack, StackEntry{Function: "<main>", Line: line})
+	return &RuntimeError{
+		Message:  err.Error(),
+		Err:      err,
+		Line:     stack[0].Line,
+		Function: stack[0].Function,
+		Stack:    stack,
+	}
+}
+
 // ==================== helper functions
 func (e *Evaluater) isTure(node ASTNode) bool {
 	res := e.eval(node).(*Boolean)
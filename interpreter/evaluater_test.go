@@ -1,6 +1,7 @@
 package interpreter
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 )
@@ -178,3 +179,164 @@ func TestEvaluater_Eval9(t *testing.T) {
 	e := NewEvaluater(c)
 	e.Eval()
 }
+
+func TestEvaluater_Hash(t *testing.T) {
+	input := `
+	h := {"a": 1, 2: "two", true: [1, 2]}
+	h["b"] = h["a"] + 1
+	h[true][1] = 3
+	delete(h, 2)
+	[h, keys(h), has(h, "b"), h["missing"]]
+	`
+	parser := NewParser(NewLexer(strings.NewReader(input)))
+	c := make(chan Statement)
+	go parser.Parse(c)
+	e := NewEvaluater(c)
+	want := "[{true: [1, 3], a: 1, b: 2}, [true, a, b], true, nil]"
+	if got := e.Eval().String(); got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
+
+func TestEvaluater_Float(t *testing.T) {
+	input := `
+	area := func(r float) {
+		3.14159 * r * r
+	}
+	results := [area(2), 1 + 0.5, -2.5, 1.0 == 1, float("0.25") * 4]
+	results
+	`
+	parser := NewParser(NewLexer(strings.NewReader(input)))
+	c := make(chan Statement)
+	go parser.Parse(c)
+	e := NewEvaluater(c)
+	want := "[12.56636, 1.5, -2.5, true, 1.0]"
+	if got := e.Eval().String(); got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
+
+func TestEvaluater_BigInt(t *testing.T) {
+	input := `
+	fact := func(n) {
+		n <= 1 ? 1 : n * fact(n - 1)
+	}
+	big := fact(25)
+	results := [big, big / fact(24), 9223372036854775807 + 1 - 1, -(-9223372036854775807 - 1)]
+	results
+	`
+	parser := NewParser(NewLexer(strings.NewReader(input)))
+	c := make(chan Statement)
+	go parser.Parse(c)
+	e := NewEvaluater(c)
+	want := "[15511210043330985984000000, 25, 9223372036854775807, 9223372036854775808]"
+	if got := e.Eval().String(); got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
+
+func TestEvaluater_Nil(t *testing.T) {
+	input := `
+	sign := func(x) {
+		if x > 0 {
+			1
+		} else if x < 0 {
+			-1
+		}
+	}
+	n := 0
+	while n < 3 {
+		n = n + 1
+	}
+	results := [sign(5), sign(0), sign(0) == nil, nil != 1, n]
+	results
+	`
+	parser := NewParser(NewLexer(strings.NewReader(input)))
+	c := make(chan Statement)
+	go parser.Parse(c)
+	e := NewEvaluater(c)
+	want := "[1, nil, true, true, 3]"
+	if got := e.Eval().String(); got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
+
+func TestEvaluater_ArgumentCheck(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"add := func(x int, y int) {\nx + y\n}\nadd(1)", "add wants 2 arguments, got 1"},
+		{"add := func(x int, y int) {\nx + y\n}\nadd(1, 2, 3)", "add wants 2 arguments, got 3"},
+		{"add := func(x int, y int) {\nx + y\n}\nadd(1, \"a\")", "argument 2 of add must be int, got string"},
+		{"half := func(x float) {\nx / 2\n}\nhalf(true)", "argument 1 of half must be float, got bool"},
+	}
+	for _, tt := range tests {
+		parser := NewParser(NewLexer(strings.NewReader(tt.input)))
+		c := make(chan Statement)
+		go parser.Parse(c)
+		e := NewEvaluater(c)
+		func() {
+			defer func() {
+				if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), tt.want) {
+					t.Errorf("%q: want panic %q, got %v", tt.input, tt.want, r)
+				}
+			}()
+			e.Eval()
+		}()
+		// let the parser finish
+		for range c {
+		}
+	}
+}
+
+func TestEvaluater_RegisterFunc(t *testing.T) {
+	b := NewBuiltins()
+	if err := b.RegisterFunc("double", func(x int) int { return 2 * x }); err != nil {
+		t.Fatal(err)
+	}
+	parser := NewParser(NewLexer(strings.NewReader("x := 21\ndouble(x)")))
+	c := make(chan Statement)
+	go parser.Parse(c)
+	if got := NewEvaluaterWithBuiltins(c, b).Eval(); got.String() != "42" {
+		t.Errorf("want 42, got %v", got)
+	}
+}
+
+// a registered function that returns an error fails as it does on the VM
+func TestEvaluater_RegisterFuncError(t *testing.T) {
+	b := NewBuiltins()
+	if err := b.RegisterFunc("rate", func(name string) (float64, error) {
+		return 0, fmt.Errorf("no rate for %s", name)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	input := "f := func(name) {\nrate(name)\n}\nf(\"vat\")"
+	want := "runtime error at line 2 in f: rate: no rate for vat\n\tat f (line 2)\n\tat <main> (line 4)"
+
+	parser := NewParser(NewLexer(strings.NewReader(input)))
+	c := make(chan Statement)
+	go parser.Parse(c)
+	func() {
+		defer func() {
+			re, ok := recover().(*RuntimeError)
+			if !ok || re.Error() != want {
+				t.Errorf("want panic %q, got %v", want, re)
+			}
+		}()
+		NewEvaluaterWithBuiltins(c, b).Eval()
+	}()
+	for range c {
+	}
+
+	node, _ := NewParser(NewLexer(strings.NewReader(input))).Parse(nil)
+	compiler := NewCompilerWithState(true, NewGlobalSymbolTable(b), nil)
+	if err := compiler.Compile(node); err != nil {
+		t.Fatal(err)
+	}
+	vm := NewVM(compiler.Bytecode())
+	vm.builtins = b
+	if err := vm.Run(); err == nil || err.Error() != want {
+		t.Errorf("VM: want %q, got %v", want, err)
+	}
+}
@@ -1,6 +1,10 @@
 package interpreter
 
-import "encoding/binary"
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
 
 type Instructions []byte
 
@@ -12,3 +16,49 @@ func (i Instructions) readUint16(pos int) int {
 	result := binary.BigEndian.Uint16(i[pos:])
 	return int(result)
 }
+
+func (i Instructions) readUint32(pos int) int {
+	return int(binary.BigEndian.Uint32(i[pos:]))
+}
+
+// String disassembles the instructions, one per line with its offset
+func (i Instructions) String() string {
+	var out bytes.Buffer
+	i.disassemble(&out, func(pc int) (string, string) { return "", "" })
+	return out.String()
+}
+
+// writes the instructions, annotate gives the text in front of
+// and the comment behind the one at pc.
+// Broken bytes are reported and skipped
+func (i Instructions) disassemble(out *bytes.Buffer, annotate func(pc int) (string, string)) {
+	for pc := 0; pc < len(i); {
+		prefix, comment := annotate(pc)
+		out.WriteString(prefix)
+		def, err := Lookup(Opcode(i[pc]))
+		if err != nil {
+			fmt.Fprintf(out, "%04d ERROR: %v\n", pc, err)
+			pc++
+			continue
+		}
+		if pc+def.Size() > len(i) {
+			fmt.Fprintf(out, "%04d ERROR: %s cut off\n", pc, def.Name)
+			return
+		}
+		operands, _ := ReadOperands(def, i[pc+1:])
+		fmt.Fprintf(out, "%04d %s\n", pc, formatInstruction(def, operands, comment))
+		pc += def.Size()
+	}
+}
+
+// "OpConstant 1          ; comment"
+func formatInstruction(def *Definition, operands []int, comment string) string {
+	text := def.Name
+	for _, operand := range operands {
+		text += fmt.Sprintf(" %d", operand)
+	}
+	if comment != "" {
+		text = fmt.Sprintf("%-20s ; %s", text, comment)
+	}
+	return text
+}
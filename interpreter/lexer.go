@@ -4,12 +4,13 @@ import (
 	"bufio"
 	"fmt"
 	"io"
-	"log"
 	"regexp"
+	"strings"
+	"unicode/utf8"
 )
 
 // const regexPat = `\s*((//.*)|([0-9]+)|("(\\"|\\\\|\\n|[^"])*")|([A-Za-z]\w*)|(\+|-|\*|/|%|==|:=|=|!=|>=|<=|<|>|&&|\|\||\\n|\?|:|\[|\]|{|}|,)|[[:punct:]])?`
-const regexPat = `\s*((//.*)|([0-9]+)|("((\\"|\\\\|\\n|[^"])*)")|([A-Za-z]\w*)|(->|\+|-|\*|/|%|==|:=|=|!=|>=|<=|<|>|!|&&|\|\||\\n|\?|:|\[|\]|{|}|,|\(|\))|[[:punct:]])?`
+const regexPat = `\s*((//.*)|([0-9]+(?:\.[0-9]+)?(?:[eE][+-]?[0-9]+)?)|("((\\"|\\\\|\\n|[^"])*)")|([A-Za-z]\w*)|(->|\+|-|\*|/|%|==|:=|=|!=|>=|<=|<|>|!|&&|\|\||\\n|\?|:|\[|\]|{|}|,|\(|\))|[[:punct:]])?`
 
 type Lexer struct {
 	pat     *regexp.Regexp // regular expression
@@ -17,10 +18,17 @@ type Lexer struct {
 	queue   []Token // list of tokens
 	lineNo  int
 	hasMore bool
+	file    string // for positions, may be empty
 }
 
 func NewLexer(in io.Reader) *Lexer {
+	return NewFileLexer("", in)
+}
+
+// the positions of the nodes parsed from it name the file
+func NewFileLexer(file string, in io.Reader) *Lexer {
 	return &Lexer{
+		file:    file,
 		pat:     regexp.MustCompile(regexPat),
 		scanner: bufio.NewScanner(in),
 		queue:   make([]Token, 0),
@@ -44,10 +52,13 @@ func (l *Lexer) Readline() {
 	low := 0
 	for low < len(line) {
 		if s := l.pat.FindString(line[low:]); s != "" { // a token is matched
+			l.AddToken(s, low)
 			low += len(s)
-			l.AddToken(s)
 		} else {
-			log.Fatalln("bad token at line ", l.lineNo)
+			// not even punctuation, e.g. a non-ASCII letter
+			_, size := utf8.DecodeRuneInString(line[low:])
+			l.queue = append(l.queue, NewIllegalToken(l.lineNo, low+1, line[low:low+size]))
+			low += size
 		}
 	}
 	l.queue = append(l.queue, EOL)
@@ -80,7 +91,8 @@ func (l *Lexer) Read() Token {
 
 }
 
-func (l *Lexer) AddToken(str string) {
+// str was matched at byte offset low of the current line
+func (l *Lexer) AddToken(str string, low int) {
 
 	matches := l.pat.FindAllStringSubmatch(str, -1)[0]
 	// 2 comment
@@ -100,26 +112,33 @@ func (l *Lexer) AddToken(str string) {
 	if m1 == "" || matches[2] != "" { // empty or \n or comment
 		return
 	}
+	col := low + len(str) - len(m1) + 1
 	var tk Token
 	if matches[3] != "" { // number
-		tk = NewNumToken(l.lineNo, matches[3])
-	} else if matches[5] != "" { // string
+		if strings.ContainsAny(matches[3], ".eE") {
+			tk = NewFloatToken(l.lineNo, col, matches[3])
+		} else {
+			tk = NewNumToken(l.lineNo, col, matches[3])
+		}
+	} else if matches[4] != "" { // string, "" is a string too
 		// tk = NewStrToken(l.lineNo, matches[5])
-		tk = NewStrToken(l.lineNo, l.toStringLiteral(matches[5]))
+		tk = NewStrToken(l.lineNo, col, l.toStringLiteral(matches[5]))
 	} else if matches[7] != "" { // identifier
 		if matches[7] == TRUE || matches[7] == FALSE {
-			tk = NewBooleanToken(l.lineNo, matches[7])
+			tk = NewBooleanToken(l.lineNo, col, matches[7])
 			goto Add
 		}
-		for _, reserved := range []string{IF, WHILE, FUNCTION, TRUE, FALSE, HOPE, FUZZING} {
+		for _, reserved := range []string{IF, WHILE, FUNCTION, TRUE, FALSE, HOPE, FUZZING, NIL} {
 			if matches[7] == reserved {
-				tk = NewReservedToken(l.lineNo, reserved)
+				tk = NewReservedToken(l.lineNo, col, reserved)
 				goto Add
 			}
 		}
-		tk = NewIdToken(l.lineNo, matches[7])
+		tk = NewIdToken(l.lineNo, col, matches[7])
 	} else if matches[8] != "" {
-		tk = NewOpToken(l.lineNo, matches[8])
+		tk = NewOpToken(l.lineNo, col, matches[8])
+	} else { // punctuation Ho doesn't use, or an unterminated string
+		tk = NewIllegalToken(l.lineNo, col, m1)
 	}
 Add:
 	l.queue = append(l.queue, tk)
@@ -23,3 +23,40 @@ func TestLexer_Read(t *testing.T) {
 		log.Println(tk)
 	}
 }
+
+func TestLexer_Float(t *testing.T) {
+	input := `1 2.5 1e9 3.0E-2 2e x`
+	lexer := NewLexer(strings.NewReader(input))
+	want := []struct{ typ, literal string }{
+		{INTEGER, "1"}, {FLOAT, "2.5"}, {FLOAT, "1e9"}, {FLOAT, "3.0E-2"},
+		{INTEGER, "2"}, {IDENTIFIER, "e"}, {IDENTIFIER, "x"},
+	}
+	for _, w := range want {
+		tk := lexer.Read()
+		if tk.Type() != w.typ || tk.Literal() != w.literal {
+			t.Errorf("want <%s %s>, got <%s %s>", w.typ, w.literal, tk.Type(), tk.Literal())
+		}
+	}
+}
+
+func TestLexer_Column(t *testing.T) {
+	input := "x := \"\"\n  f(a, 1.5) @ é"
+	lexer := NewLexer(strings.NewReader(input))
+	want := []struct {
+		typ, literal string
+		line, column int
+	}{
+		{IDENTIFIER, "x", 1, 1}, {OPERATOR, ":=", 1, 3}, {STRING, "", 1, 6},
+		{"helper", "EOL", 0, 0},
+		{IDENTIFIER, "f", 2, 3}, {OPERATOR, "(", 2, 4}, {IDENTIFIER, "a", 2, 5},
+		{OPERATOR, ",", 2, 6}, {FLOAT, "1.5", 2, 8}, {OPERATOR, ")", 2, 11},
+		{ILLEGAL, "@", 2, 13}, {ILLEGAL, "é", 2, 15},
+	}
+	for _, w := range want {
+		tk := lexer.Read()
+		if tk.Type() != w.typ || tk.Literal() != w.literal || tk.LineNumber() != w.line || tk.Column() != w.column {
+			t.Errorf("want <%s %s> at %d:%d, got <%s %s> at %d:%d", w.typ, w.literal, w.line, w.column,
+				tk.Type(), tk.Literal(), tk.LineNumber(), tk.Column())
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package interpreter
+
+import "fmt"
+
+// ====== limits
+// Limits bound what an untrusted program may use, 0 means no limit.
+// The stack can't grow past StackSize whatever MaxStack says
+type Limits struct {
+	MaxSteps       int // instructions executed
+	MaxCallDepth   int // calls in progress
+	MaxStack       int // stack slots, for values and locals
+	MaxAllocations int // array elements, hash pairs, string bytes and big integer words created
+}
+
+// LimitError is what a program over one of its Limits fails with,
+// the *RuntimeError returned by Run wraps it
+type LimitError struct {
+	Limit string // "steps", "call depth", "stack" or "allocations"
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s limit of %d exceeded", e.Limit, e.Max)
+}
+
+// how often RunContext looks at its context, in instructions
+const cancelCheckInterval = 1024
+
+// the size charged against MaxAllocations for values the VM created
+func allocationSize(objs ...Object) int {
+	size := 0
+	for _, obj := range objs {
+		switch obj := obj.(type) {
+		case *Array:
+			size += len(obj.Elements)
+		case *Hash:
+			size += len(obj.Pairs)
+		case *String:
+			size += len(obj.Value)
+		case *BigInt:
+			size += len(obj.Value.Bits())
+		}
+	}
+	return size
+}
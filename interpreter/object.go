@@ -3,7 +3,10 @@ package interpreter
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"math/big"
 	"math/rand"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -16,12 +19,15 @@ func init() {
 const (
 	IDENTIFIER_OBJ = "IDENTIFIER" // add, x, y, ...
 	INTEGER_OBJ    = "INTEGER"    // 1343456
+	BIGINT_OBJ     = "BIGINT"     // an integer that doesn't fit in INTEGER
 	STRING_OBJ     = "STRING"     // "foobar"
 	BOOLEAN_OBJ    = "BOOLEAN"
-	NUMBER_OBJ     = "NUMBER"
+	FLOAT_OBJ      = "FLOAT"
 	FUNCTION_OBJ   = "FUNCTION"
 	ARRAY_OBJ      = "ARRAY"
+	HASH_OBJ       = "HASH"
 	BUILTIN_OBJ    = "BUILTIN"
+	NULL_OBJ       = "NULL"
 
 	// compiler
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION_OBJ"
@@ -33,10 +39,33 @@ type Object interface {
 }
 
 // ================ integer
+// Integer, String and Boolean values never change once made,
+// the VM shares them: constants, cached small integers, True and False
 type Integer struct {
 	Value int
 }
 
+// the integers loops and indexes count with are made once
+const (
+	smallIntMin = -128
+	smallIntMax = 1023
+)
+
+var smallInts = func() []Integer {
+	ints := make([]Integer, smallIntMax-smallIntMin+1)
+	for i := range ints {
+		ints[i].Value = smallIntMin + i
+	}
+	return ints
+}()
+
+func newInt(v int) *Integer {
+	if v >= smallIntMin && v <= smallIntMax {
+		return &smallInts[v-smallIntMin]
+	}
+	return &Integer{Value: v}
+}
+
 func (i *Integer) Type() string {
 	return INTEGER_OBJ
 }
@@ -44,6 +73,131 @@ func (i *Integer) String() string {
 	return strconv.Itoa(i.Value)
 }
 
+// ================ big integer
+// an integer result that would overflow Integer is promoted to BigInt,
+// a BigInt result that fits is demoted back, so that the same value
+// always has the same representation
+type BigInt struct {
+	Value *big.Int
+}
+
+func (b *BigInt) Type() string {
+	return BIGINT_OBJ
+}
+func (b *BigInt) String() string {
+	return b.Value.String()
+}
+
+func newInteger(v *big.Int) Object {
+	if v.IsInt64() && int64(int(v.Int64())) == v.Int64() {
+		return newInt(int(v.Int64()))
+	}
+	return &BigInt{Value: v}
+}
+
+func isInteger(obj Object) bool {
+	t := obj.Type()
+	return t == INTEGER_OBJ || t == BIGINT_OBJ
+}
+
+func toBig(obj Object) *big.Int {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(int64(obj.Value))
+	case *BigInt:
+		return obj.Value
+	}
+	return new(big.Int)
+}
+
+// checked integer arithmetic, falls back to math/big on overflow
+
+func addInt(l, r int) Object {
+	s := l + r
+	if (r > 0 && s < l) || (r < 0 && s > l) {
+		return newInteger(new(big.Int).Add(big.NewInt(int64(l)), big.NewInt(int64(r))))
+	}
+	return newInt(s)
+}
+
+func subInt(l, r int) Object {
+	d := l - r
+	if (r < 0 && d < l) || (r > 0 && d > l) {
+		return newInteger(new(big.Int).Sub(big.NewInt(int64(l)), big.NewInt(int64(r))))
+	}
+	return newInt(d)
+}
+
+func mulInt(l, r int) Object {
+	p := l * r
+	if (l == -1 && r == math.MinInt) || (r == -1 && l == math.MinInt) || (l != 0 && p/l != r) {
+		return newInteger(new(big.Int).Mul(big.NewInt(int64(l)), big.NewInt(int64(r))))
+	}
+	return newInt(p)
+}
+
+// the only overflow is MinInt / -1
+func divInt(l, r int) Object {
+	if l == math.MinInt && r == -1 {
+		return newInteger(new(big.Int).Neg(big.NewInt(int64(l))))
+	}
+	return newInt(l / r)
+}
+
+func negInt(v int) Object {
+	if v == math.MinInt {
+		return newInteger(new(big.Int).Neg(big.NewInt(int64(v))))
+	}
+	return newInt(-v)
+}
+
+// ================ float
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() string {
+	return FLOAT_OBJ
+}
+
+// always looks like a float, 2.0 instead of 2
+func (f *Float) String() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+// integers and floats are numbers, an integer is promoted to float
+// when it meets a float
+func isNumber(obj Object) bool {
+	return isInteger(obj) || obj.Type() == FLOAT_OBJ
+}
+
+func toFloat(obj Object) float64 {
+	switch obj := obj.(type) {
+	case *Integer:
+		return float64(obj.Value)
+	case *BigInt:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f
+	case *Float:
+		return obj.Value
+	}
+	return 0
+}
+
+// floats are equal if they are close enough, relative to their size
+func floatEqual(a, b float64) bool {
+	if a == b {
+		return true
+	}
+	diff := math.Abs(a - b)
+	scale := math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+	return diff <= 1e-9*scale
+}
+
 // ================ string
 type String struct {
 	Value string
@@ -57,10 +211,23 @@ func (s String) String() string {
 }
 
 // ================= bool
+// there are only True and False, but a Boolean made elsewhere works too
 type Boolean struct {
 	Value bool
 }
 
+var (
+	True  = &Boolean{Value: true}
+	False = &Boolean{Value: false}
+)
+
+func nativeBool(b bool) *Boolean {
+	if b {
+		return True
+	}
+	return False
+}
+
 func (b Boolean) Type() string {
 	return BOOLEAN_OBJ
 }
@@ -72,6 +239,20 @@ func (b Boolean) String() string {
 	return res
 }
 
+// ================= null
+// the value of an if without a taken branch, a while loop,
+// an empty block ... there is only one of it
+type Null struct{}
+
+var NullObj = &Null{}
+
+func (n *Null) Type() string {
+	return NULL_OBJ
+}
+func (n *Null) String() string {
+	return "nil"
+}
+
 // =================== builtin functions
 type BuiltinFunction func(...Object) Object
 
@@ -88,8 +269,10 @@ func (b *Builtin) String() string { return "builtin function" }
 
 type Function struct {
 	Parameters []*IdentifierLiteral
+	ParaTypes  []string
 	Body       *BlockExpression
 	Env        *Environment
+	Name       string
 }
 
 func (f *Function) Type() string { return FUNCTION_OBJ }
@@ -133,11 +316,94 @@ func (a *Array) String() string {
 	return out.String()
 }
 
+//	============== Hash
+
+// objects that can be used as a key of a hash
+type Hashable interface {
+	Object
+	HashKey() HashKey
+}
+
+// the same value of the same type gives the same key
+type HashKey struct {
+	Type  string
+	Value string
+}
+
+func (i *Integer) HashKey() HashKey {
+	return HashKey{Type: i.Type(), Value: strconv.Itoa(i.Value)}
+}
+
+func (s String) HashKey() HashKey {
+	return HashKey{Type: s.Type(), Value: s.Value}
+}
+
+func (b *BigInt) HashKey() HashKey {
+	return HashKey{Type: b.Type(), Value: b.String()}
+}
+
+func (b Boolean) HashKey() HashKey {
+	return HashKey{Type: b.Type(), Value: b.String()}
+}
+
+type HashPair struct {
+	Key   Object
+	Value Object
+}
+
+type Hash struct {
+	Pairs map[HashKey]HashPair
+}
+
+func NewHash() *Hash {
+	return &Hash{Pairs: make(map[HashKey]HashPair)}
+}
+
+func (h *Hash) Type() string {
+	return HASH_OBJ
+}
+
+func (h *Hash) String() string {
+	var out bytes.Buffer
+	pairs := []string{}
+	for _, pair := range h.SortedPairs() {
+		pairs = append(pairs, pair.Key.String()+": "+pair.Value.String())
+	}
+	out.WriteString(LBRACE)
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString(RBRACE)
+	return out.String()
+}
+
+// pairs ordered by key, so that printing and keys() are stable
+// integers are ordered by value, other keys by their text
+func (h *Hash) SortedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		ki, kj := pairs[i].Key, pairs[j].Key
+		if ki.Type() != kj.Type() {
+			return ki.Type() < kj.Type()
+		}
+		if ki, ok := ki.(*Integer); ok {
+			return ki.Value < kj.(*Integer).Value
+		}
+		return ki.String() < kj.String()
+	})
+	return pairs
+}
+
 // ================== compiled function
 type CompiledFunction struct {
 	Instructions Instructions
 	NumLocals    int
 	NumParas     int
+	ParaTypes    []string // "" for a parameter without annotation
+	Name         string
+	Lines        []LineInfo
+	LocalNames   []string // by slot, "" for a slot whose name was shadowed
 }
 
 func (cf *CompiledFunction) Type() string {
@@ -148,6 +414,46 @@ func (cf *CompiledFunction) String() string {
 	return fmt.Sprintf("func(%d paras, %d locals )", cf.NumParas, cf.NumLocals)
 }
 
+// the name in stack traces
+func (cf *CompiledFunction) DisplayName() string {
+	if cf.Name == "" {
+		return "<anonymous>"
+	}
+	return cf.Name
+}
+
+// the VM and the evaluater check the arguments of a call
+// against the parameters, annotated ones by their type.
+// An integer passed for a float parameter becomes a float in args.
+func checkArguments(name string, numParas int, paraTypes []string, args []Object) error {
+	if len(args) != numParas {
+		return fmt.Errorf("%s wants %d arguments, got %d", name, numParas, len(args))
+	}
+	for i, typ := range paraTypes {
+		if !matchesAnnotation(typ, args[i]) {
+			return fmt.Errorf("argument %d of %s must be %s, got %s", i+1, name, typ, typeName(args[i]))
+		}
+		if typ == "float" && isInteger(args[i]) {
+			args[i] = &Float{Value: toFloat(args[i])}
+		}
+	}
+	return nil
+}
+
+func matchesAnnotation(typ string, obj Object) bool {
+	switch typ {
+	case "int":
+		return isInteger(obj)
+	case "float":
+		return isNumber(obj)
+	case "string":
+		return obj.Type() == STRING_OBJ
+	case "bool":
+		return obj.Type() == BOOLEAN_OBJ
+	}
+	return true
+}
+
 func randomObject(s string) Object {
 
 	var res Object
@@ -159,6 +465,11 @@ func randomObject(s string) Object {
 		}
 		res = &Integer{Value: v}
 
+	case "float":
+		// mostly ordinary values, sometimes very large or very small ones
+		v := rand.NormFloat64() * math.Pow(10, float64(rand.Intn(21)-10))
+		res = &Float{Value: v}
+
 	case "bool":
 		res = &Boolean{Value: bool(rand.Intn(2) == 0)}
 
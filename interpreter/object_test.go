@@ -1,34 +1,96 @@
 package interpreter
 
 import (
-	"reflect"
+	"math"
+	"math/big"
 	"testing"
 )
 
 func Test_randomObject(t *testing.T) {
+	// the values are random, only their types are known
 	tests := []struct {
 		name string
 		arg  string
-		want Object
+		want string
 	}{
-		{"int test",
-			"int",
-			&Integer{Value: 1},
-		},
-		{"string test",
-			"string",
-			&Integer{Value: 2},
-		},
-		{"bool test",
-			"bool",
-			&Integer{Value: 3},
-		},
+		{"int test", "int", INTEGER_OBJ},
+		{"float test", "float", FLOAT_OBJ},
+		{"string test", "string", STRING_OBJ},
+		{"bool test", "bool", BOOLEAN_OBJ},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := randomObject(tt.arg); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("randomObject() = %v, want %v", got, tt.want)
+			if got := randomObject(tt.arg); got.Type() != tt.want {
+				t.Errorf("randomObject() = %v, want a %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestFloat_String(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{2, "2.0"},
+		{-0.5, "-0.5"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+	}
+	for _, tt := range tests {
+		if got := (&Float{Value: tt.value}).String(); got != tt.want {
+			t.Errorf("want %s, got %s", tt.want, got)
+		}
+	}
+}
+
+func Test_hopeEqual(t *testing.T) {
+	tests := []struct {
+		expected, got Object
+		want          bool
+	}{
+		{&Float{Value: 0.3}, &Float{Value: 0.1 + 0.2}, true},
+		{&Float{Value: 0.3}, &Float{Value: 0.31}, false},
+		{&Integer{Value: 2}, &Float{Value: 2.0000000000001}, true},
+		{&Float{Value: 1e20}, &Float{Value: 1e20 + 1e5}, true},
+		{&Integer{Value: 2}, &Integer{Value: 2}, true},
+		{NullObj, NullObj, true},
+		{NullObj, &Integer{Value: 0}, false},
+		{&Integer{Value: 2}, &String{Value: "2"}, false},
+		{newInteger(new(big.Int).Lsh(big.NewInt(1), 70)), mulInt(1<<35, 1<<35), true},
+		{newInteger(new(big.Int).Lsh(big.NewInt(1), 70)), mulInt(1<<35, 1<<36), false},
+		{&Array{Elements: []Object{&Float{Value: 0.3}}}, &Array{Elements: []Object{&Float{Value: 0.1 + 0.2}}}, true},
+	}
+	for i, tt := range tests {
+		if got := hopeEqual(tt.expected, tt.got); got != tt.want {
+			t.Errorf("%d: hopeEqual(%v, %v) = %v", i, tt.expected, tt.got, got)
+		}
+	}
+}
+
+func Test_checkedArithmetic(t *testing.T) {
+	tests := []struct {
+		got  Object
+		want string
+	}{
+		{addInt(math.MaxInt, 1), "9223372036854775808"},
+		{addInt(math.MinInt, -1), "-9223372036854775809"},
+		{addInt(math.MaxInt, -1), "9223372036854775806"},
+		{subInt(math.MinInt, 1), "-9223372036854775809"},
+		{subInt(0, math.MinInt), "9223372036854775808"},
+		{mulInt(math.MinInt, -1), "9223372036854775808"},
+		{mulInt(-1, math.MinInt), "9223372036854775808"},
+		{mulInt(3037000500, 3037000500), "9223372037000250000"},
+		{mulInt(-3037000499, 3037000499), "-9223372030926249001"},
+		{divInt(math.MinInt, -1), "9223372036854775808"},
+		{negInt(math.MinInt), "9223372036854775808"},
+	}
+	for i, tt := range tests {
+		if got := tt.got.String(); got != tt.want {
+			t.Errorf("%d: want %s, got %s", i, tt.want, got)
+		}
+	}
+	if _, ok := mulInt(-3037000499, 3037000499).(*Integer); !ok {
+		t.Errorf("a result that fits must stay an Integer")
+	}
+}
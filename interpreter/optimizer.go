@@ -0,0 +1,202 @@
+package interpreter
+
+// ====== optimizer
+// With SetOptimize the compiler
+//   - folds expressions of literals, 2+3*4 is the constant 14,
+//   - leaves out the branches of if, ?: and while that a literal
+//     condition never takes,
+//   - makes a jump that lands on a jump go to where that one goes,
+//   - drops the constants nothing loads any more.
+//
+// Folding runs the operators of the VM, so a folded expression has
+// the value it would have had at run time. One that would fail,
+// 1/0 or 1 + "a", isn't folded and fails when it runs
+
+// SetOptimize turns the optimizer on, it is off by default.
+// The constants compiled before, see NewCompilerWithState, stay as they are
+func (c *Compiler) SetOptimize(on bool) {
+	c.optimize = on
+}
+
+// the value of an expression of literals
+func (c *Compiler) fold(node ASTNode) (Object, bool) {
+	switch node := node.(type) {
+	case *IntegerLiteral:
+		return newInt(node.Key), true
+	case *BigIntLiteral:
+		return &BigInt{Value: node.Key}, true
+	case *FloatLiteral:
+		return &Float{Value: node.Key}, true
+	case *StringLiteral:
+		return &String{Value: node.Key}, true
+	case *BooleanLiteral:
+		return nativeBool(node.Key), true
+	case *NilLiteral:
+		return NullObj, true
+
+	case *UnaryExpression:
+		right, ok := c.fold(node.Right)
+		if !ok {
+			return nil, false
+		}
+		op := OpMinus
+		if node.Operator == BANG {
+			op = OpBang
+		}
+		obj, err := unary(op, right)
+		return obj, err == nil
+
+	case *InfixExpression:
+		op, ok := c.operator2code[node.Operator]
+		if !ok {
+			return nil, false
+		}
+		left, ok := c.fold(node.Left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := c.fold(node.Right)
+		if !ok {
+			return nil, false
+		}
+		vm := &VM{stack: make([]Object, 1), maxStack: 1}
+		if err := vm.infix(op, left, right); err != nil {
+			return nil, false
+		}
+		return vm.stack[0], true
+	}
+	return nil, false
+}
+
+// the value of an expression when optimizing
+func (c *Compiler) foldExpression(node ASTNode) (Object, bool) {
+	if !c.optimize {
+		return nil, false
+	}
+	return c.fold(node)
+}
+
+// the literal boolean a condition always is
+func (c *Compiler) foldCondition(cnd ASTNode) (value bool, ok bool) {
+	obj, ok := c.foldExpression(cnd)
+	b, isBool := obj.(*Boolean)
+	if !ok || !isBool {
+		return false, false
+	}
+	return b.Value, true
+}
+
+func (c *Compiler) emitFolded(obj Object) {
+	if obj == NullObj {
+		c.emit(OpNull)
+		return
+	}
+	c.emitConstant(obj)
+}
+
+// compiles code that never runs and throws its instructions away,
+// the variables it defines stay defined for the code after it
+func (c *Compiler) discard(compile func() error) error {
+	scope := &c.scopes[len(c.scopes)-1]
+	ins, lines := len(scope.instructions), len(scope.lines)
+	err := compile()
+	scope = &c.scopes[len(c.scopes)-1]
+	scope.instructions = scope.instructions[:ins]
+	scope.lines = scope.lines[:lines]
+	return err
+}
+
+// a jump to a jump goes where the last one goes
+func threadJumps(ins Instructions) {
+	for pc := 0; pc < len(ins); {
+		op := Opcode(ins[pc])
+		def, err := Lookup(op)
+		if err != nil || pc+def.Size() > len(ins) {
+			return
+		}
+		if op == OpJump || op == OpJumpIfFalse {
+			target := ins.readUint16(pc + 1)
+			// a loop of jumps never ends, leave it be
+			for hops := 0; hops < len(ins) && target+2 < len(ins) && Opcode(ins[target]) == OpJump; hops++ {
+				target = ins.readUint16(target + 1)
+			}
+			copy(ins[pc:], Make(op, target))
+		}
+		pc += def.Size()
+	}
+}
+
+// drops the constants from index base on that no instruction loads,
+// in the instructions of the program and of the functions it loads
+func (c *Compiler) dropUnusedConstants(base int) {
+	used := make([]bool, len(c.constants))
+	var mark func(ins Instructions)
+	mark = func(ins Instructions) {
+		forEachConstant(ins, func(pc int, idx int) {
+			if idx >= len(used) || used[idx] {
+				return
+			}
+			used[idx] = true
+			if fn, ok := c.constants[idx].(*CompiledFunction); ok {
+				mark(fn.Instructions)
+			}
+		})
+	}
+	// the ones compiled before may be loaded by code compiled before
+	for i := 0; i < base; i++ {
+		used[i] = true
+	}
+	mark(c.currentInstructions())
+
+	// the new index of every constant that stays
+	index := make([]int, len(c.constants))
+	constants := c.constants[:0:0]
+	for i, obj := range c.constants {
+		if used[i] {
+			index[i] = len(constants)
+			constants = append(constants, obj)
+		}
+	}
+	if len(constants) == len(c.constants) {
+		return
+	}
+	renumber := func(ins Instructions) {
+		forEachConstant(ins, func(pc int, idx int) {
+			copy(ins[pc:], Make(Opcode(ins[pc]), index[idx]))
+		})
+	}
+	renumber(c.currentInstructions())
+	for _, obj := range constants[base:] {
+		if fn, ok := obj.(*CompiledFunction); ok {
+			renumber(fn.Instructions)
+		}
+	}
+
+	c.constants = constants
+	c.interned = make(map[interface{}]int)
+	for i, obj := range constants {
+		if key, ok := internKey(obj); ok {
+			if _, seen := c.interned[key]; !seen {
+				c.interned[key] = i
+			}
+		}
+	}
+}
+
+// calls f with the pc and the index of every OpConstant and OpConstantWide
+func forEachConstant(ins Instructions, f func(pc int, idx int)) {
+	for pc := 0; pc < len(ins); {
+		op := Opcode(ins[pc])
+		def, err := Lookup(op)
+		if err != nil || pc+def.Size() > len(ins) {
+			return
+		}
+		switch op {
+		case OpConstant:
+			f(pc, ins.readUint16(pc+1))
+		case OpConstantWide:
+			f(pc, ins.readUint32(pc+1))
+		}
+		pc += def.Size()
+	}
+}
@@ -1,8 +1,9 @@
 package interpreter
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"math/big"
 	"strconv"
 )
 
@@ -50,6 +51,10 @@ type Parser struct {
 	next         Token
 	prefixParser map[string]prefixParseFn
 	infixParser  map[string]infixParseFn
+
+	diagnostics []Diagnostic
+	// where the last token ended, EOL and EOF have no position
+	last Position
 }
 
 func NewParser(l *Lexer) *Parser {
@@ -61,9 +66,12 @@ func NewParser(l *Lexer) *Parser {
 
 	p.prefixParser[IDENTIFIER] = p.parseIdentifier
 	p.prefixParser[INTEGER] = p.parseInteger
+	p.prefixParser[FLOAT] = p.parseFloat
 	p.prefixParser[BOOLEAN] = p.parseBoolean
+	p.prefixParser[NIL] = p.parseNil
 	p.prefixParser[STRING] = p.parseString
 	p.prefixParser[LBRACKET] = p.parseArray
+	p.prefixParser[LBRACE] = p.parseHash
 	p.prefixParser[LPAREN] = p.parseGroupedExpression
 	p.prefixParser[BANG] = p.parseUnaryExpression
 	p.prefixParser[MINUS] = p.parseUnaryExpression
@@ -81,7 +89,11 @@ func NewParser(l *Lexer) *Parser {
 }
 
 // ================== parse functions
-func (p *Parser) Parse(res chan Statement) (ASTNode, error) {
+// every parse function leaves cur on the last token of what it parsed
+
+// Parse returns the program and everything wrong with it.
+// A broken statement is left out and parsing goes on after it.
+func (p *Parser) Parse(res chan Statement) (ASTNode, []Diagnostic) {
 	prog := &Program{}
 	for p.cur != EOF {
 		// // log.Println("parse : cur and next are ", p.cur, p.next)
@@ -91,12 +103,12 @@ func (p *Parser) Parse(res chan Statement) (ASTNode, error) {
 		}
 		stmt, err := p.parseStatement()
 		if err != nil {
-			return nil, err
+			p.report(err)
+			p.synchronize(false)
+			continue
 		}
 		// debug
 		// log.Println("parse : ", p.lexer.lineNo, "th line : ", stmt.String())
-		// advance
-		p.advance()
 		prog.Statements = append(prog.Statements, stmt)
 		if res != nil {
 			res <- stmt
@@ -106,9 +118,14 @@ func (p *Parser) Parse(res chan Statement) (ASTNode, error) {
 	if res != nil {
 		close(res)
 	}
+	if n := len(prog.Statements); n > 0 {
+		prog.Span = Span{prog.Statements[0].Pos(), prog.Statements[n-1].End()}
+	}
 
-	return prog, nil
+	return prog, p.diagnostics
 }
+
+// a statement ends with its line or with the } of its block
 func (p *Parser) parseStatement() (Statement, error) {
 	var stmt Statement
 	var err error
@@ -121,6 +138,10 @@ func (p *Parser) parseStatement() (Statement, error) {
 	if err != nil {
 		return nil, err
 	}
+	p.advance()
+	if p.cur != EOL && p.cur != EOF && !p.checkCur(RBRACE) {
+		return nil, p.errorf("unexpected %s after the statement", describe(p.cur))
+	}
 	return stmt, nil
 }
 
@@ -137,36 +158,89 @@ func (p *Parser) parseExpressionStatement() (Expression, error) {
 		case "=":
 			return p.parseAssignExpression()
 		default:
-			return p.parseExpression(LOWEST)
+			// stop before "=" so that a[i] = x can be told apart
+			expr, err := p.parseExpression(ASSIGNPRE)
+			if err != nil {
+				return nil, err
+			}
+			if p.checkNext(ASSIGN) {
+				return p.parseIndexAssignExpression(expr)
+			}
+			return expr, nil
 		}
 
 	}
 }
 func (p *Parser) parseDefineExpression() (Expression, error) {
-	ds := &DefineExpression{}                           // =
-	ds.Ident = &IdentifierLiteral{Key: p.cur.Literal()} // identifier
+	if p.cur.Type() != IDENTIFIER {
+		return nil, p.errorf("cannot define %s", describe(p.cur))
+	}
+	ds := &DefineExpression{}                                            // =
+	ds.Ident = &IdentifierLiteral{Key: p.cur.Literal(), Span: p.token()} // identifier
 	p.advance()
-	p.skip(":=")
+	if err := p.skip(":="); err != nil {
+		return nil, err
+	}
 
-	ds.Expr, _ = p.parseExpression(LOWEST) //
+	expr, err := p.parseExpression(LOWEST) //
+	if err != nil {
+		return nil, err
+	}
+	ds.Expr = expr
+	if fn, ok := ds.Expr.(*FunctionLiteral); ok {
+		fn.Name = ds.Ident.Key
+	}
+	ds.Span = p.span(ds.Ident.Pos())
 	return ds, nil
 }
 func (p *Parser) parseAssignExpression() (Expression, error) {
-	assign := &AssignExpression{}                           // =
-	assign.Ident = &IdentifierLiteral{Key: p.cur.Literal()} // identifier
+	if p.cur.Type() != IDENTIFIER {
+		return nil, p.errorf("cannot assign to %s", describe(p.cur))
+	}
+	assign := &AssignExpression{}                                            // =
+	assign.Ident = &IdentifierLiteral{Key: p.cur.Literal(), Span: p.token()} // identifier
 	p.advance()
-	p.skip("=")
+	if err := p.skip("="); err != nil {
+		return nil, err
+	}
 
-	assign.Expr, _ = p.parseExpression(LOWEST) //
+	expr, err := p.parseExpression(LOWEST) //
+	if err != nil {
+		return nil, err
+	}
+	assign.Expr = expr
+	if fn, ok := assign.Expr.(*FunctionLiteral); ok {
+		fn.Name = assign.Ident.Key
+	}
+	assign.Span = p.span(assign.Ident.Pos())
 	return assign, nil
 }
 
+func (p *Parser) parseIndexAssignExpression(left Expression) (Expression, error) {
+	target, ok := left.(*IndexExpression)
+	if !ok {
+		return nil, p.errorf("cannot assign to %s", left.String())
+	}
+	p.advance()
+	if err := p.skip("="); err != nil {
+		return nil, err
+	}
+	expr, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return nil, err
+	}
+	return &IndexAssignExpression{Target: target, Expr: expr, Span: p.span(target.Pos())}, nil
+}
+
 func (p *Parser) parseIfExpression() (Expression, error) {
 	ie := &IfExpression{conditions: make([]Expression, 0),
 		executes: make([]*BlockExpression, 0)}
+	start := p.pos()
 
-	p.skip("if")
-	log.Println("----- parseIfExpression -----  ", p.cur.Type(), p.cur.Literal())
+	if err := p.skip("if"); err != nil {
+		return nil, err
+	}
+	debugLog.Println("----- parseIfExpression -----  ", p.cur.Type(), p.cur.Literal())
 	cnd, err := p.parseExpression(LOWEST)
 	if err != nil {
 		return nil, err
@@ -179,7 +253,8 @@ func (p *Parser) parseIfExpression() (Expression, error) {
 	}
 	ie.addPair(cnd, block)
 	// ------------------- else
-	for p.checkCur("else") {
+	for p.checkNext("else") {
+		p.advance()
 		p.advance()
 		if p.checkCur("if") {
 			p.advance()
@@ -198,27 +273,42 @@ func (p *Parser) parseIfExpression() (Expression, error) {
 		}
 		ie.addPair(cnd, block)
 	}
+	ie.Span = p.span(start)
 	return ie, nil
 }
 
 func (p *Parser) parseWhileExpression() (ASTNode, error) {
 	we := &WhileExpression{}
+	start := p.pos()
 	p.advance()
-	we.Condition, _ = p.parseExpression(LOWEST)
+	cnd, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return nil, err
+	}
+	we.Condition = cnd
 
 	p.advance()
-	we.Execute, _ = p.parseBlockExpression()
+	if we.Execute, err = p.parseBlockExpression(); err != nil {
+		return nil, err
+	}
+	we.Span = p.span(start)
 
 	return we, nil
 }
 
+// a broken statement in a block is reported, the block goes on
 func (p *Parser) parseBlockExpression() (*BlockExpression, error) {
 	// log.Println("block!\t")
-	p.skip("{")
+	start := p.pos()
+	if err := p.skip("{"); err != nil {
+		return nil, err
+	}
 	block := &BlockExpression{Statements: make([]Statement, 0)}
 	for !p.checkCur(RBRACE) {
 		// log.Println("cur and next are ", p.cur, p.next)
-
+		if p.cur == EOF {
+			return nil, p.errorf("want '}', got %s", describe(p.cur))
+		}
 		if p.cur == EOL {
 			p.advance()
 			continue
@@ -227,16 +317,16 @@ func (p *Parser) parseBlockExpression() (*BlockExpression, error) {
 		stmt, err := p.parseStatement()
 
 		if err != nil {
-			return nil, err
+			p.report(err)
+			p.synchronize(true)
+			continue
 		}
 		// debug
 		// log.Println(p.lexer.lineNo, "th line : ", stmt.String())
-		// advance
-		p.advance()
 		block.Statements = append(block.Statements, stmt)
 
 	}
-	p.skip("}")
+	block.Span = p.span(start)
 	return block, nil
 }
 func (p *Parser) parseExpression(precedence int) (Expression, error) {
@@ -248,11 +338,17 @@ func (p *Parser) parseExpression(precedence int) (Expression, error) {
 	}
 	parser, ok := p.prefixParser[tp]
 	if !ok {
-		return nil, fmt.Errorf("no prefix function for %+v", p.cur.Literal())
+		if tp == ILLEGAL {
+			return nil, p.errorf("illegal character %s", describe(p.cur))
+		}
+		return nil, p.errorf("want an expression, got %s", describe(p.cur))
+	}
+	left, err := parser() // Expression, error
+	if err != nil {
+		return nil, err
 	}
-	left, _ := parser() // Expression, error
-	for p.next.Literal() != "EOL" && precedence < p.peekPrecedence() {
-		log.Println("---- parseExpression ----", p.cur, p.next)
+	for p.next != EOL && precedence < p.peekPrecedence() {
+		debugLog.Println("---- parseExpression ----", p.cur, p.next)
 
 		tp = p.next.Type()
 		if tp == OPERATOR {
@@ -260,32 +356,48 @@ func (p *Parser) parseExpression(precedence int) (Expression, error) {
 		}
 		infix, ok := p.infixParser[tp]
 		if !ok {
-			return left, fmt.Errorf("no infix function for %v", p.next.Literal())
+			return nil, p.errorf("no infix function for %s", describe(p.next))
 		}
 		p.advance()
-		left, _ = infix(left)
+		if left, err = infix(left); err != nil {
+			return nil, err
+		}
 
 	}
-	log.Println("---- parseExpression end----", p.cur, p.next)
+	debugLog.Println("---- parseExpression end----", p.cur, p.next)
 
 	return left, nil
 }
 
 func (p *Parser) parseUnaryExpression() (Expression, error) {
-	log.Printf("========= unary expression========%T %v", p.cur, p.cur)
+	debugLog.Printf("========= unary expression========%T %v", p.cur, p.cur)
 	ue := &UnaryExpression{
 		Operator: p.cur.Literal(),
 	}
+	start := p.pos()
 	p.advance()
-	ue.Right, _ = p.parseExpression(PREFIX)
+	right, err := p.parseExpression(PREFIX)
+	if err != nil {
+		return nil, err
+	}
+	ue.Right = right
+	ue.Span = p.span(start)
 	return ue, nil
 }
 func (p *Parser) parseGroupedExpression() (Expression, error) {
-	p.skip("(")
-	expr, _ := p.parseExpression(LOWEST)
+	if err := p.skip("("); err != nil {
+		return nil, err
+	}
+	expr, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return nil, err
+	}
 	p.advance()
 	// (2+4/2)*(2+3)
-	// p.skip(")")
+	// stay at ")"
+	if !p.checkCur(RPAREN) {
+		return nil, p.errorf("want ')', got %s", describe(p.cur))
+	}
 	return expr, nil
 }
 func (p *Parser) parseInfixExpression(left Expression) (Expression, error) {
@@ -295,96 +407,156 @@ func (p *Parser) parseInfixExpression(left Expression) (Expression, error) {
 	}
 	precedence := p.curPrecedence()
 	p.advance()
-	expr.Right, _ = p.parseExpression(precedence)
+	right, err := p.parseExpression(precedence)
+	if err != nil {
+		return nil, err
+	}
+	expr.Right = right
+	expr.Span = p.span(left.Pos())
 	return expr, nil
 }
 func (p *Parser) parseTernaryExpression(condition Expression) (Expression, error) {
 	// log.Println("Now I am in Ternary?")
-	p.skip("?")
+	if err := p.skip("?"); err != nil {
+		return nil, err
+	}
 	ternary := &TernaryExpression{
 		condition: condition,
 	}
-	ternary.left, _ = p.parseExpression(LOWEST)
+	var err error
+	if ternary.left, err = p.parseExpression(LOWEST); err != nil {
+		return nil, err
+	}
 	p.advance()
-	p.skip(":")
-	ternary.right, _ = p.parseExpression(LOWEST)
+	if err := p.skip(":"); err != nil {
+		return nil, err
+	}
+	if ternary.right, err = p.parseExpression(LOWEST); err != nil {
+		return nil, err
+	}
+	ternary.Span = p.span(condition.Pos())
 	return ternary, nil
 }
 
 func (p *Parser) parseHopeBlock() (*HopeBlock, error) {
-	p.skip("{")
+	start := p.pos()
+	if err := p.skip("{"); err != nil {
+		return nil, err
+	}
 	hopeBlock := &HopeBlock{
 		HopeExpressions: make([]HopeExpression, 0),
 	}
+	var err error
 	for !p.checkCur(RBRACE) && !p.checkCur(FUZZING) {
+		if p.cur == EOF {
+			return nil, p.errorf("want '}', got %s", describe(p.cur))
+		}
 		if p.cur == EOL {
 			p.advance()
 			continue
 		}
 		hpe := HopeExpression{}
-		hpe.Parameters, _ = p.parseExpressionList("->")
-		log.Printf("++\n\nafter parse parameters %v %v\n\n++", p.cur, p.next)
-		p.skip("->")
+		caseStart := p.pos()
+		if hpe.Parameters, err = p.parseExpressionList("->"); err != nil {
+			return nil, err
+		}
+		debugLog.Printf("++\n\nafter parse parameters %v %v\n\n++", p.cur, p.next)
+		if err := p.skip("->"); err != nil {
+			return nil, err
+		}
 		// should advance() after parseExpression
-		hpe.Expected, _ = p.parseExpression(LOWEST)
+		if hpe.Expected, err = p.parseExpression(LOWEST); err != nil {
+			return nil, err
+		}
+		hpe.Span = p.span(caseStart)
 		p.advance()
-		log.Printf("++\n\nafter parse answer %v %v\n\n++", p.cur, p.next)
+		if p.cur != EOL && !p.checkCur(RBRACE) {
+			return nil, p.errorf("unexpected %s after the hope case", describe(p.cur))
+		}
+		debugLog.Printf("++\n\nafter parse answer %v %v\n\n++", p.cur, p.next)
 		hopeBlock.HopeExpressions = append(hopeBlock.HopeExpressions, hpe)
 	}
 
 	if p.checkCur(FUZZING) {
-		p.skip(FUZZING)
-		val, _ := strconv.Atoi(p.cur.Literal())
-		hopeBlock.NFuzzing = &IntegerLiteral{Key: val}
 		p.advance()
+		val, err := strconv.Atoi(p.cur.Literal())
+		if p.cur.Type() != INTEGER || err != nil {
+			return nil, p.errorf("fuzzing wants a number of cases, got %s", describe(p.cur))
+		}
+		hopeBlock.NFuzzing = &IntegerLiteral{Key: val, Span: p.token()}
 		p.advance()
+		p.skipEOL()
+		if !p.checkCur(RBRACE) {
+			return nil, p.errorf("want '}' after fuzzing, got %s", describe(p.cur))
+		}
 	}
-	p.skip(RBRACE)
+	hopeBlock.Span = p.span(start)
 	return hopeBlock, nil
 }
 func (p *Parser) parseIndexExpression(left Expression) (Expression, error) {
 	expr := &IndexExpression{
 		Left: left,
 	}
-	p.skip(LBRACKET)
-	expr.Index, _ = p.parseExpression(LOWEST)
+	if err := p.skip(LBRACKET); err != nil {
+		return nil, err
+	}
+	index, err := p.parseExpression(LOWEST)
+	if err != nil {
+		return nil, err
+	}
+	expr.Index = index
 	p.advance()
-	// p.skip(RBRACKET)
+	// stay at "]"
+	if !p.checkCur(RBRACKET) {
+		return nil, p.errorf("want ']', got %s", describe(p.cur))
+	}
+	expr.Span = p.span(left.Pos())
 	return expr, nil
 }
 func (p *Parser) parseCallExpression(left Expression) (Expression, error) {
 	ce := &CallExpression{
 		Function: left,
 	}
-	p.skip(LPAREN)
-	log.Println("-- CallExpression --", p.cur, p.next)
+	if err := p.skip(LPAREN); err != nil {
+		return nil, err
+	}
+	debugLog.Println("-- CallExpression --", p.cur, p.next)
 
-	ce.Arguments, _ = p.parseExpressionList(RPAREN)
+	args, err := p.parseExpressionList(RPAREN)
+	if err != nil {
+		return nil, err
+	}
+	ce.Arguments = args
 	// delete this so that len(a) <= 10 could work
 	// p.skip(RPAREN)
+	ce.Span = p.span(left.Pos())
 	return ce, nil
 }
 
+// leaves cur on end
 func (p *Parser) parseExpressionList(end string) ([]Expression, error) {
 	list := []Expression{}
 	if p.checkCur(end) { // no identifier
 		return list, nil
 	}
 	for {
-		log.Printf("before parse expressionList p.cur=%v, p.next=%v\n", p.cur, p.next)
-		expr, _ := p.parseExpression(LOWEST)
-		log.Printf("after parse expressionList p.cur=%v, p.next=%v\n", p.cur, p.next)
+		debugLog.Printf("before parse expressionList p.cur=%v, p.next=%v\n", p.cur, p.next)
+		expr, err := p.parseExpression(LOWEST)
+		if err != nil {
+			return nil, err
+		}
+		debugLog.Printf("after parse expressionList p.cur=%v, p.next=%v\n", p.cur, p.next)
 
 		list = append(list, expr)
 		p.advance()
-		log.Printf("expressionList: after advance p.cur=%v, p.next=%v\n", p.cur, p.next)
+		debugLog.Printf("expressionList: after advance p.cur=%v, p.next=%v\n", p.cur, p.next)
 
 		if p.checkCur(end) {
 			return list, nil
 		} else if p.checkCur(COMMA) {
 			p.advance()
 		} else {
-			return nil, fmt.Errorf("function parameters mismatch")
+			return nil, p.errorf("want ',' or '%s', got %s", end, describe(p.cur))
 		}
 	}
 }
@@ -393,46 +565,119 @@ func (p *Parser) parseExpressionList(end string) ([]Expression, error) {
 
 func (p *Parser) parseArray() (Expression, error) {
 	array := &ArrayLiteral{Elements: make([]Expression, 0)}
-	p.skip(LBRACKET)
-	array.Elements, _ = p.parseExpressionList(RBRACKET)
-	p.skip(RBRACKET)
+	start := p.pos()
+	if err := p.skip(LBRACKET); err != nil {
+		return nil, err
+	}
+	elements, err := p.parseExpressionList(RBRACKET)
+	if err != nil {
+		return nil, err
+	}
+	array.Elements = elements
+	// stay at "]" like a call stays at ")", so that [1, 2][0] works
+	array.Span = p.span(start)
 	return array, nil
 }
 
+// a brace in the place of an expression is always a hash,
+// blocks are only parsed after if, while and func
+func (p *Parser) parseHash() (Expression, error) {
+	hash := &HashLiteral{Keys: []Expression{}, Values: []Expression{}}
+	start := p.pos()
+	if err := p.skip(LBRACE); err != nil {
+		return nil, err
+	}
+	p.skipEOL()
+	for !p.checkCur(RBRACE) {
+		key, err := p.parseExpression(LOWEST)
+		if err != nil {
+			return nil, err
+		}
+		p.advance()
+		if err := p.skip(":"); err != nil {
+			return nil, err
+		}
+		value, err := p.parseExpression(LOWEST)
+		if err != nil {
+			return nil, err
+		}
+		p.advance()
+		p.skipEOL()
+		hash.Keys = append(hash.Keys, key)
+		hash.Values = append(hash.Values, value)
+
+		if p.checkCur(COMMA) {
+			p.advance()
+			p.skipEOL()
+		} else if !p.checkCur(RBRACE) {
+			return nil, p.errorf("hash literal: want ',' or '}', got %s", describe(p.cur))
+		}
+	}
+	hash.Span = p.span(start)
+	return hash, nil
+}
+
 func (p *Parser) parseFunction() (Expression, error) {
-	p.skip("func")
-	paras, typs, _ := p.parseIdentifierList()
-	p.skip(RPAREN)
-	exec, _ := p.parseBlockExpression()
+	start := p.pos()
+	if err := p.skip("func"); err != nil {
+		return nil, err
+	}
+	paras, typs, err := p.parseIdentifierList()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.skip(RPAREN); err != nil {
+		return nil, err
+	}
+	// the optional return type
+	ret := ""
+	if p.cur.Type() == IDENTIFIER && isTypeName(p.cur.Literal()) {
+		ret = p.cur.Literal()
+		p.advance()
+	}
+	exec, err := p.parseBlockExpression()
+	if err != nil {
+		return nil, err
+	}
 
 	// parse hope
 	var hopes *HopeBlock
 	hopes = nil
-	if p.checkCur("hope") {
-		p.skip("hope")
-		hopes, _ = p.parseHopeBlock()
+	if p.checkNext("hope") {
+		p.advance()
+		p.advance()
+		if hopes, err = p.parseHopeBlock(); err != nil {
+			return nil, err
+		}
 	}
 	return &FunctionLiteral{
 		Parameters: paras,
 		ParaTypes:  typs,
+		ReturnType: ret,
 		Execute:    exec,
 		Hopes:      hopes,
+		Span:       p.span(start),
 	}, nil
 }
 
 // parse function parameters
 func (p *Parser) parseIdentifierList() ([]*IdentifierLiteral, []string, error) {
-	p.skip(LPAREN)
+	if err := p.skip(LPAREN); err != nil {
+		return nil, nil, err
+	}
 	identList := []*IdentifierLiteral{}
 	typeList := []string{}
 	if p.checkCur(RPAREN) { // no identifier
 		return identList, typeList, nil
 	}
 	for {
-		ident := &IdentifierLiteral{Key: p.cur.Literal()}
+		if p.cur.Type() != IDENTIFIER {
+			return nil, nil, p.errorf("want a parameter name, got %s", describe(p.cur))
+		}
+		ident := &IdentifierLiteral{Key: p.cur.Literal(), Span: p.token()}
 		identList = append(identList, ident)
 		p.advance()
-		if p.checkCur("int") || p.checkCur("string") || p.checkCur("bool") {
+		if p.cur.Type() == IDENTIFIER && isTypeName(p.cur.Literal()) {
 			typeList = append(typeList, p.cur.Literal())
 			p.advance()
 		} else {
@@ -446,25 +691,48 @@ func (p *Parser) parseIdentifierList() ([]*IdentifierLiteral, []string, error) {
 		case p.checkCur(COMMA):
 			p.advance()
 
+		default:
+			return nil, nil, p.errorf("want ',' or ')', got %s", describe(p.cur))
 		}
 	}
 }
 
+// the names a parameter or a result can be annotated with
+func isTypeName(name string) bool {
+	switch name {
+	case "int", "float", "string", "bool":
+		return true
+	}
+	return false
+}
+
 // ========== parse leaves
 func (p *Parser) parseString() (Expression, error) {
-	return &StringLiteral{Key: p.cur.Literal()}, nil
+	return &StringLiteral{Key: p.cur.Literal(), Span: p.token()}, nil
 }
 
 func (p *Parser) parseIdentifier() (Expression, error) {
-	return &IdentifierLiteral{Key: p.cur.Literal()}, nil
+	return &IdentifierLiteral{Key: p.cur.Literal(), Span: p.token()}, nil
 }
 
 func (p *Parser) parseInteger() (Expression, error) {
 	val, err := strconv.Atoi(p.cur.Literal())
+	if errors.Is(err, strconv.ErrRange) {
+		key, _ := new(big.Int).SetString(p.cur.Literal(), 10)
+		return &BigIntLiteral{Key: key, Span: p.token()}, nil
+	}
 	if err != nil {
-		return nil, err
+		return nil, p.errorf("%v", err)
 	}
-	return &IntegerLiteral{Key: val}, nil
+	return &IntegerLiteral{Key: val, Span: p.token()}, nil
+}
+
+func (p *Parser) parseFloat() (Expression, error) {
+	val, err := strconv.ParseFloat(p.cur.Literal(), 64)
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	return &FloatLiteral{Key: val, Span: p.token()}, nil
 }
 
 func (p *Parser) parseBoolean() (Expression, error) {
@@ -472,7 +740,93 @@ func (p *Parser) parseBoolean() (Expression, error) {
 	if p.cur.Literal() == "true" {
 		key = true
 	}
-	return &BooleanLiteral{Key: key}, nil
+	return &BooleanLiteral{Key: key, Span: p.token()}, nil
+}
+
+func (p *Parser) parseNil() (Expression, error) {
+	return &NilLiteral{Span: p.token()}, nil
+}
+
+//  ============ positions
+
+// where the current token starts
+func (p *Parser) pos() Position {
+	return Position{File: p.lexer.file, Line: p.cur.LineNumber(), Column: p.cur.Column()}
+}
+
+// just after the current token
+func (p *Parser) end() Position {
+	n := len(p.cur.Literal())
+	if p.cur.Type() == STRING {
+		n += 2 // the quotes
+	}
+	return Position{File: p.lexer.file, Line: p.cur.LineNumber(), Column: p.cur.Column() + n}
+}
+
+// the span of the current token
+func (p *Parser) token() Span {
+	return Span{p.pos(), p.end()}
+}
+
+// from start to the current token, which ends the node
+func (p *Parser) span(start Position) Span {
+	return Span{start, p.end()}
+}
+
+//  ============ errors
+
+// a diagnostic at the current token
+func (p *Parser) errorf(format string, args ...interface{}) error {
+	pos := p.pos()
+	if p.cur == EOL || p.cur == EOF {
+		pos = p.last
+	}
+	return &Diagnostic{Line: pos.Line, Column: pos.Column, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *Parser) report(err error) {
+	var d *Diagnostic
+	if !errors.As(err, &d) {
+		d = p.errorf("%v", err).(*Diagnostic)
+	}
+	p.diagnostics = append(p.diagnostics, *d)
+}
+
+// skip the rest of a broken statement: up to the end of its line,
+// together with any block it opened. In a block, the } of the
+// block ends the statement too.
+func (p *Parser) synchronize(inBlock bool) {
+	depth := 0
+	for p.cur != EOF {
+		switch {
+		case p.cur == EOL && depth == 0:
+			return
+		case p.checkCur(LBRACE) || p.checkCur(LPAREN) || p.checkCur(LBRACKET):
+			depth++
+		case p.checkCur(RBRACE) || p.checkCur(RPAREN) || p.checkCur(RBRACKET):
+			if depth == 0 && inBlock && p.checkCur(RBRACE) {
+				return
+			}
+			if depth > 0 {
+				depth--
+			}
+		}
+		p.advance()
+	}
+}
+
+// how a token is called in error messages
+func describe(tk Token) string {
+	switch tk {
+	case EOL:
+		return "end of line"
+	case EOF:
+		return "end of file"
+	}
+	if tk.Type() == STRING {
+		return strconv.Quote(tk.Literal())
+	}
+	return "'" + tk.Literal() + "'"
 }
 
 //  ============ helper functions
@@ -489,16 +843,25 @@ func (p *Parser) peekPrecedence() int {
 	return LOWEST
 }
 
-func (p *Parser) skip(s string) {
-	if p.checkCur(s) {
-		p.advance()
-	} else {
-		log.Panicf("skip: want %s, got <%s %s>", s, p.cur.Type(), p.cur.Literal())
+func (p *Parser) skip(s string) error {
+	if !p.checkCur(s) {
+		return p.errorf("want '%s', got %s", s, describe(p.cur))
+	}
+	p.advance()
+	return nil
+}
 
+// a hash literal may span several lines
+func (p *Parser) skipEOL() {
+	for p.cur == EOL {
+		p.advance()
 	}
 }
 
 func (p *Parser) advance() {
+	if p.cur != nil && p.cur != EOL && p.cur != EOF {
+		p.last = p.end()
+	}
 	p.cur = p.lexer.Read()
 	p.next = p.lexer.Peek(0)
 }
@@ -515,11 +878,12 @@ func (p *Parser) curPrecedence() int {
 	return LOWEST
 }
 
+// a string is never syntax, even "}"
 func (p *Parser) checkCur(expt string) bool {
-	log.Printf("checkCur %v, %v\n", p.cur.Literal(), expt)
-	return p.cur.Literal() == expt
+	debugLog.Printf("checkCur %v, %v\n", p.cur.Literal(), expt)
+	return p.cur.Type() != STRING && p.cur.Literal() == expt
 }
 
 func (p *Parser) checkNext(expt string) bool {
-	return p.next.Literal() == expt
+	return p.next.Type() != STRING && p.next.Literal() == expt
 }
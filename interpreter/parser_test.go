@@ -1,6 +1,7 @@
 package interpreter
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -138,3 +139,137 @@ func TestParser_Parse7(t *testing.T) {
 	t.Log("================ parse ERROR =============\n ", err)
 
 }
+
+func TestParser_Hash(t *testing.T) {
+	input := `h := {"a": 1, "b": {}}
+	h["c"] = {
+		1: [1, 2],
+		"x": 1 + 2
+	}
+	if h["a"] == 1 {
+		h
+	}`
+	parser := NewParser(NewLexer(strings.NewReader(input)))
+	root, err := parser.Parse(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmts := root.(*Program).Statements
+	if len(stmts) != 3 {
+		t.Fatalf("want 3 statements, got %d", len(stmts))
+	}
+	if hash, ok := stmts[0].(*DefineExpression).Expr.(*HashLiteral); !ok || len(hash.Keys) != 2 {
+		t.Errorf("want a hash of 2 pairs, got %v", stmts[0])
+	}
+	if assign, ok := stmts[1].(*IndexAssignExpression); !ok || len(assign.Expr.(*HashLiteral).Keys) != 2 {
+		t.Errorf("want an index assignment of a hash, got %v", stmts[1])
+	}
+	if _, ok := stmts[2].(*IfExpression); !ok {
+		t.Errorf("want an if expression, got %v", stmts[2])
+	}
+}
+
+func TestParser_Diagnostics(t *testing.T) {
+	input := `x := 1 +
+y := (2 + 3
+z := 4 5
+f := func(a) {
+	b := a *
+	a
+}
+w := é
+1 := 2
+ok := 1`
+	parser := NewParser(NewLexer(strings.NewReader(input)))
+	root, diags := parser.Parse(nil)
+	want := []Diagnostic{
+		{1, 9, "want an expression, got end of line"},
+		{2, 12, "want ')', got end of line"},
+		{3, 8, "unexpected '5' after the statement"},
+		{5, 10, "want an expression, got end of line"},
+		{8, 6, "illegal character 'é'"},
+		{9, 1, "cannot define '1'"},
+	}
+	if !reflect.DeepEqual(diags, want) {
+		t.Fatalf("want %v,\ngot %v", want, diags)
+	}
+	// the parser goes on after a broken statement
+	stmts := root.(*Program).Statements
+	if len(stmts) != 2 || stmts[1].String() != (&DefineExpression{Ident: &IdentifierLiteral{Key: "ok"}, Expr: &IntegerLiteral{Key: 1}}).String() {
+		t.Errorf("want f and ok to be parsed, got %v", stmts)
+	}
+}
+
+func TestParser_DiagnosticsEOF(t *testing.T) {
+	tests := []string{
+		"f := func(a) {\n a",
+		"if true {\n",
+		"g := func(a b) {\n}",
+		"h := func(a) { a } hope { 1 -> }",
+		"[1, 2",
+	}
+	for _, input := range tests {
+		parser := NewParser(NewLexer(strings.NewReader(input)))
+		if _, diags := parser.Parse(nil); len(diags) != 1 {
+			t.Errorf("%q: want 1 diagnostic, got %v", input, diags)
+		}
+	}
+}
+
+// each construct ends on its last token, so they nest on one line
+func TestParser_OneLine(t *testing.T) {
+	input := `f := func(a) { if a { if a { 1 } else { 2 } } else { 3 } }
+[f(true), f(false)]
+g := func(x) { x } hope { 1 -> 1 }
+s := ""`
+	parser := NewParser(NewLexer(strings.NewReader(input)))
+	root, diags := parser.Parse(nil)
+	if diags != nil {
+		t.Fatal(diags)
+	}
+	if stmts := root.(*Program).Statements; len(stmts) != 4 {
+		t.Fatalf("want 4 statements, got %d: %v", len(stmts), stmts)
+	}
+}
+
+func TestParser_Positions(t *testing.T) {
+	input := `x := add(1, "ab") * -y
+f := func(a int) {
+	a[0]
+} hope {
+	[1] -> 1
+}`
+	parser := NewParser(NewFileLexer("pos.ho", strings.NewReader(input)))
+	root, diags := parser.Parse(nil)
+	if diags != nil {
+		t.Fatal(diags)
+	}
+	pos := func(line, column int) Position { return Position{File: "pos.ho", Line: line, Column: column} }
+	def := root.(*Program).Statements[0].(*DefineExpression)
+	infix := def.Expr.(*InfixExpression)
+	call := infix.Left.(*CallExpression)
+	fn := root.(*Program).Statements[1].(*DefineExpression).Expr.(*FunctionLiteral)
+	index := fn.Execute.Statements[0].(*IndexExpression)
+	tests := []struct {
+		node       ASTNode
+		start, end Position
+	}{
+		{root, pos(1, 1), pos(6, 2)},
+		{def, pos(1, 1), pos(1, 23)},
+		{def.Ident, pos(1, 1), pos(1, 2)},
+		{infix, pos(1, 6), pos(1, 23)},
+		{call, pos(1, 6), pos(1, 18)},
+		{call.Arguments[1], pos(1, 13), pos(1, 17)},
+		{infix.Right, pos(1, 21), pos(1, 23)},
+		{fn, pos(2, 6), pos(6, 2)},
+		{fn.Execute, pos(2, 18), pos(4, 2)},
+		{index, pos(3, 2), pos(3, 6)},
+		{fn.Hopes, pos(4, 8), pos(6, 2)},
+		{fn.Hopes.HopeExpressions[0], pos(5, 2), pos(5, 10)},
+	}
+	for _, tt := range tests {
+		if tt.node.Pos() != tt.start || tt.node.End() != tt.end {
+			t.Errorf("%s: want %v-%v, got %v-%v", tt.node, tt.start, tt.end, tt.node.Pos(), tt.node.End())
+		}
+	}
+}
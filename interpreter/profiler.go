@@ -0,0 +1,307 @@
+package interpreter
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ====== profiler
+// A Profiler is a CallTracer that records the call tree of a run:
+// how often each call path was taken, the instructions executed and the
+// wall time spent in it. Nothing is sampled, every instruction counts.
+// Builtins run in the frame of their caller, their time is the caller's
+//
+//	p := NewProfiler("rules.ho")
+//	vm.SetTracer(p)
+//	err := vm.Run()
+//	p.Stop()
+//	p.WriteSummary(os.Stderr)
+type Profiler struct {
+	file string // for the functions in a pprof profile
+
+	root, cur *callNode
+	start     time.Time
+	last      time.Time // of the last call or return
+	duration  time.Duration
+}
+
+// one call path, from <main> down to fn
+type callNode struct {
+	fn       *CompiledFunction
+	parent   *callNode
+	children map[*CompiledFunction]*callNode
+
+	calls        int64
+	instructions int64 // the ones of fn itself
+	wall         time.Duration
+}
+
+// FunctionProfile sums up the calls of one function.
+// The ones with Self leave out the functions it called
+type FunctionProfile struct {
+	Name             string
+	Calls            int64
+	SelfInstructions int64
+	Instructions     int64
+	SelfTime         time.Duration
+	Time             time.Duration
+}
+
+func NewProfiler(file string) *Profiler {
+	return &Profiler{file: file}
+}
+
+func (p *Profiler) Step(fn *CompiledFunction, pc int, stack []Object) {
+	if p.cur == nil {
+		p.root = &callNode{fn: fn, calls: 1}
+		p.cur = p.root
+		p.start = time.Now()
+		p.last = p.start
+	}
+	p.cur.instructions++
+}
+
+func (p *Profiler) Call(fn *CompiledFunction) {
+	p.tick()
+	child := p.cur.children[fn]
+	if child == nil {
+		child = &callNode{fn: fn, parent: p.cur}
+		if p.cur.children == nil {
+			p.cur.children = make(map[*CompiledFunction]*callNode)
+		}
+		p.cur.children[fn] = child
+	}
+	child.calls++
+	p.cur = child
+}
+
+func (p *Profiler) Return(fn *CompiledFunction) {
+	p.tick()
+	if p.cur.parent != nil {
+		p.cur = p.cur.parent
+	}
+}
+
+// the time since the last call or return was spent in the current call
+func (p *Profiler) tick() {
+	now := time.Now()
+	p.cur.wall += now.Sub(p.last)
+	p.last = now
+}
+
+// Stop ends the profile once the run is over,
+// the time since the last call or return goes to the call still running
+func (p *Profiler) Stop() {
+	if p.cur == nil {
+		return
+	}
+	p.tick()
+	p.duration = p.last.Sub(p.start)
+}
+
+// Functions are the profiles of the functions called, and <main>,
+// the ones with the most self time first
+func (p *Profiler) Functions() []FunctionProfile {
+	profiles := map[*CompiledFunction]*FunctionProfile{}
+	running := map[*CompiledFunction]int{} // a recursive call is in the time of the outer one
+	var walk func(n *callNode) (int64, time.Duration)
+	walk = func(n *callNode) (int64, time.Duration) {
+		fp := profiles[n.fn]
+		if fp == nil {
+			fp = &FunctionProfile{Name: n.fn.DisplayName()}
+			profiles[n.fn] = fp
+		}
+		fp.Calls += n.calls
+		fp.SelfInstructions += n.instructions
+		fp.SelfTime += n.wall
+
+		instructions, wall := n.instructions, n.wall
+		running[n.fn]++
+		for _, child := range n.children {
+			i, w := walk(child)
+			instructions, wall = instructions+i, wall+w
+		}
+		running[n.fn]--
+		if running[n.fn] == 0 {
+			fp.Instructions += instructions
+			fp.Time += wall
+		}
+		return instructions, wall
+	}
+	if p.root != nil {
+		walk(p.root)
+	}
+
+	result := []FunctionProfile{}
+	for _, fp := range profiles {
+		result = append(result, *fp)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].SelfTime != result[j].SelfTime {
+			return result[i].SelfTime > result[j].SelfTime
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// WriteSummary writes a line per function, the hottest first
+//
+//	function  calls  self instructions  instructions  self time  time
+//	fib       177    1593               1593          84µs       84µs
+func (p *Profiler) WriteSummary(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "function\tcalls\tself instructions\tinstructions\tself time\ttime")
+	for _, fp := range p.Functions() {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%v\t%v\n",
+			fp.Name, fp.Calls, fp.SelfInstructions, fp.Instructions, fp.SelfTime, fp.Time)
+	}
+	return tw.Flush()
+}
+
+// ------ pprof
+// WriteProfile writes the call tree as a gzipped pprof profile,
+// go tool pprof shows it. Every call path is a sample with the
+// values calls, instructions and wall nanoseconds, wall is the default.
+// A Ho function is one location, at the line it starts
+func (p *Profiler) WriteProfile(w io.Writer) error {
+	strs := newStringTable()
+	var profile protoBuffer
+	for _, st := range [][2]string{{"calls", "count"}, {"instructions", "count"}, {"wall", "nanoseconds"}} {
+		var valueType protoBuffer
+		valueType.int(1, strs.index(st[0]))
+		valueType.int(2, strs.index(st[1]))
+		profile.message(1, valueType)
+	}
+
+	ids := map[*CompiledFunction]uint64{}
+	var fns []*CompiledFunction
+	var walk func(n *callNode)
+	walk = func(n *callNode) {
+		if ids[n.fn] == 0 {
+			fns = append(fns, n.fn)
+			ids[n.fn] = uint64(len(fns))
+		}
+		if n.calls != 0 || n.instructions != 0 || n.wall != 0 {
+			var sample, locations, values protoBuffer
+			for c := n; c != nil; c = c.parent {
+				locations.varint(ids[c.fn])
+			}
+			values.varint(uint64(n.calls))
+			values.varint(uint64(n.instructions))
+			values.varint(uint64(n.wall.Nanoseconds()))
+			sample.bytes(1, locations.data)
+			sample.bytes(2, values.data)
+			profile.message(2, sample)
+		}
+		// the same order every time
+		children := make([]*callNode, 0, len(n.children))
+		for _, child := range n.children {
+			children = append(children, child)
+		}
+		sort.Slice(children, func(i, j int) bool {
+			return children[i].fn.DisplayName() < children[j].fn.DisplayName()
+		})
+		for _, child := range children {
+			walk(child)
+		}
+	}
+	if p.root != nil {
+		walk(p.root)
+	}
+
+	for i, fn := range fns {
+		id := uint64(i + 1)
+		line := int64(0)
+		if len(fn.Lines) > 0 {
+			line = int64(fn.Lines[0].Line)
+		}
+		var location, locationLine, function protoBuffer
+		locationLine.uint(1, id)
+		locationLine.int(2, line)
+		location.uint(1, id)
+		location.message(4, locationLine)
+		profile.message(4, location)
+
+		// pprof takes <...> for C++ template arguments and drops it
+		name := strings.Trim(fn.DisplayName(), "<>")
+		function.uint(1, id)
+		function.int(2, strs.index(name))
+		function.int(3, strs.index(name))
+		function.int(4, strs.index(p.file))
+		function.int(5, line)
+		profile.message(5, function)
+	}
+
+	defaultType := strs.index("wall")
+	for _, s := range strs.strings {
+		profile.bytes(6, []byte(s))
+	}
+	profile.int(9, p.start.UnixNano())
+	profile.int(10, p.duration.Nanoseconds())
+	profile.int(14, defaultType)
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(profile.data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+type stringTable struct {
+	strings []string
+	indices map[string]int64
+}
+
+// index 0 is always ""
+func newStringTable() *stringTable {
+	return &stringTable{strings: []string{""}, indices: map[string]int64{"": 0}}
+}
+
+func (t *stringTable) index(s string) int64 {
+	i, ok := t.indices[s]
+	if !ok {
+		i = int64(len(t.strings))
+		t.strings = append(t.strings, s)
+		t.indices[s] = i
+	}
+	return i
+}
+
+// just the protobuf wire format a profile needs: varints and
+// length-delimited fields, a packed repeated field is its varints as bytes
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protoBuffer) uint(field int, x uint64) {
+	b.varint(uint64(field) << 3)
+	b.varint(x)
+}
+
+func (b *protoBuffer) int(field int, x int64) {
+	b.uint(field, uint64(x))
+}
+
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *protoBuffer) message(field int, m protoBuffer) {
+	b.bytes(field, m.data)
+}
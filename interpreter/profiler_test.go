@@ -0,0 +1,132 @@
+package interpreter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+)
+
+const profileProgram = `fib := func(n int) int {
+	n <= 2 ? n : fib(n-1) + fib(n-2)
+}
+twice := func(n int) int {
+	fib(n) + fib(n-1)
+}
+twice(5) + len("ab")`
+
+func TestProfiler(t *testing.T) {
+	vm := NewVM(compileString(t, profileProgram))
+	p := NewProfiler("fib.ho")
+	vm.SetTracer(p)
+	if err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	p.Stop()
+
+	profiles := map[string]FunctionProfile{}
+	for _, fp := range p.Functions() {
+		profiles[fp.Name] = fp
+	}
+	if len(profiles) != 3 {
+		t.Fatalf("got %v, want fib, twice and <main>", p.Functions())
+	}
+	main, twice, fib := profiles["<main>"], profiles["twice"], profiles["fib"]
+	// fib(5) calls fib 9 times, fib(4) 5 times
+	if main.Calls != 1 || twice.Calls != 1 || fib.Calls != 14 {
+		t.Errorf("calls: <main> %d, twice %d, fib %d", main.Calls, twice.Calls, fib.Calls)
+	}
+	if main.Instructions != int64(vm.steps) {
+		t.Errorf("<main> has %d instructions, the run %d", main.Instructions, vm.steps)
+	}
+	if fib.Instructions != fib.SelfInstructions {
+		t.Errorf("fib is counted again for its recursive calls: %d, %d", fib.Instructions, fib.SelfInstructions)
+	}
+	if twice.Instructions != twice.SelfInstructions+fib.Instructions {
+		t.Errorf("twice has %d instructions, %d of its own and %d of fib", twice.Instructions, twice.SelfInstructions, fib.Instructions)
+	}
+	if main.SelfInstructions+twice.Instructions != main.Instructions {
+		t.Errorf("<main> has %d instructions, %d of its own", main.Instructions, main.SelfInstructions)
+	}
+	if main.Time < twice.Time || twice.Time < fib.Time || fib.Time <= 0 {
+		t.Errorf("times: <main> %v, twice %v, fib %v", main.Time, twice.Time, fib.Time)
+	}
+
+	var summary bytes.Buffer
+	if err := p.WriteSummary(&summary); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(summary.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "function  calls  self instructions") {
+		t.Errorf("summary:\n%s", summary.String())
+	}
+}
+
+func TestProfiler_WriteProfile(t *testing.T) {
+	vm := NewVM(compileString(t, profileProgram))
+	p := NewProfiler("fib.ho")
+	vm.SetTracer(p)
+	if err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	p.Stop()
+
+	var out bytes.Buffer
+	if err := p.WriteProfile(&out); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the top level fields of the profile, by field number
+	fields := map[int][][]byte{}
+	for len(data) > 0 {
+		key, n := readVarint(data)
+		data = data[n:]
+		field, wire := int(key>>3), key&7
+		switch wire {
+		case 0:
+			_, n = readVarint(data)
+			data = data[n:]
+			fields[field] = append(fields[field], nil)
+		case 2:
+			length, n := readVarint(data)
+			fields[field] = append(fields[field], data[n:n+int(length)])
+			data = data[n+int(length):]
+		default:
+			t.Fatalf("wire type %d of field %d", wire, field)
+		}
+	}
+	var strs []string
+	for _, s := range fields[6] {
+		strs = append(strs, string(s))
+	}
+	expected := []string{"", "calls", "count", "instructions", "wall", "nanoseconds", "main", "fib.ho", "twice", "fib"}
+	if strings.Join(strs, ",") != strings.Join(expected, ",") {
+		t.Errorf("string table %q, want %q", strs, expected)
+	}
+	// a sample per call path: <main>, twice and fib 1 to 4 calls deep
+	if len(fields[1]) != 3 || len(fields[2]) != 6 || len(fields[4]) != 3 || len(fields[5]) != 3 {
+		t.Errorf("%d sample types, %d samples, %d locations and %d functions",
+			len(fields[1]), len(fields[2]), len(fields[4]), len(fields[5]))
+	}
+}
+
+func readVarint(data []byte) (uint64, int) {
+	x, shift := uint64(0), 0
+	for i, b := range data {
+		x |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return x, i + 1
+		}
+		shift += 7
+	}
+	return x, len(data)
+}
@@ -0,0 +1,418 @@
+package interpreter
+
+import "fmt"
+
+// ====== register code
+// The RegisterVM doesn't run the stack instructions, it translates
+// each function once to instructions that name their operands:
+//
+//	OpGetLocal 0, OpConstant 1, OpSub, OpSetLocal 0  ->  sub r0, r0, k1
+//
+// A function's registers are its locals, then one per stack slot,
+// so a call leaves callee and arguments where the stack VM would and
+// the arguments become the callee's first locals.
+// Locals and constants are read where they are, not pushed first:
+// an operand is a register if it is >= 0, the constant -1-x if not.
+// At jumps and their targets every slot is in its own register,
+// the paths that meet there agree on where the values are.
+// Only a local that some path reads before setting it is checked
+
+type rop uint8
+
+const (
+	rMove       rop = iota // a = b
+	rGetGlobal             // a = globals[b]
+	rSetGlobal             // globals[a] = b
+	rGetBuiltin            // a = builtins[b]
+	rCheck                 // a is set, a local that may be read before it is
+
+	// a = b op c
+	rAdd
+	rSub
+	rMult
+	rDiv
+	rMod
+	rLt
+	rGt
+	rLte
+	rGte
+	rEq
+	rNeq
+
+	// a = op b
+	rMinus
+	rBang
+
+	rJump        // to a
+	rJumpIfFalse // to a unless b
+
+	// to a unless b op c, a comparison and the jump after it
+	rJumpUnlessLt
+	rJumpUnlessGt
+	rJumpUnlessLte
+	rJumpUnlessGte
+	rJumpUnlessEq
+	rJumpUnlessNeq
+
+	rCall     // the callee in a, b arguments after it
+	rTailCall // rCall in the caller's frame
+	rReturn   // a
+
+	rArray    // a = the b registers from a on
+	rHash     // a = the 2*b registers from a on, key and value
+	rIndex    // a = b[c]
+	rSetIndex // a[b] = c
+	rHope     // hope case a: b, what the call got, is c
+)
+
+var rnames = [...]string{"move", "getglobal", "setglobal", "getbuiltin", "check",
+	"add", "sub", "mult", "div", "mod", "lt", "gt", "lte", "gte", "eq", "neq",
+	"minus", "bang", "jump", "jumpiffalse",
+	"jumpunlesslt", "jumpunlessgt", "jumpunlesslte", "jumpunlessgte", "jumpunlesseq", "jumpunlessneq", "call", "tailcall", "return",
+	"array", "hash", "index", "setindex", "hope"}
+
+// the register instruction of each operator
+var rinfix = map[Opcode]rop{OpAdd: rAdd, OpSub: rSub, OpMult: rMult, OpDiv: rDiv, OpMod: rMod,
+	OpLt: rLt, OpGt: rGt, OpLte: rLte, OpGte: rGte, OpEq: rEq, OpNeq: rNeq,
+	OpMinus: rMinus, OpBang: rBang}
+
+// and the operator of each, for the values the VM hands to VM.infix
+var roperators = func() (ops [rHope + 1]Opcode) {
+	for op, r := range rinfix {
+		ops[r] = op
+	}
+	return ops
+}()
+
+type rinstr struct {
+	op      rop
+	a, b, c int32
+	pc      int32 // of the stack instruction, for the line of an error
+}
+
+func (in rinstr) String() string {
+	return fmt.Sprintf("%s %d %d %d", rnames[in.op], in.a, in.b, in.c)
+}
+
+type rfunction struct {
+	fn        *CompiledFunction
+	code      []rinstr
+	frameSize int // locals and stack slots
+}
+
+// a register, an integer is kept unboxed in i when obj is nil.
+// For a *CompiledFunction i is the index of its rfunction
+type rvalue struct {
+	obj Object
+	i   int
+}
+
+// what a global or local holds before it is set, the zero rvalue
+// is the integer 0. It is nil with an i that a nil made by the
+// program, always unboxed with i 0, doesn't have
+var unset = rvalue{obj: NullObj, i: -1}
+
+// ------ translation
+// the constants of all functions, the ones of the bytecode first,
+// then nil
+type translator struct {
+	consts []rvalue
+	extra  map[Object]int32
+}
+
+// the operand that reads obj
+func (t *translator) constant(obj Object) int32 {
+	k, ok := t.extra[obj]
+	if !ok {
+		k = int32(len(t.consts))
+		t.consts = append(t.consts, rvalue{obj: obj})
+		t.extra[obj] = k
+	}
+	return -1 - k
+}
+
+// the state of translating one function
+type rfunctionBuilder struct {
+	code   []rinstr
+	locals int
+	stack  []int32 // the operand of each stack slot
+	max    int
+	pc     int // of the stack instruction being translated
+
+	// the instruction that just put its result in the top slot's
+	// register, -1 if there is none. Setting a local can make it
+	// write to the local instead
+	produced int
+}
+
+func (b *rfunctionBuilder) home(slot int) int32 {
+	return int32(b.locals + slot)
+}
+
+func (b *rfunctionBuilder) emit(op rop, a, bb, c int32) int {
+	b.code = append(b.code, rinstr{op: op, a: a, b: bb, c: c, pc: int32(b.pc)})
+	b.produced = -1
+	return len(b.code) - 1
+}
+
+func (b *rfunctionBuilder) push(x int32) {
+	b.stack = append(b.stack, x)
+	if len(b.stack) > b.max {
+		b.max = len(b.stack)
+	}
+}
+
+func (b *rfunctionBuilder) pop() int32 {
+	x := b.stack[len(b.stack)-1]
+	b.stack = b.stack[:len(b.stack)-1]
+	return x
+}
+
+// emits op with its result in the next slot's register
+func (b *rfunctionBuilder) pushResult(op rop, x, y int32) {
+	dst := b.home(len(b.stack))
+	i := b.emit(op, dst, x, y)
+	b.push(dst)
+	b.produced = i
+}
+
+// moves the slots from the first on to their own registers
+func (b *rfunctionBuilder) flush(first int) {
+	for slot := first; slot < len(b.stack); slot++ {
+		if home := b.home(slot); b.stack[slot] != home {
+			b.emit(rMove, home, b.stack[slot], 0)
+			b.stack[slot] = home
+		}
+	}
+}
+
+func (t *translator) function(fn *CompiledFunction) (*rfunction, error) {
+	ins := fn.Instructions
+	heights, end, err := stackHeights(ins)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn.DisplayName(), err)
+	}
+	targets := map[int]bool{}
+	for pc := range heights {
+		if op := Opcode(ins[pc]); op == OpJump || op == OpJumpIfFalse {
+			targets[ins.readUint16(pc+1)] = true
+		}
+	}
+
+	set := setLocals(ins, fn.NumParas)
+	b := &rfunctionBuilder{locals: fn.NumLocals, produced: -1}
+	at := map[int]int{} // stack pc -> register pc
+	var jumps []int     // the register jumps, still to the stack pc
+	live := true        // the previous instruction goes on to the next one
+	for pc := 0; pc <= len(ins); {
+		height, reached := heights[pc]
+		if pc == len(ins) {
+			height, reached = end, end >= 0
+		}
+		if reached && (targets[pc] || !live) {
+			if live {
+				b.flush(0)
+			}
+			b.stack = b.stack[:0]
+			for slot := 0; slot < height; slot++ {
+				b.push(b.home(slot))
+			}
+			at[pc] = len(b.code)
+			b.produced = -1
+			live = true
+		}
+		if pc == len(ins) {
+			break
+		}
+		op := Opcode(ins[pc])
+		def, err := Lookup(op)
+		if err != nil {
+			return nil, err
+		}
+		if !reached {
+			pc += def.Size()
+			continue
+		}
+		if len(b.stack) != height {
+			return nil, fmt.Errorf("%s: %d slots at pc=%d, want %d", fn.DisplayName(), len(b.stack), pc, height)
+		}
+		b.pc = pc
+		switch op {
+		case OpConstant:
+			b.push(int32(-1 - ins.readUint16(pc+1)))
+		case OpConstantWide:
+			b.push(int32(-1 - ins.readUint32(pc+1)))
+		case OpNull:
+			b.push(t.constant(NullObj))
+		case OpGetBuiltin:
+			b.pushResult(rGetBuiltin, int32(ins.readUint8(pc+1)), 0)
+		case OpPop:
+			b.pop()
+
+		case OpGetLocal:
+			local := int32(ins.readUint8(pc + 1))
+			if s := set[pc]; !s.has(int(local)) {
+				b.emit(rCheck, local, 0, 0)
+			}
+			b.push(local)
+		case OpSetLocal:
+			local := int32(ins.readUint8(pc + 1))
+			x, produced := b.pop(), b.produced
+			// the slots that still read the old value
+			for slot, y := range b.stack {
+				if y == local {
+					b.emit(rMove, b.home(slot), local, 0)
+					b.stack[slot] = b.home(slot)
+				}
+			}
+			if produced >= 0 && produced == len(b.code)-1 && b.code[produced].a == x {
+				b.code[produced].a = local
+			} else {
+				b.emit(rMove, local, x, 0)
+			}
+		case OpGetGlobal:
+			b.pushResult(rGetGlobal, int32(ins.readUint16(pc+1)), 0)
+		case OpSetGlobal:
+			b.emit(rSetGlobal, int32(ins.readUint16(pc+1)), b.pop(), 0)
+
+		case OpAdd, OpSub, OpMult, OpDiv, OpMod, OpLt, OpGt, OpLte, OpGte, OpEq, OpNeq:
+			y := b.pop()
+			x := b.pop()
+			b.pushResult(rinfix[op], x, y)
+		case OpMinus, OpBang:
+			b.pushResult(rinfix[op], b.pop(), 0)
+
+		case OpJump:
+			b.flush(0)
+			jumps = append(jumps, b.emit(rJump, int32(ins.readUint16(pc+1)), 0, 0))
+			live = false
+		case OpJumpIfFalse:
+			cnd, produced := b.pop(), b.produced
+			target := int32(ins.readUint16(pc + 1))
+			if produced >= 0 && produced == len(b.code)-1 && b.code[produced].a == cnd &&
+				b.code[produced].op >= rLt && b.code[produced].op <= rNeq {
+				// the comparison doesn't need to leave its result,
+				// the moves of the flush don't touch its operands
+				cmp := b.code[produced]
+				b.code = b.code[:produced]
+				b.flush(0)
+				jumps = append(jumps, b.emit(cmp.op-rLt+rJumpUnlessLt, target, cmp.b, cmp.c))
+				b.code[len(b.code)-1].pc = cmp.pc
+				break
+			}
+			b.flush(0)
+			jumps = append(jumps, b.emit(rJumpIfFalse, target, cnd, 0))
+
+		case OpCall, OpTailCall:
+			n := ins.readUint8(pc + 1)
+			callee := len(b.stack) - n - 1
+			b.flush(callee)
+			call := rCall
+			if op == OpTailCall {
+				call = rTailCall
+			}
+			b.emit(call, b.home(callee), int32(n), 0)
+			b.stack = b.stack[:callee+1]
+		case OpReturnValue:
+			b.emit(rReturn, b.pop(), 0, 0)
+			live = false
+
+		case OpArray, OpHash:
+			n := ins.readUint16(pc + 1)
+			kind, first := rArray, len(b.stack)-n
+			if op == OpHash {
+				kind, first = rHash, len(b.stack)-2*n
+			}
+			b.flush(first)
+			b.stack = b.stack[:first]
+			b.pushResult(kind, int32(n), 0)
+			// it reads the registers it writes to
+			b.produced = -1
+		case OpIndex:
+			idx := b.pop()
+			left := b.pop()
+			b.pushResult(rIndex, left, idx)
+		case OpSetIndex:
+			value := b.pop()
+			idx := b.pop()
+			b.emit(rSetIndex, b.pop(), idx, value)
+		case OpHope:
+			expected := b.pop()
+			b.emit(rHope, int32(ins.readUint8(pc+1)), b.pop(), expected)
+		default:
+			return nil, fmt.Errorf("%s: no register instruction for %s", fn.DisplayName(), def.Name)
+		}
+		pc += def.Size()
+	}
+	// <main> runs off the end with its result on the stack
+	if end >= 0 {
+		b.pc = len(ins)
+		b.emit(rReturn, b.stack[0], 0, 0)
+	}
+	for _, i := range jumps {
+		b.code[i].a = int32(at[int(b.code[i].a)])
+	}
+	return &rfunction{fn: fn, code: b.code, frameSize: b.locals + b.max}, nil
+}
+
+// ------ locals read before they are set
+// the locals, at most 256, as bits
+type localSet [4]uint64
+
+func (s *localSet) add(local int) {
+	s[local/64] |= 1 << (local % 64)
+}
+
+func (s *localSet) has(local int) bool {
+	return s[local/64]&(1<<(local%64)) != 0
+}
+
+// the locals set on every path to each instruction reached,
+// the parameters are set when the function starts
+func setLocals(ins Instructions, numParas int) map[int]localSet {
+	var start localSet
+	for i := 0; i < numParas; i++ {
+		start.add(i)
+	}
+	in := map[int]localSet{0: start}
+	work := []int{0}
+	for len(work) > 0 {
+		pc := work[len(work)-1]
+		work = work[:len(work)-1]
+		s := in[pc]
+		op := Opcode(ins[pc])
+		def, err := Lookup(op)
+		if err != nil {
+			continue
+		}
+		next := []int{pc + def.Size()}
+		switch op {
+		case OpSetLocal:
+			s.add(ins.readUint8(pc + 1))
+		case OpJump:
+			next = []int{ins.readUint16(pc + 1)}
+		case OpJumpIfFalse:
+			next = append(next, ins.readUint16(pc+1))
+		case OpReturnValue:
+			next = nil
+		}
+		// a path adds what it sets to the ones that all paths set
+		for _, to := range next {
+			if to >= len(ins) {
+				continue
+			}
+			old, seen := in[to]
+			meet := s
+			if seen {
+				for i := range meet {
+					meet[i] &= old[i]
+				}
+			}
+			if !seen || meet != old {
+				in[to] = meet
+				work = append(work, to)
+			}
+		}
+	}
+	return in
+}
@@ -0,0 +1,452 @@
+package interpreter
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// ====== register VM
+// A prototype of a VM that runs register code, see registerCode.go.
+// It takes the Bytecode the compiler makes, like NewVM, and gives
+// the same results and runtime errors:
+//
+//	vm := NewRegisterVM(bc)
+//	err := vm.Run()
+//	result := vm.LastPopped()
+//
+// What makes it faster than VM.Run:
+//   - an instruction reads locals and constants where they are,
+//     n - 1 is one instruction and not three pushes and pops,
+//   - integers are unboxed in the registers, adding two of them
+//     allocates nothing,
+//   - frames are values, a call allocates nothing.
+//
+// It has no tracer, limits or context yet
+type RegisterVM struct {
+	consts  []rvalue
+	globals []rvalue
+	funcs   []*rfunction // by the index in an rvalue
+	fnIndex map[*CompiledFunction]int
+	main    *rfunction
+
+	regs   []rvalue
+	frames []rframe // the callers of the running function
+	result rvalue
+
+	builtins *Builtins
+
+	// failed hopes are reported here
+	out io.Writer
+
+	// runs the operators on values that aren't two integers
+	scratch *VM
+
+	// what went wrong translating the bytecode, Run returns it
+	err error
+}
+
+type rframe struct {
+	fn *rfunction
+	ip int // the instruction after the call
+	bp int
+}
+
+func NewRegisterVM(bc Bytecode) *RegisterVM {
+	vm := &RegisterVM{
+		globals:  make([]rvalue, VariableSize),
+		fnIndex:  make(map[*CompiledFunction]int),
+		regs:     make([]rvalue, StackSize),
+		out:      os.Stdout,
+		builtins: standardBuiltins,
+		scratch:  &VM{stack: make([]Object, 1), maxStack: 1},
+	}
+	for i := range vm.globals {
+		vm.globals[i] = unset
+	}
+	for _, obj := range bc.constants {
+		if fn, ok := obj.(*CompiledFunction); ok {
+			vm.fnIndex[fn] = len(vm.fnIndex)
+		}
+	}
+	t := &translator{extra: make(map[Object]int32)}
+	for _, obj := range bc.constants {
+		// nil and Booleans made by the compiler are the shared ones
+		switch c := obj.(type) {
+		case *Boolean:
+			obj = nativeBool(c.Value)
+		case *Null:
+			obj = NullObj
+		}
+		t.consts = append(t.consts, vm.unbox(obj))
+	}
+
+	vm.funcs = make([]*rfunction, len(vm.fnIndex))
+	for fn, i := range vm.fnIndex {
+		if vm.funcs[i], vm.err = t.function(fn); vm.err != nil {
+			return vm
+		}
+	}
+	main := &CompiledFunction{Instructions: bc.instructions, Lines: bc.lines, Name: "<main>"}
+	vm.main, vm.err = t.function(main)
+	vm.consts = t.consts
+	return vm
+}
+
+// the value of a register as an Object
+func box(v rvalue) Object {
+	if v.obj == nil {
+		return newInt(v.i)
+	}
+	return v.obj
+}
+
+func (vm *RegisterVM) unbox(obj Object) rvalue {
+	switch o := obj.(type) {
+	case nil:
+		return rvalue{obj: NullObj}
+	case *Integer:
+		return rvalue{i: o.Value}
+	case *CompiledFunction:
+		if i, ok := vm.fnIndex[o]; ok {
+			return rvalue{obj: o, i: i}
+		}
+	}
+	return rvalue{obj: obj}
+}
+
+// the last value popped off the stack, after Run it is the final result
+func (vm *RegisterVM) LastPopped() Object {
+	return box(vm.result)
+}
+
+// Run executes the bytecode. A Ho program that goes wrong doesn't panic,
+// Run returns a *RuntimeError with the source line and the call stack.
+func (vm *RegisterVM) Run() (err error) {
+	if vm.err != nil {
+		return vm.err
+	}
+	fn, bp, ip := vm.main, 0, 0
+	code, regs, consts := fn.code, vm.regs, vm.consts
+	vm.frames = vm.frames[:0]
+
+	// a register is read with operand(x), the hot cases do it inline
+	operand := func(x int32) rvalue {
+		if x >= 0 {
+			return regs[bp+int(x)]
+		}
+		return consts[-1-x]
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = vm.runtimeError(fn, ip, e)
+			} else {
+				err = vm.runtimeError(fn, ip, fmt.Errorf("%v", r))
+			}
+		}
+	}()
+
+	for {
+		in := &code[ip]
+		ip++
+		var l, r rvalue
+		if in.op >= rAdd && in.op <= rNeq || in.op >= rJumpUnlessLt && in.op <= rJumpUnlessNeq {
+			if in.b >= 0 {
+				l = regs[bp+int(in.b)]
+			} else {
+				l = consts[-1-in.b]
+			}
+			if in.c >= 0 {
+				r = regs[bp+int(in.c)]
+			} else {
+				r = consts[-1-in.c]
+			}
+		}
+
+		switch in.op {
+		case rMove:
+			regs[bp+int(in.a)] = operand(in.b)
+
+		case rGetGlobal:
+			if regs[bp+int(in.a)] = vm.globals[in.b]; regs[bp+int(in.a)] == unset {
+				err = errUndefinedVariable
+			}
+
+		case rGetBuiltin:
+			regs[bp+int(in.a)] = vm.unbox(vm.builtins.get(int(in.b)))
+
+		case rCheck:
+			if regs[bp+int(in.a)] == unset {
+				err = errUndefinedVariable
+			}
+
+		case rSetGlobal:
+			vm.globals[in.a] = operand(in.b)
+
+		case rAdd:
+			if l.obj == nil && r.obj == nil {
+				if s := l.i + r.i; (s > l.i) == (r.i > 0) {
+					regs[bp+int(in.a)] = rvalue{i: s}
+					break
+				}
+			}
+			err = vm.infix(OpAdd, l, r, &regs[bp+int(in.a)])
+
+		case rSub:
+			if l.obj == nil && r.obj == nil {
+				if d := l.i - r.i; (d < l.i) == (r.i > 0) {
+					regs[bp+int(in.a)] = rvalue{i: d}
+					break
+				}
+			}
+			err = vm.infix(OpSub, l, r, &regs[bp+int(in.a)])
+
+		case rMult:
+			if l.obj == nil && r.obj == nil {
+				p := l.i * r.i
+				if l.i == 0 || (p/l.i == r.i && !(l.i == -1 && r.i == math.MinInt) && !(r.i == -1 && l.i == math.MinInt)) {
+					regs[bp+int(in.a)] = rvalue{i: p}
+					break
+				}
+			}
+			err = vm.infix(OpMult, l, r, &regs[bp+int(in.a)])
+
+		case rDiv:
+			if l.obj == nil && r.obj == nil && r.i != 0 && !(l.i == math.MinInt && r.i == -1) {
+				regs[bp+int(in.a)] = rvalue{i: l.i / r.i}
+				break
+			}
+			err = vm.infix(OpDiv, l, r, &regs[bp+int(in.a)])
+
+		case rMod:
+			if l.obj == nil && r.obj == nil && r.i != 0 {
+				regs[bp+int(in.a)] = rvalue{i: l.i % r.i}
+				break
+			}
+			err = vm.infix(OpMod, l, r, &regs[bp+int(in.a)])
+
+		case rLt, rGt, rLte, rGte, rEq, rNeq:
+			if l.obj == nil && r.obj == nil {
+				regs[bp+int(in.a)] = rvalue{obj: nativeBool(compareInts(in.op, l.i, r.i))}
+				break
+			}
+			err = vm.infix(roperators[in.op], l, r, &regs[bp+int(in.a)])
+
+		case rMinus, rBang:
+			x := operand(in.b)
+			if in.op == rMinus && x.obj == nil && x.i != math.MinInt {
+				regs[bp+int(in.a)] = rvalue{i: -x.i}
+				break
+			}
+			var obj Object
+			if obj, err = unary(roperators[in.op], box(x)); err == nil {
+				regs[bp+int(in.a)] = vm.unbox(obj)
+			}
+
+		case rJump:
+			ip = int(in.a)
+
+		case rJumpUnlessLt, rJumpUnlessGt, rJumpUnlessLte, rJumpUnlessGte, rJumpUnlessEq, rJumpUnlessNeq:
+			cmp := in.op - rJumpUnlessLt + rLt
+			if l.obj == nil && r.obj == nil {
+				if !compareInts(cmp, l.i, r.i) {
+					ip = int(in.a)
+				}
+				break
+			}
+			var cnd rvalue
+			if err = vm.infix(roperators[cmp], l, r, &cnd); err == nil && cnd.obj == False {
+				ip = int(in.a)
+			}
+
+		case rJumpIfFalse:
+			switch cnd := operand(in.b); cnd.obj {
+			case True:
+			case False:
+				ip = int(in.a)
+			default:
+				b, ok := cnd.obj.(*Boolean)
+				if !ok {
+					err = fmt.Errorf("condition must be a boolean, got %s", box(cnd).Type())
+				} else if !b.Value {
+					ip = int(in.a)
+				}
+			}
+
+		case rCall, rTailCall:
+			callee, n := bp+int(in.a), int(in.b)
+			switch f := regs[callee].obj.(type) {
+			case *Builtin:
+				args := make([]Object, n)
+				for i := range args {
+					args[i] = box(regs[callee+1+i])
+				}
+				regs[callee] = vm.unbox(f.Fn(args...))
+			case *CompiledFunction:
+				next := vm.funcs[regs[callee].i]
+				if err = vm.checkArguments(next, regs[callee+1:callee+1+n]); err != nil {
+					break
+				}
+				nextBP := callee + 1
+				if in.op == rTailCall {
+					copy(regs[bp-1:], regs[callee:callee+1+n])
+					nextBP = bp
+				}
+				if nextBP+next.frameSize >= len(regs) {
+					err = fmt.Errorf("stack overflow")
+					break
+				}
+				// the locals after the arguments, not what a call before left
+				for i := nextBP + n; i < nextBP+next.fn.NumLocals; i++ {
+					regs[i] = unset
+				}
+				if in.op == rCall {
+					vm.frames = append(vm.frames, rframe{fn: fn, ip: ip, bp: bp})
+				}
+				fn, code, ip, bp = next, next.code, 0, nextBP
+			default:
+				err = fmt.Errorf("calling non-function %s", box(regs[callee]).Type())
+			}
+
+		case rReturn:
+			result := operand(in.a)
+			if len(vm.frames) == 0 {
+				vm.result = result
+				return nil
+			}
+			regs[bp-1] = result
+			f := vm.frames[len(vm.frames)-1]
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			fn, code, ip, bp = f.fn, f.fn.code, f.ip, f.bp
+
+		case rArray:
+			first := bp + int(in.a)
+			elements := make([]Object, in.b)
+			for i := range elements {
+				elements[i] = box(regs[first+i])
+			}
+			regs[first] = rvalue{obj: &Array{Elements: elements}}
+
+		case rHash:
+			first := bp + int(in.a)
+			hash := NewHash()
+			for i := 0; i < int(in.b) && err == nil; i++ {
+				key, value := box(regs[first+2*i]), box(regs[first+2*i+1])
+				var hk HashKey
+				if hk, err = toHashKey(key); err == nil {
+					hash.Pairs[hk] = HashPair{Key: key, Value: value}
+				}
+			}
+			regs[first] = rvalue{obj: hash}
+
+		case rIndex:
+			left, idx := operand(in.b), operand(in.c)
+			if a, ok := left.obj.(*Array); ok && idx.obj == nil && idx.i >= 0 && idx.i < len(a.Elements) {
+				regs[bp+int(in.a)] = vm.unbox(a.Elements[idx.i])
+				break
+			}
+			var obj Object
+			if obj, err = vm.scratch.index(box(left), box(idx)); err == nil {
+				regs[bp+int(in.a)] = vm.unbox(obj)
+			}
+
+		case rSetIndex:
+			err = vm.scratch.setIndex(box(operand(in.a)), box(operand(in.b)), box(operand(in.c)))
+
+		case rHope:
+			expected, got := box(operand(in.c)), box(operand(in.b))
+			if !hopeEqual(expected, got) {
+				fmt.Fprintf(vm.out, "want %v, got %v in the %d-th test case\n", expected, got, in.a)
+			}
+		}
+		if err != nil {
+			return vm.runtimeError(fn, ip, err)
+		}
+	}
+}
+
+func compareInts(op rop, l, r int) bool {
+	switch op {
+	case rLt:
+		return l < r
+	case rGt:
+		return l > r
+	case rLte:
+		return l <= r
+	case rGte:
+		return l >= r
+	case rEq:
+		return l == r
+	}
+	return l != r
+}
+
+// runs op as VM.Run does, into dst
+func (vm *RegisterVM) infix(op Opcode, l, r rvalue, dst *rvalue) error {
+	vm.scratch.stackIdx = 0
+	if err := vm.scratch.infix(op, box(l), box(r)); err != nil {
+		return err
+	}
+	*dst = vm.unbox(vm.scratch.stack[0])
+	return nil
+}
+
+// checkArguments for the arguments in registers, an int argument of a
+// float parameter becomes a float. Only a wrong call boxes them all
+func (vm *RegisterVM) checkArguments(fn *rfunction, args []rvalue) error {
+	f := fn.fn
+	if len(args) == f.NumParas {
+		ok := true
+		for i, typ := range f.ParaTypes {
+			if typ == "" || (typ == "int" && args[i].obj == nil) {
+				continue
+			}
+			obj := box(args[i])
+			if !matchesAnnotation(typ, obj) {
+				ok = false
+				break
+			}
+			if typ == "float" && isInteger(obj) {
+				args[i] = rvalue{obj: &Float{Value: toFloat(obj)}}
+			}
+		}
+		if ok {
+			return nil
+		}
+	}
+	objs := make([]Object, len(args))
+	for i, arg := range args {
+		objs[i] = box(arg)
+	}
+	return checkArguments(f.DisplayName(), f.NumParas, f.ParaTypes, objs)
+}
+
+// the error at the instruction before ip in fn, with the Ho call stack
+func (vm *RegisterVM) runtimeError(fn *rfunction, ip int, err error) *RuntimeError {
+	if re, ok := err.(*RuntimeError); ok {
+		return re
+	}
+	stack := []StackEntry{{Function: fn.fn.DisplayName(), Line: fn.line(ip - 1)}}
+	for i := len(vm.frames) - 1; i >= 0; i-- {
+		f := vm.frames[i]
+		stack = append(stack, StackEntry{Function: f.fn.fn.DisplayName(), Line: f.fn.line(f.ip - 1)})
+	}
+	return &RuntimeError{
+		Message:  err.Error(),
+		Err:      err,
+		Line:     stack[0].Line,
+		Function: stack[0].Function,
+		Stack:    stack,
+	}
+}
+
+// the source line of the instruction at ip
+func (fn *rfunction) line(ip int) int {
+	if ip < 0 || ip >= len(fn.code) {
+		return 0
+	}
+	return lineAt(fn.fn.Lines, int(fn.code[ip].pc))
+}
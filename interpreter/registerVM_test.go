@@ -0,0 +1,174 @@
+package interpreter
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// the register VM runs every program of runVM as well,
+// these test what is particular to it
+
+func TestRegisterVM_Code(t *testing.T) {
+	tests := []struct {
+		input string
+		code  string // of f
+	}{
+		// the local and the constant are read where they are
+		{"f := func(n) { n - 1 }\nf(1)", "sub 1 0 -1; return 1 0 0"},
+		// the sum goes straight to the local
+		{"f := func(i) { i = i + 1\ni }\nf(1)", "add 0 0 -1; return 0 0 0"},
+		// the argument is moved next to the callee
+		{"g := func(n) { n }\nf := func(n) { g(n) + 1 }\nf(1)", "getglobal 1 0 0; move 2 0 0; call 1 1 0; add 1 1 -2; return 1 0 0"},
+		// the comparison jumps, the branches leave their value in the same register
+		{"f := func(n) { if n < 1 { 0 } else { n } }\nf(1)", "jumpunlesslt 3 0 -1; move 1 -2 0; jump 4 0 0; move 1 0 0; return 1 0 0"},
+		// set before the loop, the local is read without a check
+		{"f := func(n) { i := 0\nwhile i < n { i = i + 1 }\ni }\nf(1)", "move 1 -1 0; jumpunlesslt 4 1 0; add 1 1 -2; jump 1 0 0; return 1 0 0"},
+		// set on one branch only, it is checked where it is read
+		{"f := func(c) { if c { x := 1 }\nx }\nf(true)", "jumpiffalse 4 0 0; move 1 -1 0; move 2 -4 0; jump 5 0 0; move 2 -4 0; check 1 0 0; return 1 0 0"},
+		// builtins are looked up when the call runs
+		{"f := func(a) { len(a) }\nf([])", "getbuiltin 1 6 0; move 2 0 0; tailcall 1 1 0; return 1 0 0"},
+	}
+	for _, tt := range tests {
+		bc := compileString(t, tt.input)
+		vm := NewRegisterVM(bc)
+		if vm.err != nil {
+			t.Fatal(vm.err)
+		}
+		var f *rfunction
+		for _, fn := range vm.funcs {
+			if fn.fn.Name == "f" {
+				f = fn
+			}
+		}
+		var code []string
+		for _, in := range f.code {
+			code = append(code, in.String())
+		}
+		if got := strings.Join(code, "; "); got != tt.code {
+			t.Errorf("%q: code %s, want %s", tt.input, got, tt.code)
+		}
+	}
+}
+
+// a local set while the stack still reads it
+func TestRegisterVM_SetLocal(t *testing.T) {
+	vm := runVM(t, `f := func(i) {
+	a := [i, 0]
+	h := {"old": i}
+	i = i + 1
+	[a[0], h["old"], i]
+}
+f(1)`)
+	if got := vm.LastPopped().String(); got != "[1, 1, 2]" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestRegisterVM_Hopes(t *testing.T) {
+	parser := NewParser(NewLexer(strings.NewReader(`add := func(x int, y float) {
+	x + y
+} hope {
+	1, 2 -> 3.0
+	1, 1 -> 3
+}
+add(1, 2)`)))
+	node, _ := parser.Parse(nil)
+	c := NewCompilerWithState(false, NewGlobalSymbolTable(standardBuiltins), nil)
+	if err := c.Compile(node); err != nil {
+		t.Fatal(err)
+	}
+	vm := NewVM(c.Bytecode())
+	rvm := NewRegisterVM(c.Bytecode())
+	var out, rout bytes.Buffer
+	vm.out, rvm.out = &out, &rout
+	if err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rvm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if rout.String() != out.String() || out.String() != "want 3, got 2.0 in the 2-th test case\n" {
+		t.Errorf("register VM printed %q, VM %q", rout.String(), out.String())
+	}
+	if got := rvm.LastPopped().String(); got != "3.0" {
+		t.Errorf("got %s, want 3.0", got)
+	}
+}
+
+// the builtins of a Runtime, not the standard ones
+func TestRegisterVM_Builtins(t *testing.T) {
+	builtins := NewBuiltins()
+	if err := builtins.RegisterFunc("twice", func(x int) int { return 2 * x }); err != nil {
+		t.Fatal(err)
+	}
+	node, _ := NewParser(NewLexer(strings.NewReader(`f := func(x) { twice(x) + len("ab") }
+f(20)`))).Parse(nil)
+	c := NewCompilerWithState(true, NewGlobalSymbolTable(builtins), nil)
+	if err := c.Compile(node); err != nil {
+		t.Fatal(err)
+	}
+	vm := NewRegisterVM(c.Bytecode())
+	vm.builtins = builtins
+	if err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if got := vm.LastPopped().String(); got != "42" {
+		t.Errorf("got %s, want 42", got)
+	}
+}
+
+// the optimizer leaves jumps to jumps out and dead code behind
+func TestRegisterVM_Optimized(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`f := func(a) {
+	if a > 0 {
+		if a > 1 { "big" } else { "one" }
+	} else {
+		"none"
+	}
+}
+[f(0), f(1), f(2), 2 + 3 * 4]`, "[none, one, big, 14]"},
+		{`f := func(n) {
+	i := 0
+	while 1 < 2 { i = i + 1 }
+}
+x := 1 > 2 ? f(1) : 2
+while false { 1 }
+[x, true ? "yes" : "no"]`, "[2, yes]"},
+	}
+	for _, tt := range tests {
+		vm := NewRegisterVM(compileOptimized(t, tt.input))
+		if err := vm.Run(); err != nil {
+			t.Fatalf("%q: %v", tt.input, err)
+		}
+		if got := vm.LastPopped().String(); got != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+func benchmarkRegisterVM(b *testing.B, input string) {
+	bc := compileBenchmark(b, input)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := NewRegisterVM(bc).Run(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRegisterVM_Fib25(b *testing.B) {
+	benchmarkRegisterVM(b, fib25Program)
+}
+
+func BenchmarkRegisterVM_Loop(b *testing.B) {
+	benchmarkRegisterVM(b, loopProgram)
+}
+
+func BenchmarkRegisterVM_LocalLoop(b *testing.B) {
+	benchmarkRegisterVM(b, localLoopProgram)
+}
@@ -0,0 +1,104 @@
+package interpreter
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ====== REPL
+// every input is compiled on top of the inputs before it by a Runtime:
+// the globals, their types and the constants live as long as the REPL.
+// Hopes run as soon as their function is defined
+const (
+	prompt      = ">> "
+	morePrompt  = ".. "
+	replWelcome = "Ho, ctrl-d to quit"
+)
+
+type REPL struct {
+	rt *Runtime
+}
+
+func NewREPL() *REPL {
+	return &REPL{rt: NewRuntime()}
+}
+
+// StartREPL reads inputs from in until it ends,
+// an input goes on over lines until its braces are closed,
+// a func, if, while or hope block
+func StartREPL(in io.Reader, out io.Writer) {
+	repl := NewREPL()
+	scanner := bufio.NewScanner(in)
+	fmt.Fprintln(out, replWelcome)
+
+	input := ""
+	for {
+		if input == "" {
+			fmt.Fprint(out, prompt)
+		} else {
+			fmt.Fprint(out, morePrompt)
+		}
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return
+		}
+		input += scanner.Text() + "\n"
+		if openBraces(input) > 0 {
+			continue
+		}
+		repl.Eval(input, out)
+		input = ""
+	}
+}
+
+// Eval compiles and runs one input, it writes the value,
+// the failed hopes or what went wrong to out
+func (r *REPL) Eval(input string, out io.Writer) {
+	if strings.TrimSpace(input) == "" {
+		return
+	}
+	r.rt.SetOutput(out)
+	result, node, err := r.rt.eval(context.Background(), input)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return
+	}
+	if !endsWithDefinition(node) {
+		fmt.Fprintln(out, result)
+	}
+}
+
+// how many { are still open, the lexer skips
+// the ones in strings and comments
+func openBraces(input string) int {
+	n := 0
+	lexer := NewLexer(strings.NewReader(input))
+	for tk := lexer.Read(); tk != EOF; tk = lexer.Read() {
+		if tk.Type() == STRING {
+			continue
+		}
+		switch tk.Literal() {
+		case LBRACE:
+			n++
+		case RBRACE:
+			n--
+		}
+	}
+	return n
+}
+
+// a definition has no value worth printing
+func endsWithDefinition(node ASTNode) bool {
+	program, ok := node.(*Program)
+	if !ok || len(program.Statements) == 0 {
+		return false
+	}
+	switch program.Statements[len(program.Statements)-1].(type) {
+	case *DefineExpression, *AssignExpression, *IndexAssignExpression:
+		return true
+	}
+	return false
+}
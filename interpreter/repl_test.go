@@ -0,0 +1,55 @@
+package interpreter
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestREPL(t *testing.T) {
+	input := `x := 2
+x * 21
+fib := func(n int) int {
+	n <= 2 ? n : fib(n-1) + fib(n-2)
+} hope {
+	3 -> 3
+	4 -> 6
+}
+fib(10)
+y := zz
+y
+x + "a"
+x = x + 1
+1 / x
+"}" + "{"
+`
+	var out bytes.Buffer
+	StartREPL(strings.NewReader(input), &out)
+	want := []string{
+		replWelcome,
+		">> >> 42",
+		">> .. .. .. .. .. want 6, got 5 in the 2-th test case",
+		">> 89",
+		">> line 1: undefined variable zz",
+		">> line 1: undefined variable y",
+		">> line 1:1: type mismatch: int + string",
+		">> >> 0",
+		">> }{",
+		">> ",
+	}
+	if got := out.String(); got != strings.Join(want, "\n")+"\n" {
+		t.Errorf("want\n%s\ngot\n%s", strings.Join(want, "\n"), got)
+	}
+}
+
+func TestREPL_RuntimeError(t *testing.T) {
+	repl := NewREPL()
+	var out bytes.Buffer
+	for _, input := range []string{"a := [1, 2]", "a[5]", "a = append(a, 3)", "a"} {
+		repl.Eval(input, &out)
+	}
+	want := "runtime error at line 1 in <main>: array index out of range! expect [0, 2), got 5\n\tat <main> (line 1)\n[1, 2, 3]\n"
+	if got := out.String(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,304 @@
+package interpreter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// ====== embedding
+// a Runtime runs Ho inside a Go program:
+//
+//	rt := interpreter.NewRuntime()
+//	rt.Set("limit", 10)
+//	rt.Eval(`fib := func(n int) int { n <= 2 ? n : fib(n-1) + fib(n-2) }`)
+//	v, err := rt.Call("fib", rt.Get("limit"))
+//
+// Every Eval is compiled on top of the ones before it,
+// so the globals defined by one are seen by the next
+type Runtime struct {
+	symbolTable *SymbalTable
+	constants   []Object
+	globals     []Object
+	checker     *TypeChecker
+	builtins    *Builtins
+	limits      Limits
+
+	// failed hopes and fuzzing are reported here
+	out io.Writer
+}
+
+func NewRuntime() *Runtime {
+	builtins := NewBuiltins()
+	return &Runtime{
+		symbolTable: NewGlobalSymbolTable(builtins),
+		constants:   make([]Object, 0, 1024),
+		globals:     make([]Object, VariableSize),
+		checker:     newTypeCheckerWithBuiltins(builtins),
+		builtins:    builtins,
+		out:         os.Stdout,
+	}
+}
+
+// RegisterFunc makes a Go function a builtin of this runtime only,
+// see Builtins.RegisterFunc for how values are converted
+func (rt *Runtime) RegisterFunc(name string, fn interface{}) error {
+	if symbol, ok := rt.symbolTable.Resolve(name); ok && symbol.Scope != BuiltinScope {
+		return fmt.Errorf("%s is already defined", name)
+	}
+	if err := rt.builtins.RegisterFunc(name, fn); err != nil {
+		return err
+	}
+	rt.symbolTable.DefineBuiltin(len(rt.builtins.names)-1, name)
+	rt.checker.builtins.types[name] = rt.builtins.types[name]
+	return nil
+}
+
+// a VM on the globals and builtins of the runtime
+func (rt *Runtime) newVM(bc Bytecode) *VM {
+	vm := NewVMWithGlobals(bc, rt.globals)
+	vm.out = rt.out
+	vm.builtins = rt.builtins
+	vm.SetLimits(rt.limits)
+	return vm
+}
+
+// SetOutput sets where failed hopes and the arguments of fuzzing
+// are reported, os.Stdout by default
+func (rt *Runtime) SetOutput(w io.Writer) {
+	rt.out = w
+}
+
+// SetLimits bounds every later Eval and Call on its own, see Limits
+func (rt *Runtime) SetLimits(limits Limits) {
+	rt.limits = limits
+}
+
+// Eval compiles and runs src, it returns the value of its last statement.
+// A program that doesn't compile returns Diagnostics,
+// one that goes wrong while running a *RuntimeError
+func (rt *Runtime) Eval(src string) (Object, error) {
+	return rt.EvalContext(context.Background(), src)
+}
+
+// EvalContext is Eval that stops once ctx is done
+func (rt *Runtime) EvalContext(ctx context.Context, src string) (Object, error) {
+	obj, _, err := rt.eval(ctx, src)
+	return obj, err
+}
+
+// eval returns the parsed program too
+func (rt *Runtime) eval(ctx context.Context, src string) (obj Object, node ASTNode, err error) {
+	defer recoverError(&err)
+	parser := NewParser(NewLexer(strings.NewReader(src)))
+	node, diags := parser.Parse(nil)
+	if len(diags) == 0 {
+		diags = rt.checker.Check(node)
+	}
+	if len(diags) > 0 {
+		return nil, node, Diagnostics(diags)
+	}
+
+	// a failed program must not leave its definitions behind
+	symbolTable := rt.symbolTable.clone()
+	compiler := NewCompilerWithState(false, symbolTable, rt.constants)
+	compiler.out = rt.out
+	if err := compiler.Compile(node); err != nil {
+		return nil, node, err
+	}
+	bc := compiler.Bytecode()
+	rt.symbolTable, rt.constants = symbolTable, bc.constants
+
+	vm := rt.newVM(bc)
+	if err := vm.RunContext(ctx); err != nil {
+		return nil, node, err
+	}
+	return vm.LastPopped(), node, nil
+}
+
+// Set defines the global name, or assigns it if it exists.
+// value is converted by ToObject
+func (rt *Runtime) Set(name string, value interface{}) error {
+	obj, err := ToObject(value)
+	if err != nil {
+		return err
+	}
+	symbol, ok := rt.symbolTable.Resolve(name)
+	if ok && symbol.Scope == BuiltinScope {
+		return fmt.Errorf("cannot set builtin %s", name)
+	}
+	if !ok {
+		if rt.symbolTable.size >= len(rt.globals) {
+			return fmt.Errorf("too many globals")
+		}
+		symbol = rt.symbolTable.Define(name)
+	}
+	rt.globals[symbol.Index] = obj
+	rt.checker.declare(name, obj)
+	return nil
+}
+
+// Get returns the value of a global or a builtin, nil if there is none
+func (rt *Runtime) Get(name string) Object {
+	symbol, ok := rt.symbolTable.Resolve(name)
+	if !ok {
+		return nil
+	}
+	if symbol.Scope == BuiltinScope {
+		return rt.builtins.get(symbol.Index)
+	}
+	return rt.globals[symbol.Index]
+}
+
+// Call calls the function in the global fnName,
+// the arguments are converted by ToObject
+func (rt *Runtime) Call(fnName string, args ...interface{}) (Object, error) {
+	return rt.CallContext(context.Background(), fnName, args...)
+}
+
+// CallContext is Call that stops once ctx is done
+func (rt *Runtime) CallContext(ctx context.Context, fnName string, args ...interface{}) (Object, error) {
+	fn := rt.Get(fnName)
+	if fn == nil {
+		return nil, fmt.Errorf("undefined function %s", fnName)
+	}
+	objs := make([]Object, len(args))
+	for i, arg := range args {
+		obj, err := ToObject(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d of %s: %v", i+1, fnName, err)
+		}
+		objs[i] = obj
+	}
+	return rt.call(ctx, fn, objs)
+}
+
+// runs a single OpCall with the callee and the arguments on the stack
+func (rt *Runtime) call(ctx context.Context, fn Object, args []Object) (obj Object, err error) {
+	defer recoverError(&err)
+	// the number of arguments is a 1-byte operand
+	if len(args) > 255 {
+		return nil, fmt.Errorf("too many arguments, %d", len(args))
+	}
+	bc := Bytecode{instructions: Make(OpCall, len(args)), constants: rt.constants}
+	vm := rt.newVM(bc)
+	for _, obj := range append([]Object{fn}, args...) {
+		if err := vm.push(obj); err != nil {
+			return nil, err
+		}
+	}
+	if err := vm.RunContext(ctx); err != nil {
+		return nil, err
+	}
+	return vm.LastPopped(), nil
+}
+
+// a bug in Ho that panics fails the Eval or Call with it,
+// it doesn't take the host down
+func recoverError(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("internal error: %v", r)
+	}
+}
+
+// ====== Go values
+// ToObject converts a Go value to a Ho one:
+// nil, bools, integers, floats, strings, *big.Int,
+// slices and arrays of them and maps with int, string or bool keys.
+// An Object is returned as it is
+func ToObject(value interface{}) (Object, error) {
+	switch v := value.(type) {
+	case nil:
+		return NullObj, nil
+	case Object:
+		return v, nil
+	case *big.Int:
+		return newInteger(new(big.Int).Set(v)), nil
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return nativeBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return newInt(int(rv.Int())), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return newInteger(new(big.Int).SetUint64(rv.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return &Float{Value: rv.Float()}, nil
+	case reflect.String:
+		return &String{Value: rv.String()}, nil
+	case reflect.Slice, reflect.Array:
+		elements := make([]Object, rv.Len())
+		for i := range elements {
+			obj, err := ToObject(rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = obj
+		}
+		return &Array{Elements: elements}, nil
+	case reflect.Map:
+		hash := NewHash()
+		iter := rv.MapRange()
+		for iter.Next() {
+			key, err := ToObject(iter.Key().Interface())
+			if err != nil {
+				return nil, err
+			}
+			hk, err := toHashKey(key)
+			if err != nil {
+				return nil, err
+			}
+			value, err := ToObject(iter.Value().Interface())
+			if err != nil {
+				return nil, err
+			}
+			hash.Pairs[hk] = HashPair{Key: key, Value: value}
+		}
+		return hash, nil
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return NullObj, nil
+		}
+		return ToObject(rv.Elem().Interface())
+	}
+	return nil, fmt.Errorf("cannot convert %T to a Ho value", value)
+}
+
+// ToGo converts a Ho value to a Go one: int, *big.Int, float64,
+// string, bool, nil, []interface{} and map[interface{}]interface{}.
+// Functions stay Objects, they can be passed back to Call
+func ToGo(obj Object) interface{} {
+	switch obj := obj.(type) {
+	case *Integer:
+		return obj.Value
+	case *BigInt:
+		return new(big.Int).Set(obj.Value)
+	case *Float:
+		return obj.Value
+	case *String:
+		return obj.Value
+	case *Boolean:
+		return obj.Value
+	case *Null, nil:
+		return nil
+	case *Array:
+		values := make([]interface{}, len(obj.Elements))
+		for i, e := range obj.Elements {
+			values[i] = ToGo(e)
+		}
+		return values
+	case *Hash:
+		values := make(map[interface{}]interface{}, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			values[ToGo(pair.Key)] = ToGo(pair.Value)
+		}
+		return values
+	}
+	return obj
+}
@@ -0,0 +1,69 @@
+package interpreter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// maps instructions back to source lines,
+// the instructions from Offset on belong to Line until the next entry
+type LineInfo struct {
+	Offset int
+	Line   int
+}
+
+// the source line of the instruction at ip, 0 if unknown
+func lineAt(lines []LineInfo, ip int) int {
+	i := sort.Search(len(lines), func(i int) bool { return lines[i].Offset > ip })
+	if i == 0 {
+		return 0
+	}
+	return lines[i-1].Line
+}
+
+// one frame of the Ho call stack
+type StackEntry struct {
+	Function string
+	Line     int
+}
+
+func (se StackEntry) String() string {
+	if se.Line == 0 {
+		return se.Function
+	}
+	return fmt.Sprintf("%s (line %d)", se.Function, se.Line)
+}
+
+// a Ho program went wrong, the VM stops and returns it
+// instead of taking the host process down
+type RuntimeError struct {
+	Message  string
+	Line     int
+	Function string
+	Stack    []StackEntry // the innermost call first
+
+	// the error behind Message, e.g. the one a host function returned
+	Err error
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RuntimeError) Error() string {
+	var out bytes.Buffer
+	if e.Line > 0 {
+		fmt.Fprintf(&out, "runtime error at line %d in %s: %s", e.Line, e.Function, e.Message)
+	} else {
+		fmt.Fprintf(&out, "runtime error in %s: %s", e.Function, e.Message)
+	}
+	for _, entry := range e.Stack {
+		out.WriteString("\n\tat " + entry.String())
+	}
+	return out.String()
+}
+
+// reading a global or local that nothing has set yet, x := x + 1
+var errUndefinedVariable = errors.New("variable used before definition")
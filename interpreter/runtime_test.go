@@ -0,0 +1,250 @@
+package interpreter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRuntime(t *testing.T) {
+	rt := NewRuntime()
+	rt.SetOutput(io.Discard)
+	if err := rt.Set("limit", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.Set("names", []string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.Eval(`fib := func(n int) int {
+		n <= 2 ? n : fib(n-1) + fib(n-2)
+	}`); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := rt.Eval(`[fib(limit), len(names), names[1]]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ToGo(result), []interface{}{89, 2, "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	result, err = rt.Call("fib", uint8(12))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ToGo(result); got != 233 {
+		t.Errorf("want 233, got %v", got)
+	}
+	result, err = rt.Call("len", map[string]int{"x": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ToGo(result); got != 1 {
+		t.Errorf("want 1, got %v", got)
+	}
+
+	// the host changes a global between runs
+	if err := rt.Set("limit", 3); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := rt.Eval("fib(limit)"); ToGo(result) != 3 {
+		t.Errorf("want 3, got %v", result)
+	}
+}
+
+// the parser and compiler don't write to the host's log,
+// fuzzing reports to the output of the runtime
+func TestRuntime_Output(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	rt := NewRuntime()
+	var out bytes.Buffer
+	rt.SetOutput(&out)
+	if _, err := rt.Eval(`add := func(x int, y int) {
+		x + y
+	} hope {
+		1, 2 -> 3
+		fuzzing 2
+	}
+	add(1, 2)`); err != nil {
+		t.Fatal(err)
+	}
+	if logged.Len() > 0 {
+		t.Errorf("logged %q", logged.String())
+	}
+	if got := strings.Count(out.String(), "fuzzing"); got != 4 {
+		t.Errorf("want 4 random arguments, got %q", out.String())
+	}
+}
+
+func TestRuntime_Errors(t *testing.T) {
+	rt := NewRuntime()
+	rt.Set("n", 1)
+	rt.Eval("add := func(x int, y int) { x + y }")
+
+	tests := []struct {
+		run  func() error
+		want string
+	}{
+		{func() error { _, err := rt.Eval(`n + "a"`); return err }, "line 1:1: type mismatch: int + string"},
+		{func() error { _, err := rt.Eval("x := y\nz := )"); return err }, "line 2:6: want an expression, got ')'"},
+		{func() error { _, err := rt.Eval("m := undefined"); return err }, "line 1: undefined variable undefined"},
+		{func() error { _, err := rt.Eval("m"); return err }, "line 1: undefined variable m"},
+		{func() error { _, err := rt.Call("add", 1); return err }, "runtime error in <main>: add wants 2 arguments, got 1\n\tat <main>"},
+		{func() error { _, err := rt.Call("add", 1, "2"); return err }, "runtime error in <main>: argument 2 of add must be int, got string\n\tat <main>"},
+		{func() error { _, err := rt.Call("sub"); return err }, "undefined function sub"},
+		{func() error { _, err := rt.Call("add", 1, struct{}{}); return err }, "argument 2 of add: cannot convert struct {} to a Ho value"},
+		{func() error { return rt.Set("len", 1) }, "cannot set builtin len"},
+		{func() error {
+			_, err := rt.Eval("f := func(x int, y) { x } hope {\n fuzzing 2\n}\nf(1, 2)")
+			return err
+		}, "line 1: fuzzing needs every parameter annotated, y isn't"},
+		{func() error { _, err := rt.Call("add", make([]interface{}, 256)...); return err }, "too many arguments, 256"},
+	}
+	for i, tt := range tests {
+		if err := tt.run(); err == nil || err.Error() != tt.want {
+			t.Errorf("%d: want %q, got %v", i, tt.want, err)
+		}
+	}
+}
+
+func TestToObject(t *testing.T) {
+	big20, _ := new(big.Int).SetString("100000000000000000000", 10)
+	tests := []struct {
+		value interface{}
+		want  interface{} // after ToGo
+	}{
+		{nil, nil},
+		{true, true},
+		{int64(-5), -5},
+		{uint64(1 << 63), new(big.Int).SetUint64(1 << 63)},
+		{big20, big20},
+		{float32(0.5), 0.5},
+		{"ho", "ho"},
+		{[2]int{1, 2}, []interface{}{1, 2}},
+		{map[int][]bool{1: {true}}, map[interface{}]interface{}{1: []interface{}{true}}},
+		{&Integer{Value: 7}, 7},
+	}
+	for _, tt := range tests {
+		obj, err := ToObject(tt.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ToGo(obj); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: want %#v, got %#v", tt.value, tt.want, got)
+		}
+	}
+	if _, err := ToObject(map[float64]int{1.5: 1}); err == nil {
+		t.Error("want an error for float keys")
+	}
+}
+
+var errNoRule = errors.New("no such rule")
+
+func TestRuntime_RegisterFunc(t *testing.T) {
+	rt := NewRuntime()
+	rules := map[string]float64{"vat": 0.2}
+	for name, fn := range map[string]interface{}{
+		"now": func() int { return 1700000000 },
+		"rate": func(name string) (float64, error) {
+			r, ok := rules[name]
+			if !ok {
+				return 0, errNoRule
+			}
+			return r, nil
+		},
+		"sum": func(xs []int) int {
+			n := 0
+			for _, x := range xs {
+				n += x
+			}
+			return n
+		},
+		"count": func(h map[string]int, key string) uint8 { return uint8(h[key]) },
+		"first": func(xs ...Object) Object { return xs[0] },
+		"log":   func(v interface{}) error { return nil },
+		"small": func(x int8) int8 { return x },
+	} {
+		if err := rt.RegisterFunc(name, fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := rt.Eval(`[now(), rate("vat") * 10, sum([1, 2, 3]), count({"a": 2}, "a"), first("x", 1), log([1]), len("ab")]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := result.String(), "[1700000000, 2.0, 6, 2, x, nil, 2]"; got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+
+	// the error of a host function stops the program
+	_, err = rt.Eval("x := 1\nrate(\"tax\")")
+	var re *RuntimeError
+	if !errors.As(err, &re) || !errors.Is(err, errNoRule) || re.Line != 2 || re.Message != "rate: no such rule" {
+		t.Errorf("want a runtime error at line 2 wrapping errNoRule, got %v", err)
+	}
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`now() + "a"`, "line 1:1: type mismatch: int + string"},
+		{`sum(1)`, "line 1:5: argument 1 of sum must be array, got int"},
+		{`now(1)`, "line 1:1: now wants 0 arguments, got 1"},
+		{`sum(["a"])`, "runtime error at line 1 in <main>: argument 1 of sum must be []int, got array\n\tat <main> (line 1)"},
+		{`small(300)`, "runtime error at line 1 in <main>: argument 1 of small must be int8, got int"},
+	}
+	for _, tt := range tests {
+		_, err := rt.Eval(tt.input)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+			t.Errorf("%s: want %q, got %v", tt.input, tt.want, err)
+		}
+	}
+
+	if err := rt.RegisterFunc("len", func() int { return 0 }); err == nil || err.Error() != "builtin len already exists" {
+		t.Errorf("want an error for len, got %v", err)
+	}
+	if err := rt.RegisterFunc("bad", func() (int, int) { return 0, 0 }); err == nil {
+		t.Error("want an error for a second result that isn't an error")
+	}
+	// another runtime doesn't see them
+	if _, err := NewRuntime().Eval("now()"); err == nil {
+		t.Error("want now to be undefined in a new runtime")
+	}
+}
+
+func TestRuntime_Limits(t *testing.T) {
+	rt := NewRuntime()
+	rt.SetLimits(Limits{MaxSteps: 10000})
+	if _, err := rt.Eval("spin := func() {\nwhile true {\n}\n}"); err != nil {
+		t.Fatal(err)
+	}
+	var le *LimitError
+	if _, err := rt.Call("spin"); !errors.As(err, &le) || le.Limit != "steps" {
+		t.Errorf("want the steps limit, got %v", err)
+	}
+	// every run has its own budget
+	for i := 0; i < 3; i++ {
+		if _, err := rt.Eval("x := 0\nwhile x < 1000 {\nx = x + 1\n}"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rt.SetLimits(Limits{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := rt.CallContext(ctx, "spin"); !errors.Is(err, context.Canceled) {
+		t.Errorf("want canceled, got %v", err)
+	}
+}
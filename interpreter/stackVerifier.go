@@ -0,0 +1,115 @@
+package interpreter
+
+import "fmt"
+
+// verifyStack walks every path through ins and checks that
+// each instruction always sees the same stack height, that
+// nothing pops from an empty stack and that OpReturnValue
+// finds exactly the result on the stack.
+// It returns the height when the instructions run off the end,
+// or -1 if the end is never reached (e.g. a function body).
+func verifyStack(ins Instructions) (int, error) {
+	_, end, err := stackHeights(ins)
+	return end, err
+}
+
+// stackHeights is verifyStack that also returns the height before
+// each instruction reached, the ones never reached are left out
+func stackHeights(ins Instructions) (map[int]int, int, error) {
+	heights := make(map[int]int) // pc -> stack height before the instruction
+	work := []int{0}
+	heights[0] = 0
+	end := -1
+
+	// visit records the height at pc and queues it the first time
+	visit := func(pc, height int) error {
+		if pc == len(ins) {
+			if end != -1 && end != height {
+				return fmt.Errorf("stack verifier: height %d and %d at the end", end, height)
+			}
+			end = height
+			return nil
+		}
+		if pc < 0 || pc > len(ins) {
+			return fmt.Errorf("stack verifier: jump out of range to %d", pc)
+		}
+		if h, ok := heights[pc]; ok {
+			if h != height {
+				return fmt.Errorf("stack verifier: height %d and %d at pc=%d", h, height, pc)
+			}
+			return nil
+		}
+		heights[pc] = height
+		work = append(work, pc)
+		return nil
+	}
+
+	for len(work) > 0 {
+		pc := work[len(work)-1]
+		work = work[:len(work)-1]
+		height := heights[pc]
+
+		op := Opcode(ins[pc])
+		def, err := Lookup(op)
+		if err != nil {
+			return nil, 0, fmt.Errorf("stack verifier: %v at pc=%d", err, pc)
+		}
+		if pc+def.Size() > len(ins) {
+			return nil, 0, fmt.Errorf("stack verifier: %s cut off at pc=%d", def.Name, pc)
+		}
+		pop, push := 0, 0
+		switch op {
+		case OpConstant, OpConstantWide, OpGetGlobal, OpGetLocal, OpNull, OpGetBuiltin:
+			push = 1
+		case OpArray:
+			pop, push = ins.readUint16(pc+1), 1
+		case OpHash:
+			pop, push = 2*ins.readUint16(pc+1), 1
+		case OpIndex:
+			pop, push = 2, 1
+		case OpSetIndex:
+			pop = 3
+		case OpPop:
+			pop = 1
+		case OpAdd, OpSub, OpMult, OpDiv, OpMod, OpLt, OpGt, OpLte, OpGte, OpEq, OpNeq:
+			pop, push = 2, 1
+		case OpMinus, OpBang:
+			pop, push = 1, 1
+		case OpSetGlobal, OpSetLocal:
+			pop = 1
+		case OpCall, OpTailCall:
+			pop, push = ins.readUint8(pc+1)+1, 1
+		case OpHope:
+			pop = 2
+		case OpJump:
+			if err := visit(ins.readUint16(pc+1), height); err != nil {
+				return nil, 0, err
+			}
+			continue
+		case OpJumpIfFalse:
+			if height < 1 {
+				return nil, 0, fmt.Errorf("stack verifier: empty stack at pc=%d", pc)
+			}
+			if err := visit(ins.readUint16(pc+1), height-1); err != nil {
+				return nil, 0, err
+			}
+			if err := visit(pc+def.Size(), height-1); err != nil {
+				return nil, 0, err
+			}
+			continue
+		case OpReturnValue:
+			if height != 1 {
+				return nil, 0, fmt.Errorf("stack verifier: return with height %d at pc=%d", height, pc)
+			}
+			continue
+		}
+
+		if height < pop {
+			return nil, 0, fmt.Errorf("stack verifier: pop %d from height %d at pc=%d", pop, height, pc)
+		}
+		if err := visit(pc+def.Size(), height-pop+push); err != nil {
+			return nil, 0, err
+		}
+	}
+	return heights, end, nil
+}
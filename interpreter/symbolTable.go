@@ -24,6 +24,24 @@ func NewSymbolTable() *SymbalTable {
 	}
 }
 
+// the global table the compiler starts with, the builtins are in it
+func NewGlobalSymbolTable(builtins *Builtins) *SymbalTable {
+	st := NewSymbolTable()
+	for i, name := range builtins.names {
+		st.DefineBuiltin(i, name)
+	}
+	return st
+}
+
+// a copy to compile into, thrown away if the compilation fails
+func (s *SymbalTable) clone() *SymbalTable {
+	st := &SymbalTable{outer: s.outer, store: make(map[string]*Symbol, len(s.store)), size: s.size}
+	for name, symbol := range s.store {
+		st.store[name] = symbol
+	}
+	return st
+}
+
 func (s *SymbalTable) Define(name string) *Symbol {
 	symbol := Symbol{Name: name, Scope: GlobalScope, Index: s.size}
 	if s.outer != nil {
@@ -34,6 +52,24 @@ func (s *SymbalTable) Define(name string) *Symbol {
 	return &symbol
 }
 
+// builtins don't take a slot of the globals
+func (s *SymbalTable) DefineBuiltin(index int, name string) *Symbol {
+	symbol := Symbol{Name: name, Scope: BuiltinScope, Index: index}
+	s.store[name] = &symbol
+	return &symbol
+}
+
+// the names of the variables in this table by index, builtins aren't in it
+func (s *SymbalTable) names() []string {
+	names := make([]string, s.size)
+	for name, symbol := range s.store {
+		if symbol.Scope != BuiltinScope {
+			names[symbol.Index] = name
+		}
+	}
+	return names
+}
+
 func (s *SymbalTable) Resolve(name string) (*Symbol, bool) {
 	symbol, ok := s.store[name]
 	if !ok && s.outer != nil {
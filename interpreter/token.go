@@ -4,8 +4,10 @@ const (
 	// Identifiers + literals
 	IDENTIFIER = "IDENTIFIER" // add, x, y, ...
 	INTEGER    = "INTEGER"    // 1343456
+	FLOAT      = "FLOAT"      // 3.14, 1e-9
 	STRING     = "STRING"     // "foobar"
 	BOOLEAN    = "BOOLEAN"
+	ILLEGAL    = "ILLEGAL" // a character the lexer doesn't know
 	// Operators
 	OPERATOR = "OPERATOR"
 	ASSIGN   = "="
@@ -42,10 +44,12 @@ const (
 	FALSE    = "false"
 	HOPE     = "hope"
 	FUZZING  = "fuzzing"
+	NIL      = "nil"
 )
 
 type Token interface {
 	LineNumber() int
+	Column() int
 	Type() string
 	Literal() string
 }
@@ -55,6 +59,7 @@ var EOL = helperToken{BaseToken{lineNumber: 0, literal: "EOL"}}
 
 type BaseToken struct {
 	lineNumber int
+	column     int // 1-based, in bytes
 	literal    string
 }
 
@@ -62,6 +67,10 @@ func (t BaseToken) LineNumber() int {
 	return t.lineNumber
 }
 
+func (t BaseToken) Column() int {
+	return t.column
+}
+
 func (t BaseToken) Literal() string {
 	return t.literal
 }
@@ -75,10 +84,11 @@ func (i IdToken) Type() string {
 	return IDENTIFIER
 }
 
-func NewIdToken(lineNo int, literal string) *IdToken {
+func NewIdToken(lineNo, column int, literal string) *IdToken {
 	return &IdToken{
 		BaseToken: BaseToken{
 			lineNumber: lineNo,
+			column:     column,
 			literal:    literal,
 		},
 	}
@@ -89,10 +99,11 @@ type NumToken struct {
 	BaseToken
 }
 
-func NewNumToken(lineNo int, literal string) *NumToken {
+func NewNumToken(lineNo, column int, literal string) *NumToken {
 	return &NumToken{
 		BaseToken: BaseToken{
 			lineNumber: lineNo,
+			column:     column,
 			literal:    literal,
 		},
 	}
@@ -102,15 +113,35 @@ func (n NumToken) Type() string {
 	return INTEGER
 }
 
+// ========================== float
+type FloatToken struct {
+	BaseToken
+}
+
+func NewFloatToken(lineNo, column int, literal string) *FloatToken {
+	return &FloatToken{
+		BaseToken: BaseToken{
+			lineNumber: lineNo,
+			column:     column,
+			literal:    literal,
+		},
+	}
+}
+
+func (f FloatToken) Type() string {
+	return FLOAT
+}
+
 // =========================== string
 type StrToken struct {
 	BaseToken
 }
 
-func NewStrToken(lineNo int, literal string) *StrToken {
+func NewStrToken(lineNo, column int, literal string) *StrToken {
 	return &StrToken{
 		BaseToken: BaseToken{
 			lineNumber: lineNo,
+			column:     column,
 			literal:    literal,
 		},
 	}
@@ -125,9 +156,9 @@ type BooleanToken struct {
 	BaseToken
 }
 
-func NewBooleanToken(lineNo int, literal string) *BooleanToken {
+func NewBooleanToken(lineNo, column int, literal string) *BooleanToken {
 	return &BooleanToken{
-		BaseToken: BaseToken{lineNumber: lineNo,
+		BaseToken: BaseToken{lineNumber: lineNo, column: column,
 			literal: literal},
 	}
 }
@@ -141,9 +172,9 @@ type OpToken struct {
 	BaseToken
 }
 
-func NewOpToken(lineNo int, literal string) *OpToken {
+func NewOpToken(lineNo, column int, literal string) *OpToken {
 	return &OpToken{
-		BaseToken: BaseToken{lineNumber: lineNo,
+		BaseToken: BaseToken{lineNumber: lineNo, column: column,
 			literal: literal},
 	}
 }
@@ -157,9 +188,9 @@ type ReservedToken struct {
 	BaseToken
 }
 
-func NewReservedToken(lineNo int, literal string) *ReservedToken {
+func NewReservedToken(lineNo, column int, literal string) *ReservedToken {
 	return &ReservedToken{
-		BaseToken: BaseToken{lineNumber: lineNo,
+		BaseToken: BaseToken{lineNumber: lineNo, column: column,
 			literal: literal},
 	}
 }
@@ -168,6 +199,24 @@ func (r ReservedToken) Type() string {
 	return r.Literal()
 }
 
+// ======== illegal token
+// the parser reports it, so that one bad character
+// doesn't stop the lexer
+type IllegalToken struct {
+	BaseToken
+}
+
+func NewIllegalToken(lineNo, column int, literal string) *IllegalToken {
+	return &IllegalToken{
+		BaseToken: BaseToken{lineNumber: lineNo, column: column,
+			literal: literal},
+	}
+}
+
+func (i IllegalToken) Type() string {
+	return ILLEGAL
+}
+
 // ======= helper token
 type helperToken struct {
 	BaseToken
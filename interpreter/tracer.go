@@ -0,0 +1,118 @@
+package interpreter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ====== tracing
+// Tracer watches a VM run. A VM without one only pays a nil check
+// per instruction, so tracing costs nothing when it is off
+type Tracer interface {
+	// Step is called before the instruction at pc of fn runs,
+	// stack holds the values on the stack, the top one last.
+	// Neither may be kept or changed after Step returns
+	Step(fn *CompiledFunction, pc int, stack []Object)
+}
+
+// CallTracer is a Tracer that is also told when a Ho function
+// is called, once its frame is pushed, and when it returns.
+// A call to a builtin runs inside the frame of its caller
+type CallTracer interface {
+	Tracer
+	Call(fn *CompiledFunction)
+	Return(fn *CompiledFunction)
+}
+
+// how many values from the top of the stack a trace shows
+const traceStackDepth = 3
+
+// StreamTracer writes a line for every instruction to w: the function,
+// the source line, the instruction and the values on top of the stack.
+// As text for reading, or as JSON lines for tools
+type StreamTracer struct {
+	w         io.Writer
+	constants []Object
+	json      bool
+	funcs     map[string]bool // only these functions, all when empty
+	err       error
+}
+
+// NewTextTracer traces bc, only the functions in funcs if there are any
+//
+//	fib        2  0002 OpConstant 0         ; 2        [.. func fib, 3, 2]
+func NewTextTracer(w io.Writer, bc Bytecode, funcs ...string) *StreamTracer {
+	return newStreamTracer(w, bc, false, funcs)
+}
+
+// NewJSONTracer is NewTextTracer writing an object per line
+//
+//	{"func":"fib","line":2,"pc":2,"op":"OpConstant","operands":[0],"height":3,"stack":["func fib","3","2"]}
+func NewJSONTracer(w io.Writer, bc Bytecode, funcs ...string) *StreamTracer {
+	return newStreamTracer(w, bc, true, funcs)
+}
+
+func newStreamTracer(w io.Writer, bc Bytecode, json bool, funcs []string) *StreamTracer {
+	t := &StreamTracer{w: w, constants: bc.constants, json: json, funcs: make(map[string]bool)}
+	for _, name := range funcs {
+		t.funcs[name] = true
+	}
+	return t
+}
+
+// Err is the first error writing the trace
+func (t *StreamTracer) Err() error {
+	return t.err
+}
+
+// one line of a JSON trace
+type traceEvent struct {
+	Func     string   `json:"func"`
+	Line     int      `json:"line,omitempty"`
+	PC       int      `json:"pc"`
+	Op       string   `json:"op"`
+	Operands []int    `json:"operands,omitempty"`
+	Comment  string   `json:"comment,omitempty"`
+	Height   int      `json:"height"`
+	Stack    []string `json:"stack"`
+}
+
+func (t *StreamTracer) Step(fn *CompiledFunction, pc int, stack []Object) {
+	name := fn.DisplayName()
+	if t.err != nil || len(t.funcs) > 0 && !t.funcs[name] {
+		return
+	}
+	def, _ := Lookup(Opcode(fn.Instructions[pc]))
+	operands, _ := ReadOperands(def, fn.Instructions[pc+1:])
+	comment := describeOperand(fn.Instructions, pc, t.constants)
+	top := stack
+	if len(top) > traceStackDepth {
+		top = top[len(top)-traceStackDepth:]
+	}
+	values := make([]string, len(top))
+	for i, obj := range top {
+		values[i] = describeValue(obj)
+	}
+
+	if t.json {
+		event := traceEvent{
+			Func: name, Line: lineAt(fn.Lines, pc), PC: pc,
+			Op: def.Name, Operands: operands, Comment: comment,
+			Height: len(stack), Stack: values,
+		}
+		data, err := json.Marshal(event)
+		if err == nil {
+			_, err = fmt.Fprintf(t.w, "%s\n", data)
+		}
+		t.err = err
+		return
+	}
+	more := ""
+	if len(stack) > len(top) {
+		more = ".. "
+	}
+	_, t.err = fmt.Fprintf(t.w, "%-10s %4d  %04d %-30s [%s%s]\n", name, lineAt(fn.Lines, pc), pc,
+		formatInstruction(def, operands, comment), more, strings.Join(values, ", "))
+}
@@ -1,8 +1,12 @@
 package interpreter
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"io"
+	"math"
+	"math/big"
+	"os"
 	"reflect"
 )
 
@@ -17,10 +21,29 @@ type VM struct {
 
 	stack    []Object
 	stackIdx int
+
+	// failed hopes are reported here
+	out io.Writer
+
+	builtins *Builtins
+
+	tracer Tracer
+	calls  CallTracer // the tracer, if it wants the calls too
+
+	limits    Limits
+	maxStack  int // the smaller of StackSize and limits.MaxStack
+	steps     int
+	allocated int
 }
 
 func NewVM(bc Bytecode) *VM {
-	mainFn := &CompiledFunction{Instructions: bc.instructions}
+	return NewVMWithGlobals(bc, make([]Object, VariableSize))
+}
+
+// NewVMWithGlobals runs bc with the globals left by an earlier VM,
+// the REPL shares them between inputs
+func NewVMWithGlobals(bc Bytecode, globals []Object) *VM {
+	mainFn := &CompiledFunction{Instructions: bc.instructions, Lines: bc.lines, Name: "<main>"}
 	mainFrame := NewFrame(mainFn, 0, 0)
 
 	frames := []*Frame{mainFrame}
@@ -30,122 +53,195 @@ func NewVM(bc Bytecode) *VM {
 
 		stackIdx: 0,
 		stack:    make([]Object, StackSize),
-		globals:  make([]Object, VariableSize),
+		globals:  globals,
+		out:      os.Stdout,
+		builtins: standardBuiltins,
+		maxStack: StackSize,
 
 		frames: frames,
 	}
 }
 
+// SetTracer makes tracer see every instruction, nil turns tracing off
+func (vm *VM) SetTracer(tracer Tracer) {
+	vm.tracer = tracer
+	vm.calls, _ = tracer.(CallTracer)
+}
+
+// SetLimits bounds the next runs, see Limits
+func (vm *VM) SetLimits(limits Limits) {
+	vm.limits = limits
+	vm.maxStack = StackSize
+	if limits.MaxStack > 0 && limits.MaxStack < StackSize {
+		vm.maxStack = limits.MaxStack
+	}
+}
+
+// Run executes the bytecode. A Ho program that goes wrong doesn't panic,
+// Run returns a *RuntimeError with the source line and the call stack.
 func (vm *VM) Run() error {
+	return vm.RunContext(context.Background())
+}
+
+// RunContext is Run that stops with ctx.Err() in a *RuntimeError
+// once ctx is done
+func (vm *VM) RunContext(ctx context.Context) (err error) {
 	ip := 0
+	pc := 0 // the start of the current instruction
 	frame := vm.currentFrame()
 	ins := frame.fn.Instructions
 
-	log.Println("vm start!")
+	// a bug in the VM or a panicking builtin must not take the host down
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = vm.runtimeError(pc, e)
+			} else {
+				err = vm.runtimeError(pc, fmt.Errorf("%v", r))
+			}
+		}
+	}()
 
 	for ip < len(ins) {
-
+		pc = ip
 		op := Opcode(ins[ip])
-		log.Println("\n\n\n===============================")
+		size := instructionSizes[op]
+		if size == 0 {
+			return vm.runtimeError(pc, fmt.Errorf("unknown opcode %d", op))
+		}
+		ip += size
+
+		vm.steps++
+		if vm.limits.MaxSteps > 0 && vm.steps > vm.limits.MaxSteps {
+			return vm.runtimeError(pc, &LimitError{Limit: "steps", Max: vm.limits.MaxSteps})
+		}
+		if vm.steps%cancelCheckInterval == 0 {
+			select {
+			case <-ctx.Done():
+				return vm.runtimeError(pc, ctx.Err())
+			default:
+			}
+		}
+		if vm.tracer != nil {
+			vm.tracer.Step(frame.fn, pc, vm.stack[:vm.stackIdx])
+		}
 
 		switch op {
 
 		case OpConstant:
-			log.Println("constant")
-			idx := ins.readUint16(ip + 1)
-			vm.push(vm.constants[idx])
-			ip += 3
+			idx := ins.readUint16(pc + 1)
+			err = vm.push(vm.constants[idx])
+
+		case OpConstantWide:
+			idx := ins.readUint32(pc + 1)
+			err = vm.push(vm.constants[idx])
 
 		case OpPop:
-			log.Println("pop")
 			vm.pop()
-			ip++
+
+		case OpNull:
+			err = vm.push(NullObj)
 
 		case OpAdd, OpSub, OpMult, OpDiv, OpMod, OpLt, OpGt, OpLte, OpGte, OpEq, OpNeq:
-			log.Println("add sub lt ...", OpAdd)
 			right := vm.pop()
 			left := vm.pop()
-			if left.Type() != right.Type() {
-				log.Panicln("different type in infix expression")
-			}
-			switch left.Type() {
-			case INTEGER_OBJ:
-				vm.integerInfix(op, left, right)
-			case STRING_OBJ:
-				vm.stringInfix(op, left, right)
+			err = vm.infix(op, left, right)
+
+		case OpMinus, OpBang:
+			var obj Object
+			if obj, err = unary(op, vm.pop()); err == nil {
+				err = vm.pushAllocated(obj)
 			}
-			ip++
-
-		case OpMinus:
-			log.Println("minus")
-			right := vm.pop().(*Integer)
-			right.Value = -right.Value
-			vm.push(right)
-			ip++
-
-		case OpBang:
-			log.Println("bang")
-			right := vm.pop().(*Boolean)
-			right.Value = !right.Value
-			vm.push(right)
-			ip++
 
 		case OpJump:
-			log.Println("jump")
-			ip = ins.readUint16(ip + 1)
+			ip = ins.readUint16(pc + 1)
 
 		case OpJumpIfFalse:
-			log.Println("jumpIfFalse")
-
-			if cnd := vm.pop().(*Boolean); !cnd.Value {
-				log.Println("false")
-				ip = ins.readUint16(ip + 1)
 
-			} else {
-				log.Println("true")
-				ip += 3
+			cnd, ok := vm.pop().(*Boolean)
+			if !ok {
+				err = fmt.Errorf("condition must be a boolean, got %s", vm.stack[vm.stackIdx].Type())
+				break
+			}
+			if !cnd.Value {
+				ip = ins.readUint16(pc + 1)
 			}
 
 		case OpSetGlobal:
-			log.Println("setGlobal")
-			idx := ins.readUint16(ip + 1)
+			idx := ins.readUint16(pc + 1)
 			vm.globals[idx] = vm.pop()
-			ip += 3
 
 		case OpGetGlobal:
-			log.Println("getGlobal")
-			idx := ins.readUint16(ip + 1)
+			idx := ins.readUint16(pc + 1)
 			obj := vm.globals[idx]
-			vm.push(obj)
-			ip += 3
+			if obj == nil {
+				err = errUndefinedVariable
+				break
+			}
+			err = vm.push(obj)
 
 		case OpGetLocal:
-			log.Println("get local")
-			idx := ins.readUint8(ip + 1)
+			idx := ins.readUint8(pc + 1)
 			obj := vm.stack[frame.bp+idx]
-			vm.push(obj)
-			ip += 2
+			if obj == nil {
+				err = errUndefinedVariable
+				break
+			}
+			err = vm.push(obj)
 
 		case OpSetLocal:
-			log.Println("set local")
-			idx := ins.readUint8(ip + 1)
+			idx := ins.readUint8(pc + 1)
 			obj := vm.pop()
 			vm.stack[frame.bp+idx] = obj
-			ip += 2
 
-		case OpCall:
-			log.Println("call")
-			numParas := ins.readUint8(ip + 1)
-			fn := vm.stack[vm.stackIdx-1-numParas].(*CompiledFunction)
-			nextFrame := NewFrame(fn, ip+2, vm.stackIdx-numParas)
+		case OpCall, OpTailCall:
+			numParas := ins.readUint8(pc + 1)
+			callee := vm.stack[vm.stackIdx-1-numParas]
+			if builtin, ok := callee.(*Builtin); ok {
+				args := vm.stack[vm.stackIdx-numParas : vm.stackIdx]
+				before := allocationSize(args...)
+				result := builtin.Fn(args...)
+				vm.stackIdx -= numParas + 1
+				// append grows the array it was given, delete hands back its hash
+				grown := allocationSize(args...) - before
+				if !isOneOf(result, args) {
+					grown += allocationSize(result)
+				}
+				if err = vm.allocate(grown); err == nil {
+					err = vm.push(result)
+				}
+				break
+			}
+			fn, ok := callee.(*CompiledFunction)
+			if !ok {
+				err = fmt.Errorf("calling non-function %s", callee.Type())
+				break
+			}
+			args := vm.stack[vm.stackIdx-numParas : vm.stackIdx]
+			if err = checkArguments(fn.DisplayName(), fn.NumParas, fn.ParaTypes, args); err != nil {
+				break
+			}
+			if op == OpTailCall {
+				if err = vm.tailCall(frame, fn, numParas); err == nil {
+					ip, ins = 0, fn.Instructions
+				}
+				break
+			}
+			if err = vm.checkCall(fn, numParas); err != nil {
+				break
+			}
+			nextFrame := NewFrame(fn, ip, vm.stackIdx-numParas)
 			// next Frame : important!!
 			vm.stackIdx = nextFrame.bp + nextFrame.fn.NumLocals
+			vm.clearLocals(nextFrame.bp+numParas, vm.stackIdx)
 			vm.pushFrame(nextFrame) // base pointer is current stack index
 			ip, frame = 0, vm.currentFrame()
 			ins = frame.fn.Instructions
+			if vm.calls != nil {
+				vm.calls.Call(fn)
+			}
 
 		case OpReturnValue:
-			log.Println("return value")
 			result := vm.pop()
 			// go back to last frame
 			f := vm.popFrame()
@@ -153,85 +249,269 @@ func (vm *VM) Run() error {
 			vm.stackIdx = f.bp
 			ip, frame = f.ip, vm.currentFrame()
 			ins = frame.fn.Instructions
+			if vm.calls != nil {
+				vm.calls.Return(f.fn)
+			}
+
+		case OpGetBuiltin:
+			idx := ins.readUint8(pc + 1)
+			err = vm.push(vm.builtins.get(idx))
+
+		case OpArray:
+			n := ins.readUint16(pc + 1)
+			elements := make([]Object, n)
+			copy(elements, vm.stack[vm.stackIdx-n:vm.stackIdx])
+			vm.stackIdx -= n
+			err = vm.pushAllocated(&Array{Elements: elements})
+
+		case OpHash:
+			n := ins.readUint16(pc + 1)
+			hash := NewHash()
+			for i := vm.stackIdx - 2*n; i < vm.stackIdx && err == nil; i += 2 {
+				key, value := vm.stack[i], vm.stack[i+1]
+				var hk HashKey
+				if hk, err = toHashKey(key); err == nil {
+					hash.Pairs[hk] = HashPair{Key: key, Value: value}
+				}
+			}
+			if err != nil {
+				break
+			}
+			vm.stackIdx -= 2 * n
+			err = vm.pushAllocated(hash)
+
+		case OpIndex:
+			idx := vm.pop()
+			left := vm.pop()
+			var obj Object
+			if obj, err = vm.index(left, idx); err == nil {
+				err = vm.push(obj)
+			}
+
+		case OpSetIndex:
+			value := vm.pop()
+			idx := vm.pop()
+			left := vm.pop()
+			err = vm.setIndex(left, idx, value)
 
 		case OpHope:
-			log.Println("hope")
-			id := ins.readUint8(ip + 1)
+			id := ins.readUint8(pc + 1)
 			expected := vm.pop()
 			got := vm.pop()
-			if !reflect.DeepEqual(expected, got) {
-				fmt.Printf("want %v, got %v in the %d-th test case\n", expected, got, id)
+			if !hopeEqual(expected, got) {
+				fmt.Fprintf(vm.out, "want %v, got %v in the %d-th test case\n", expected, got, id)
 			}
-			// if expected.Type() != got.Type() {
-			// 	fmt.Printf("want %v, got %v in the %d-th test case\n", expected, got, id)
-			// } else {
-			// 	switch expected.Type() {
-			// 	case INTEGER_OBJ:
-			// 		if expected.(*Integer).Value != got.(*Integer).Value {
-			// 			fmt.Printf("want %v, got %v in the %d-th test case\n", expected, got, id)
-			// 		}
-			// 	}
-			// }
-			ip += 2
 		}
+		if err != nil {
+			return vm.runtimeError(pc, err)
+		}
+	}
+	vm.pop()
+	return nil
+}
+
+// wraps err with the position of the instruction at pc in the current frame
+// and the Ho call stack
+func (vm *VM) runtimeError(pc int, err error) *RuntimeError {
+	if re, ok := err.(*RuntimeError); ok {
+		return re
+	}
+	stack := []StackEntry{}
+	ip := pc
+	for i := len(vm.frames) - 1; i >= 0; i-- {
+		f := vm.frames[i]
+		stack = append(stack, StackEntry{Function: f.fn.DisplayName(), Line: lineAt(f.fn.Lines, ip)})
+		// the return address follows the OpCall in the caller
+		ip = f.ip - definitions[OpCall].Size()
+	}
+	return &RuntimeError{
+		Message:  err.Error(),
+		Err:      err,
+		Line:     stack[0].Line,
+		Function: stack[0].Function,
+		Stack:    stack,
+	}
+}
 
-		// debug
-		// iter++
-		// if iter > 2048*1024 {
-		// 	return fmt.Errorf("for ever")
-		// }
-		// log.Printf("============= %dth iter pc=%d ==========", iter, pc)
-		log.Println("vm ------- globals : ")
-		for i := 0; i < 5; i++ {
-			log.Printf("%v , ", vm.globals[i])
+func (vm *VM) infix(op Opcode, left, right Object) error {
+	if isNumber(left) && isNumber(right) {
+		switch {
+		case left.Type() == FLOAT_OBJ || right.Type() == FLOAT_OBJ:
+			return vm.floatInfix(op, left, right)
+		case left.Type() == BIGINT_OBJ || right.Type() == BIGINT_OBJ:
+			return vm.bigIntegerInfix(op, left, right)
+		default:
+			return vm.integerInfix(op, left, right)
 		}
-		// fmt.Println()
-		log.Println()
-		log.Println("vm ------- stack :  (stackIdx=)", vm.stackIdx)
-		for i := 0; i < vm.stackIdx; i++ {
-			log.Printf("%v ", vm.stack[i])
+	}
+	if left.Type() != right.Type() || left.Type() == NULL_OBJ {
+		// values of different types are never equal, nil only equals nil
+		if op != OpEq && op != OpNeq {
+			return fmt.Errorf("type mismatch: %s %s %s", left.Type(), operatorSymbol(op), right.Type())
 		}
-		// log.Println()
+		return vm.push(nativeBool((left.Type() == right.Type()) == (op == OpEq)))
 	}
-	fmt.Println("============= final result ==========")
-	fmt.Println(vm.pop())
-	return nil
+	switch left.Type() {
+	case STRING_OBJ:
+		return vm.stringInfix(op, left, right)
+	case BOOLEAN_OBJ:
+		return vm.booleanInfix(op, left, right)
+	}
+	return fmt.Errorf("operator %s not supported for %s", operatorSymbol(op), left.Type())
 }
 
-func (vm *VM) integerInfix(code Opcode, left, right Object) {
+// -x and !x, the values are new ones
+func unary(op Opcode, right Object) (Object, error) {
+	if op == OpBang {
+		if r, ok := right.(*Boolean); ok {
+			return nativeBool(!r.Value), nil
+		}
+		return nil, fmt.Errorf("operator ! not supported for %s", right.Type())
+	}
+	switch r := right.(type) {
+	case *Float:
+		return &Float{Value: -r.Value}, nil
+	case *BigInt:
+		return newInteger(new(big.Int).Neg(r.Value)), nil
+	case *Integer:
+		return negInt(r.Value), nil
+	}
+	return nil, fmt.Errorf("operator - not supported for %s", right.Type())
+}
+
+func (vm *VM) integerInfix(code Opcode, left, right Object) error {
 	l := left.(*Integer).Value
 	r := right.(*Integer).Value
 
 	var obj Object
 	switch code {
 	case OpAdd:
-		obj = &Integer{Value: l + r}
+		obj = addInt(l, r)
+	case OpSub:
+		obj = subInt(l, r)
+	case OpMult:
+		obj = mulInt(l, r)
+	case OpDiv:
+		if r == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		obj = divInt(l, r)
+	case OpMod:
+		if r == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		obj = newInt(l % r)
+	case OpLt:
+		obj = nativeBool(l < r)
+	case OpLte:
+		obj = nativeBool(l <= r)
+	case OpGt:
+		obj = nativeBool(l > r)
+	case OpGte:
+		obj = nativeBool(l >= r)
+	case OpEq:
+		obj = nativeBool(l == r)
+	case OpNeq:
+		obj = nativeBool(l != r)
+
+	}
+	return vm.push(obj)
+}
+
+// one of the operands is a BigInt, the other a BigInt or an Integer
+// division and modulo truncate like they do for Integer
+func (vm *VM) bigIntegerInfix(code Opcode, left, right Object) error {
+	l := toBig(left)
+	r := toBig(right)
+
+	var obj Object
+	switch code {
+	case OpAdd:
+		obj = newInteger(new(big.Int).Add(l, r))
+	case OpSub:
+		obj = newInteger(new(big.Int).Sub(l, r))
+	case OpMult:
+		obj = newInteger(new(big.Int).Mul(l, r))
+	case OpDiv:
+		if r.Sign() == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		obj = newInteger(new(big.Int).Quo(l, r))
+	case OpMod:
+		if r.Sign() == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		obj = newInteger(new(big.Int).Rem(l, r))
+	case OpLt:
+		obj = nativeBool(l.Cmp(r) < 0)
+	case OpLte:
+		obj = nativeBool(l.Cmp(r) <= 0)
+	case OpGt:
+		obj = nativeBool(l.Cmp(r) > 0)
+	case OpGte:
+		obj = nativeBool(l.Cmp(r) >= 0)
+	case OpEq:
+		obj = nativeBool(l.Cmp(r) == 0)
+	case OpNeq:
+		obj = nativeBool(l.Cmp(r) != 0)
+
+	}
+	// charged by its words, x = x * x doubles them every time
+	return vm.pushAllocated(obj)
+}
+
+// one of the operands is a float, the other an integer or a float
+func (vm *VM) floatInfix(code Opcode, left, right Object) error {
+	l := toFloat(left)
+	r := toFloat(right)
+
+	var obj Object
+	switch code {
+	case OpAdd:
+		obj = &Float{Value: l + r}
 	case OpSub:
-		obj = &Integer{Value: l - r}
+		obj = &Float{Value: l - r}
 	case OpMult:
-		obj = &Integer{Value: l * r}
+		obj = &Float{Value: l * r}
 	case OpDiv:
-		obj = &Integer{Value: l / r}
+		obj = &Float{Value: l / r}
 	case OpMod:
-		obj = &Integer{Value: l % r}
+		obj = &Float{Value: math.Mod(l, r)}
 	case OpLt:
-		obj = &Boolean{Value: l < r}
+		obj = nativeBool(l < r)
 	case OpLte:
-		obj = &Boolean{Value: l <= r}
+		obj = nativeBool(l <= r)
 	case OpGt:
-		obj = &Boolean{Value: l > r}
+		obj = nativeBool(l > r)
 	case OpGte:
-		obj = &Boolean{Value: l >= r}
+		obj = nativeBool(l >= r)
 	case OpEq:
-		obj = &Boolean{Value: l == r}
+		obj = nativeBool(l == r)
 	case OpNeq:
-		obj = &Boolean{Value: l != r}
+		obj = nativeBool(l != r)
 
 	}
-	vm.push(obj)
+	return vm.push(obj)
 }
 
-func (vm *VM) stringInfix(code Opcode, left, right Object) {
+func (vm *VM) booleanInfix(code Opcode, left, right Object) error {
+	l := left.(*Boolean).Value
+	r := right.(*Boolean).Value
+
+	var obj Object
+	switch code {
+	case OpEq:
+		obj = nativeBool(l == r)
+	case OpNeq:
+		obj = nativeBool(l != r)
+
+	default:
+		return fmt.Errorf("operator %s not supported for %s", operatorSymbol(code), BOOLEAN_OBJ)
+	}
+	return vm.push(obj)
+}
+
+func (vm *VM) stringInfix(code Opcode, left, right Object) error {
 	l := left.(*String).Value
 	r := right.(*String).Value
 
@@ -240,36 +520,191 @@ func (vm *VM) stringInfix(code Opcode, left, right Object) {
 	case OpAdd:
 		obj = &String{Value: l + r}
 	case OpEq:
-		obj = &Boolean{Value: l == r}
+		obj = nativeBool(l == r)
 	case OpNeq:
-		obj = &Boolean{Value: l != r}
+		obj = nativeBool(l != r)
 
 	default:
-		panic("illegal operator for string")
+		return fmt.Errorf("operator %s not supported for %s", operatorSymbol(code), STRING_OBJ)
+	}
+	return vm.pushAllocated(obj)
+}
+
+func (vm *VM) index(left, idx Object) (Object, error) {
+	switch left := left.(type) {
+	case *Array:
+		i, ok := idx.(*Integer)
+		if !ok {
+			return nil, fmt.Errorf("array index must be an integer, got %s", idx.Type())
+		}
+		if i.Value < 0 || i.Value >= len(left.Elements) {
+			return nil, fmt.Errorf("array index out of range! expect [%d, %d), got %d", 0, len(left.Elements), i.Value)
+		}
+		return left.Elements[i.Value], nil
+	case *Hash:
+		key, err := toHashKey(idx)
+		if err != nil {
+			return nil, err
+		}
+		// a missing key is nil
+		if pair, ok := left.Pairs[key]; ok {
+			return pair.Value, nil
+		}
+		return NullObj, nil
+	}
+	return nil, fmt.Errorf("index operator not supported: %s", left.Type())
+}
+
+func (vm *VM) setIndex(left, idx, value Object) error {
+	switch left := left.(type) {
+	case *Array:
+		i, ok := idx.(*Integer)
+		if !ok {
+			return fmt.Errorf("array index must be an integer, got %s", idx.Type())
+		}
+		if i.Value < 0 || i.Value >= len(left.Elements) {
+			return fmt.Errorf("array index out of range! expect [%d, %d), got %d", 0, len(left.Elements), i.Value)
+		}
+		left.Elements[i.Value] = value
+	case *Hash:
+		key, err := toHashKey(idx)
+		if err != nil {
+			return err
+		}
+		if _, ok := left.Pairs[key]; !ok {
+			if err := vm.allocate(1); err != nil {
+				return err
+			}
+		}
+		left.Pairs[key] = HashPair{Key: idx, Value: value}
+	default:
+		return fmt.Errorf("index assignment not supported: %s", left.Type())
 	}
-	vm.push(obj)
+	return nil
 }
 
-func (vm *VM) push(obj Object) {
-	if vm.stackIdx > StackSize {
-		panic("stack overflow")
+// a hope case passes if the result is what is expected,
+// numbers involving a float only need to be close enough
+func hopeEqual(expected, got Object) bool {
+	if isNumber(expected) && isNumber(got) &&
+		(expected.Type() == FLOAT_OBJ || got.Type() == FLOAT_OBJ) {
+		return floatEqual(toFloat(expected), toFloat(got))
+	}
+	if isInteger(expected) && isInteger(got) {
+		return toBig(expected).Cmp(toBig(got)) == 0
+	}
+	if e, ok := expected.(*Array); ok {
+		g, ok := got.(*Array)
+		if !ok || len(e.Elements) != len(g.Elements) {
+			return false
+		}
+		for i := range e.Elements {
+			if !hopeEqual(e.Elements[i], g.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(expected, got)
+}
+
+func (vm *VM) push(obj Object) error {
+	if vm.stackIdx >= vm.maxStack {
+		return vm.stackOverflow()
 	}
 	vm.stack[vm.stackIdx] = obj
 	vm.stackIdx++
+	return nil
 }
 
+// the compiler's stack verifier makes sure there is something to pop
 func (vm *VM) pop() Object {
-	if vm.stackIdx == 0 {
-		panic("empty stack!")
-	}
 	vm.stackIdx--
 	return vm.stack[vm.stackIdx]
 }
 
+// the last value popped off the stack, after Run it is the final result
+func (vm *VM) LastPopped() Object {
+	return vm.stack[vm.stackIdx]
+}
+
 func (vm *VM) currentFrame() *Frame {
 	return vm.frames[len(vm.frames)-1]
 }
 
+// the frame of fn and its locals must fit
+func (vm *VM) checkCall(fn *CompiledFunction, numParas int) error {
+	if vm.limits.MaxCallDepth > 0 && len(vm.frames) > vm.limits.MaxCallDepth {
+		return &LimitError{Limit: "call depth", Max: vm.limits.MaxCallDepth}
+	}
+	if vm.stackIdx-numParas+fn.NumLocals >= vm.maxStack {
+		return vm.stackOverflow()
+	}
+	return nil
+}
+
+// the callee and its arguments take the place of the caller's,
+// the frame returns to where the caller would have
+func (vm *VM) tailCall(frame *Frame, fn *CompiledFunction, numParas int) error {
+	copy(vm.stack[frame.bp-1:], vm.stack[vm.stackIdx-1-numParas:vm.stackIdx])
+	vm.stackIdx = frame.bp + numParas
+	if err := vm.checkCall(fn, numParas); err != nil {
+		return err
+	}
+	vm.stackIdx = frame.bp + fn.NumLocals
+	vm.clearLocals(frame.bp+numParas, vm.stackIdx)
+	if vm.calls != nil {
+		vm.calls.Return(frame.fn)
+		vm.calls.Call(fn)
+	}
+	frame.fn = fn
+	return nil
+}
+
+// the locals after the arguments are unset until the function sets them,
+// not what an earlier call left in their slots
+func (vm *VM) clearLocals(from, to int) {
+	for i := from; i < to; i++ {
+		vm.stack[i] = nil
+	}
+}
+
+func (vm *VM) stackOverflow() error {
+	if vm.maxStack < StackSize {
+		return &LimitError{Limit: "stack", Max: vm.maxStack}
+	}
+	return fmt.Errorf("stack overflow")
+}
+
+// pushes a value the program just created
+func (vm *VM) pushAllocated(obj Object) error {
+	if err := vm.allocate(allocationSize(obj)); err != nil {
+		return err
+	}
+	return vm.push(obj)
+}
+
+func isOneOf(obj Object, objs []Object) bool {
+	for _, o := range objs {
+		if o == obj {
+			return true
+		}
+	}
+	return false
+}
+
+// charges n against MaxAllocations
+func (vm *VM) allocate(n int) error {
+	if vm.limits.MaxAllocations == 0 {
+		return nil
+	}
+	vm.allocated += n
+	if vm.allocated > vm.limits.MaxAllocations {
+		return &LimitError{Limit: "allocations", Max: vm.limits.MaxAllocations}
+	}
+	return nil
+}
+
 func (vm *VM) pushFrame(f *Frame) {
 	vm.frames = append(vm.frames, f)
 }
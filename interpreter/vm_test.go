@@ -1,11 +1,16 @@
 package interpreter
 
 import (
-	"io"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"log"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestVM_Run1(t *testing.T) {
@@ -179,7 +184,6 @@ func TestVM_Run7(t *testing.T) {
 }
 
 func TestVM_Run8(t *testing.T) {
-	log.SetOutput(io.Discard)
 	input := `
 	add := func(x,y) {
 		x
@@ -234,7 +238,6 @@ func TestVM_Run9(t *testing.T) {
 }
 
 func TestVM_Run10(t *testing.T) {
-	log.SetOutput(io.Discard)
 
 	input := `
 	fib := func(n) {
@@ -264,7 +267,6 @@ func TestVM_Run10(t *testing.T) {
 
 func TestVM_Run11(t *testing.T) {
 	// log.SetOutput(os.Stdout)
-	log.SetOutput(io.Discard)
 	input := `
 	fib := func(n) {
 		if n <= 2{
@@ -296,7 +298,6 @@ func TestVM_Run11(t *testing.T) {
 }
 func TestVM_Run12(t *testing.T) {
 	// log.SetOutput(os.Stdout)
-	log.SetOutput(io.Discard)
 	input := `
 	fib := func(n) {
 		if n <= 2{
@@ -334,7 +335,6 @@ func TestVM_Run12(t *testing.T) {
 
 func TestVM_Run13(t *testing.T) {
 	// log.SetOutput(os.Stdout)
-	log.SetOutput(io.Discard)
 	input := `
 	// a wrong function
 	// virtual machine would give warning
@@ -378,7 +378,6 @@ func TestVM_Run13(t *testing.T) {
 
 func TestVM_Run14(t *testing.T) {
 	// log.SetOutput(os.Stdout)
-	log.SetOutput(io.Discard)
 	input := `
 	fib := func(n int) {
 		if n <= 3 {
@@ -411,15 +410,732 @@ func TestVM_Run14(t *testing.T) {
 	in := strings.NewReader(input)
 	lexer := NewLexer(in)
 	parser := NewParser(lexer)
-	node, err := parser.Parse(nil)
-	if err != nil {
-		log.Println(err)
+	node, diags := parser.Parse(nil)
+	if diags != nil {
+		t.Fatal(diags)
 	}
 	compiler := NewCompiler(false)
-	compiler.Compile(node)
+	// reserver is a typo, the compiler reports it instead of panicking
+	err := compiler.Compile(node)
+	if err == nil || !strings.Contains(err.Error(), "undefined variable reserver") {
+		t.Fatalf("want undefined variable error, got %v", err)
+	}
+}
 
-	compiler.show()
+func runVM(t *testing.T, input string) *VM {
+	t.Helper()
+	parser := NewParser(NewLexer(strings.NewReader(input)))
+	node, diags := parser.Parse(nil)
+	if diags != nil {
+		t.Fatal(diags)
+	}
+	if diags := NewTypeChecker().Check(node); diags != nil {
+		t.Fatal(diags)
+	}
+	compiler := NewCompiler(true)
+	if err := compiler.Compile(node); err != nil {
+		t.Fatal(err)
+	}
+	vm := NewVM(compiler.Bytecode())
+	if err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	// every program tested here runs on the register VM too
+	rvm := NewRegisterVM(compiler.Bytecode())
+	if err := rvm.Run(); err != nil {
+		t.Fatalf("register VM: %v", err)
+	}
+	if got, want := rvm.LastPopped().String(), vm.LastPopped().String(); got != want {
+		t.Errorf("register VM: %s, VM: %s", got, want)
+	}
+	return vm
+}
 
+func TestVM_StackHygiene(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"1+2\n2+3", "5"},
+		{"a := 1", "nil"},
+		{"a := 1\na = 2", "nil"},
+		{"if 1 > 2 { 1 }", "nil"},
+		{"if 1 < 2 { 1 }", "1"},
+		{"if 1 > 2 { 1 } else if 2 > 3 { 2 }", "nil"},
+		{"if 1 > 2 { 1 } else { 3 }", "3"},
+		{"if 1 < 2 { }", "nil"},
+		{`
+		if 1 < 2 {
+			if 2 < 1 { 1 } else { 2 }
+		} else { 3 }`, "2"},
+		{`
+		if 1 > 2 { 1 } else {
+			if 2 < 1 { 1 } else if 3 > 2 { 4 }
+		}`, "4"},
+		{"n := 0\nwhile n < 10 { n = n + 1 }", "nil"},
+		{"n := 0\nwhile n < 10 { n = n + 1\nn }\nn", "10"},
+		{"1 < 2 ? 3 : 4", "3"},
+		{`
+		f := func(x) {
+			if x > 0 { x }
+		}
+		f(-1)`, "nil"},
+		{"f := func(x) { }\nf(1)", "nil"},
+		{"f := func(x) { y := x }\nf(1)", "nil"},
+		{"f := func(x) { x < 0 ? -x : x }\nf(-7)", "7"},
+		{`
+		f := func(x) { x * 2 }
+		i := 0
+		while i < 100000 {
+			f(i)
+			i + 1
+			if i > 50 { i }
+			i = i + 1
+		}
+		i`, "100000"},
+	}
+	for _, tt := range tests {
+		vm := runVM(t, tt.input)
+		if got := vm.LastPopped().String(); got != tt.want {
+			t.Errorf("%q: want %s, got %s", tt.input, tt.want, got)
+		}
+		if vm.stackIdx != 0 {
+			t.Errorf("%q: %d values left on the stack", tt.input, vm.stackIdx)
+		}
+	}
+}
+
+func TestVM_StackHygieneHope(t *testing.T) {
+	input := `
+	add := func(x int, y int) {
+		x + y
+	} hope {
+		1, 2 -> 3
+		fuzzing 5
+	}
+	add(1, 2)
+	`
+	parser := NewParser(NewLexer(strings.NewReader(input)))
+	node, _ := parser.Parse(nil)
+	compiler := NewCompiler(false)
+	if err := compiler.Compile(node); err != nil {
+		t.Fatal(err)
+	}
 	vm := NewVM(compiler.Bytecode())
 	vm.Run()
+	if vm.stackIdx != 0 {
+		t.Errorf("%d values left on the stack", vm.stackIdx)
+	}
+}
+
+func TestVerifyStack(t *testing.T) {
+	tests := []struct {
+		ins     Instructions
+		height  int
+		wantErr bool
+	}{
+		{Instructions{byte(OpNull)}, 1, false},
+		{Instructions{byte(OpNull), byte(OpPop)}, 0, false},
+		{Instructions{byte(OpPop)}, 0, true},
+		{Instructions{byte(OpNull), byte(OpAdd)}, 0, true},
+		{Instructions{byte(OpNull), byte(OpNull), byte(OpReturnValue)}, 0, true},
+		{Instructions{byte(OpNull), byte(OpReturnValue)}, -1, false},
+		// if true { nil } : the branches leave different heights
+		{Instructions{byte(OpNull), byte(OpJumpIfFalse), 0, 5, byte(OpNull)}, 0, true},
+		// if true { nil } else { nil }
+		{Instructions{byte(OpNull), byte(OpJumpIfFalse), 0, 8, byte(OpNull), byte(OpJump), 0, 9, byte(OpNull)}, 1, false},
+	}
+	for i, tt := range tests {
+		height, err := verifyStack(tt.ins)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%d: wantErr %v, got %v", i, tt.wantErr, err)
+			continue
+		}
+		if err == nil && height != tt.height {
+			t.Errorf("%d: want height %d, got %d", i, tt.height, height)
+		}
+	}
+}
+
+func TestVM_Hash(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`{}`, "{}"},
+		{`{"a": 1, "b": 2}`, "{a: 1, b: 2}"},
+		{`{2: "two", 10: "ten", 1: "one"}`, "{1: one, 2: two, 10: ten}"},
+		{`{true: 1}[1 < 2]`, "1"},
+		{`{"a": 1}["b"]`, "nil"},
+		{`
+		h := {
+			"one": 1,
+			"two": 1 + 1
+		}
+		h["t" + "wo"]`, "2"},
+		{`
+		h := {"a": 1}
+		h["b"] = 2
+		h["a"] = h["a"] + 10
+		h`, "{a: 11, b: 2}"},
+		{`
+		h := {"a": 1, "b": 2, "c": 3}
+		delete(h, "b")
+		[keys(h), values(h), has(h, "a"), has(h, "b"), len(h)]`, "[[a, c], [1, 3], true, false, 2]"},
+		{`[1, 2, 3][1]`, "2"},
+		{`
+		a := [1, 2, 3]
+		a[0] = a[2] * 2
+		append(a, 4)`, "[6, 2, 3, 4]"},
+		{`
+		count := func(words) {
+			counts := {}
+			i := 0
+			while i < len(words) {
+				w := words[i]
+				if has(counts, w) {
+					counts[w] = counts[w] + 1
+				} else {
+					counts[w] = 1
+				}
+				i = i + 1
+			}
+			counts
+		}
+		count(["a", "b", "a"])`, "{a: 2, b: 1}"},
+	}
+	for _, tt := range tests {
+		vm := runVM(t, tt.input)
+		if got := vm.LastPopped().String(); got != tt.want {
+			t.Errorf("%q: want %s, got %s", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestVM_Float(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"1.5 + 2.5", "4.0"},
+		{"1 + 0.5", "1.5"},
+		{"0.5 * 4", "2.0"},
+		{"7 / 2", "3"},
+		{"7 / 2.0", "3.5"},
+		{"7.5 % 2", "1.5"},
+		{"1e3 - 1", "999.0"},
+		{"2.5 > 2", "true"},
+		{"2 == 2.0", "true"},
+		{"x := 1.5\n-x", "-1.5"},
+		{"int(3.9) + int(-3.9)", "0"},
+		{`[float(1), float("2.5"), int("42"), string(1.5) + "!"]`, "[1.0, 2.5, 42, 1.5!]"},
+		{`
+		mean := func(xs) {
+			sum := 0.0
+			i := 0
+			while i < len(xs) {
+				sum = sum + xs[i]
+				i = i + 1
+			}
+			sum / len(xs)
+		}
+		mean([1, 2, 3, 4.5])`, "2.625"},
+	}
+	for _, tt := range tests {
+		vm := runVM(t, tt.input)
+		if got := vm.LastPopped().String(); got != tt.want {
+			t.Errorf("%q: want %s, got %s", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestVM_BigInt(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"9223372036854775808 - 1 == 9223372036854775807", "true"},
+		{"4294967296 * 4294967296", "18446744073709551616"},
+		{"x := -9223372036854775807 - 1\n-x", "9223372036854775808"},
+		{"x := -9223372036854775807 - 1\nx / -1", "9223372036854775808"},
+		{"100000000000000000000 / 3", "33333333333333333333"},
+		{"-100000000000000000000 % 7", "-2"},
+		{"(9223372036854775807 + 1) * 2 > 9223372036854775807", "true"},
+		{"9223372036854775807 + 1.0", "9.223372036854776e+18"},
+		{"int(1e20)", "100000000000000000000"},
+		{`
+		fib := func(n) {
+			a := 0
+			b := 1
+			i := 0
+			while i < n {
+				t := a + b
+				a = b
+				b = t
+				i = i + 1
+			}
+			a
+		}
+		fib(100)`, "354224848179261915075"},
+		{`{9223372036854775808: "big"}[9223372036854775807 + 1]`, "big"},
+	}
+	for _, tt := range tests {
+		vm := runVM(t, tt.input)
+		if got := vm.LastPopped().String(); got != tt.want {
+			t.Errorf("%q: want %s, got %s", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestVM_Nil(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"nil", "nil"},
+		{"nil == nil", "true"},
+		{"nil != nil", "false"},
+		{"1 == nil", "false"},
+		{`"a" != nil`, "true"},
+		{`1 == "1"`, "false"},
+		{"x := {}[1]\nx == nil", "true"},
+		{`{"a": 1}["b"] == nil`, "true"},
+		{`
+		find := func(xs, x) {
+			i := 0
+			found := nil
+			while i < len(xs) {
+				if xs[i] == x {
+					found = i
+				}
+				i = i + 1
+			}
+			found
+		}
+		results := [find([1, 2, 3], 2), find([1, 2, 3], 4)]
+		results`, "[1, nil]"},
+	}
+	for _, tt := range tests {
+		vm := runVM(t, tt.input)
+		if got := vm.LastPopped().String(); got != tt.want {
+			t.Errorf("%q: want %s, got %s", tt.input, tt.want, got)
+		}
+	}
+}
+
+// a negated or inverted value is a new one, the constant stays as it was
+func TestVM_ImmutablePrimitives(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"x := 5\ny := -x\nx", "5"},
+		{"x := 5\ny := -x\ny", "-5"},
+		{"b := true\nc := !b\n[b, c, true]", "[true, false, true]"},
+		{"s := \"a\"\nt := s + \"b\"\n[s, t]", "[a, ab]"},
+		{"f := func() { -1 }\n[f(), f(), f()]", "[-1, -1, -1]"},
+		{"i := 0\nsum := 0\nwhile i < 4 {\nsum = sum + -1\ni = i + 1\n}\nsum", "-4"},
+		{"i := 0\nok := true\nwhile i < 3 {\nok = !ok\ni = i + 1\n}\n[ok, !true]", "[false, false]"},
+		{"a := [1, 2]\nb := -a[0]\na", "[1, 2]"},
+	}
+	for _, tt := range tests {
+		vm := runVM(t, tt.input)
+		if got := vm.LastPopped().String(); got != tt.want {
+			t.Errorf("%q: want %s, got %s", tt.input, tt.want, got)
+		}
+	}
+
+	// comparisons don't allocate, small integers come from the cache
+	if vm := runVM(t, "1 < 2"); vm.LastPopped() != True {
+		t.Errorf("1 < 2 isn't True")
+	}
+	if vm := runVM(t, "2 == 3"); vm.LastPopped() != False {
+		t.Errorf("2 == 3 isn't False")
+	}
+	if newInt(7) != newInt(7) || newInt(smallIntMax+1) == newInt(smallIntMax+1) {
+		t.Errorf("only the small integers are cached")
+	}
+	if vm := runVM(t, "x := 500 + 500\nx"); vm.LastPopped() != newInt(1000) {
+		t.Errorf("500 + 500 isn't the cached 1000")
+	}
+}
+
+func TestVM_RuntimeError(t *testing.T) {
+	tests := []struct {
+		input    string
+		message  string
+		line     int
+		function string
+		stack    []StackEntry
+	}{
+		{"1 / 0", "division by zero", 1, "<main>", []StackEntry{{"<main>", 1}}},
+		{"x := 1\nx % 0", "division by zero", 2, "<main>", []StackEntry{{"<main>", 2}}},
+		{`
+f := func(x) {
+	y := 10 / x
+	y
+}
+g := func(a) {
+	f(a) + 1
+}
+g(0)`, "division by zero", 3, "f", []StackEntry{{"f", 3}, {"g", 7}, {"<main>", 9}}},
+		{"if 1 {\n2\n}", "condition must be a boolean, got INTEGER", 1, "<main>", nil},
+		{"x := 3\nx(1)", "calling non-function INTEGER", 2, "<main>", nil},
+		{`1 + "a"`, "type mismatch: INTEGER + STRING", 1, "<main>", nil},
+		{`-"a"`, "operator - not supported for STRING", 1, "<main>", nil},
+		{"[1, 2][5]", "array index out of range! expect [0, 2), got 5", 1, "<main>", nil},
+		{"{[1]: 2}", "unusable as hash key: ARRAY", 1, "<main>", nil},
+		{"\n\nlen(1)", "wrong argument type in len function", 3, "<main>", nil},
+		{"x := x + 1", "variable used before definition", 1, "<main>", nil},
+		{"f := func(a) {\nb := b + a\nb\n}\nf(1)", "variable used before definition", 2, "f", []StackEntry{{"f", 2}, {"<main>", 5}}},
+		{"f := func(n) {\n1 + f(n + 1)\n}\nf(0)", "stack overflow", 2, "f", nil},
+		{"add := func(x int, y int) {\nx + y\n}\nadd(1)", "add wants 2 arguments, got 1", 4, "<main>", nil},
+		{"add := func(x int, y int) {\nx + y\n}\nadd(1, 2, 3)", "add wants 2 arguments, got 3", 4, "<main>", nil},
+		{`f := func(s string) {
+	s
+}
+g := func(x) {
+	f(x)
+}
+g(1.5)`, "argument 1 of f must be string, got float", 5, "g", []StackEntry{{"g", 5}, {"<main>", 7}}},
+	}
+	for _, tt := range tests {
+		parser := NewParser(NewLexer(strings.NewReader(tt.input)))
+		node, _ := parser.Parse(nil)
+		compiler := NewCompiler(true)
+		if err := compiler.Compile(node); err != nil {
+			t.Fatal(err)
+		}
+		err := NewVM(compiler.Bytecode()).Run()
+		re, ok := err.(*RuntimeError)
+		if !ok {
+			t.Errorf("%q: want a *RuntimeError, got %v", tt.input, err)
+			continue
+		}
+		if re.Message != tt.message || re.Line != tt.line || re.Function != tt.function {
+			t.Errorf("%q: want %q at line %d in %s, got %q at line %d in %s",
+				tt.input, tt.message, tt.line, tt.function, re.Message, re.Line, re.Function)
+		}
+		if tt.stack != nil && !reflect.DeepEqual(re.Stack, tt.stack) {
+			t.Errorf("%q: want stack %v, got %v", tt.input, tt.stack, re.Stack)
+		}
+
+		// how deep a stack overflows depends on the VM
+		err = NewRegisterVM(compiler.Bytecode()).Run()
+		rre, ok := err.(*RuntimeError)
+		if !ok || rre.Message != re.Message || rre.Line != re.Line || rre.Function != re.Function ||
+			(tt.stack != nil && !reflect.DeepEqual(rre.Stack, re.Stack)) {
+			t.Errorf("%q: register VM %v, VM %v", tt.input, err, re)
+		}
+	}
+}
+
+func TestVM_UnknownOpcode(t *testing.T) {
+	bc := Bytecode{instructions: append(Make(OpNull), 200), lines: []LineInfo{{0, 1}}}
+	err := NewVM(bc).Run()
+	if re, ok := err.(*RuntimeError); !ok || re.Message != "unknown opcode 200" || re.Line != 1 {
+		t.Errorf("want unknown opcode 200 at line 1, got %v", err)
+	}
+}
+
+// a variable read before it is set is an error, not a nil,
+// and not what an earlier call left in the slot of a local
+func TestVM_UndefinedVariable(t *testing.T) {
+	tests := []struct {
+		input    string
+		line     int
+		function string
+	}{
+		{"x := x + 1", 1, "<main>"},
+		{"f := func() {\nx := x\nx\n}\nf()", 2, "f"},
+		{"g := func() {\ny := 5\ny\n}\ng()\nf := func() {\nx := x + 1\nx\n}\nf()", 7, "f"},
+		{"f := func(c) {\nif c {\nx := 1\n}\nx\n}\nf(true)\nf(false)", 5, "f"},
+	}
+	for _, tt := range tests {
+		bc := compileString(t, tt.input)
+		for _, err := range []error{NewVM(bc).Run(), NewRegisterVM(bc).Run()} {
+			re, ok := err.(*RuntimeError)
+			if !ok || !errors.Is(err, errUndefinedVariable) || re.Line != tt.line || re.Function != tt.function {
+				t.Errorf("%q: want %v at line %d in %s, got %v", tt.input, errUndefinedVariable, tt.line, tt.function, err)
+			}
+		}
+	}
+}
+
+func TestVM_ArgumentCheck(t *testing.T) {
+	input := `
+	half := func(x float) float {
+		x / 2
+	}
+	big := func(n int) {
+		n
+	}
+	id := func(a, b bool) {
+		b
+	}
+	results := [half(3), half(1.0), big(99999999999999999999), id("a", true)]
+	results`
+	vm := runVM(t, input)
+	if got, want := vm.LastPopped().String(), "[1.5, 0.5, 99999999999999999999, true]"; got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
+
+func TestVM_Limits(t *testing.T) {
+	tests := []struct {
+		input  string
+		limits Limits
+		want   LimitError
+	}{
+		{"while true {\n}", Limits{MaxSteps: 1000}, LimitError{"steps", 1000}},
+		{"f := func(n) {\n1 + f(n + 1)\n}\nf(0)", Limits{MaxCallDepth: 50}, LimitError{"call depth", 50}},
+		{"f := func(n) {\n1 + f(n + 1)\n}\nf(0)", Limits{MaxStack: 100}, LimitError{"stack", 100}},
+		{"a := []\nwhile true {\na = append(a, 1)\n}", Limits{MaxAllocations: 5000}, LimitError{"allocations", 5000}},
+		{"s := \"\"\nwhile true {\ns = s + \"ab\"\n}", Limits{MaxAllocations: 5000}, LimitError{"allocations", 5000}},
+		{"while true {\nh := {1: [1, 2]}\n}", Limits{MaxAllocations: 5000}, LimitError{"allocations", 5000}},
+		{"h := {}\ni := 0\nwhile true {\nh[i] = i\ni = i + 1\n}", Limits{MaxAllocations: 100, MaxSteps: 1e6}, LimitError{"allocations", 100}},
+		{"x := 3\nwhile true {\nx = x * x\n}", Limits{MaxAllocations: 5000, MaxSteps: 1e6}, LimitError{"allocations", 5000}},
+	}
+	for _, tt := range tests {
+		vm := NewVM(compileString(t, tt.input))
+		vm.SetLimits(tt.limits)
+		err := vm.Run()
+		var le *LimitError
+		var re *RuntimeError
+		if !errors.As(err, &le) || *le != tt.want || !errors.As(err, &re) || re.Line == 0 {
+			t.Errorf("%q: want %v with a line, got %v", tt.input, &tt.want, err)
+		}
+	}
+
+	// within the limits nothing changes
+	vm := NewVM(compileString(t, "a := [1, 2, 3]\nlen(append(a, 4))"))
+	vm.SetLimits(Limits{MaxSteps: 100, MaxCallDepth: 2, MaxStack: 10, MaxAllocations: 7})
+	if err := vm.Run(); err != nil || vm.LastPopped().String() != "4" {
+		t.Errorf("want 4, got %v %v", vm.LastPopped(), err)
+	}
+}
+
+func TestVM_RunContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := NewVM(compileString(t, "while true {\n}")).RunContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want the deadline, got %v", err)
+	}
+}
+
+type opCounter map[string]int
+
+func (c opCounter) Step(fn *CompiledFunction, pc int, stack []Object) {
+	def, _ := Lookup(Opcode(fn.Instructions[pc]))
+	c[fn.DisplayName()+" "+def.Name]++
+}
+
+func TestVM_Tracer(t *testing.T) {
+	counts := opCounter{}
+	vm := NewVM(compileString(t, "f := func(n) {\nn + 1\n}\nf(1) + f(2)"))
+	vm.SetTracer(counts)
+	if err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := opCounter{
+		"<main> OpConstant": 3, "<main> OpSetGlobal": 1, "<main> OpGetGlobal": 2,
+		"<main> OpCall": 2, "<main> OpAdd": 1,
+		"f OpGetLocal": 2, "f OpConstant": 2, "f OpAdd": 2, "f OpReturnValue": 2,
+	}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("want %v, got %v", want, counts)
+	}
+}
+
+// ====== benchmarks
+func benchmarkVM(b *testing.B, input string) {
+	bc := compileBenchmark(b, input)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := NewVM(bc).Run(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func compileBenchmark(b *testing.B, input string) Bytecode {
+	b.ReportAllocs()
+	parser := NewParser(NewLexer(strings.NewReader(input)))
+	node, diags := parser.Parse(nil)
+	if diags != nil {
+		b.Fatal(diags)
+	}
+	compiler := NewCompiler(true)
+	if err := compiler.Compile(node); err != nil {
+		b.Fatal(err)
+	}
+	return compiler.Bytecode()
+}
+
+// recursion heavy
+const fib25Program = `fib := func(n int) int {
+	n <= 2 ? n : fib(n-1) + fib(n-2)
+}
+fib(25)`
+
+// loop heavy, on globals
+const loopProgram = `i := 0
+sum := 0
+while i < 100000 {
+	sum = sum + i % 7
+	i = i + 1
+}
+sum`
+
+// loop heavy, on locals as in a rule
+const localLoopProgram = `score := func(n) {
+	i := 0
+	sum := 0
+	while i < n {
+		if i % 3 == 0 { sum = sum + i * 2 } else { sum = sum - 1 }
+		i = i + 1
+	}
+	sum
+}
+score(100000)`
+
+func BenchmarkVM_Fib25(b *testing.B) {
+	benchmarkVM(b, fib25Program)
+}
+
+func BenchmarkVM_Loop(b *testing.B) {
+	benchmarkVM(b, loopProgram)
+}
+
+func BenchmarkVM_LocalLoop(b *testing.B) {
+	benchmarkVM(b, localLoopProgram)
+}
+
+func BenchmarkVM_StringConcat(b *testing.B) {
+	benchmarkVM(b, `s := ""
+i := 0
+while i < 1000 {
+	s = s + "ab"
+	i = i + 1
+}
+len(s)`)
+}
+
+func TestVM_StreamTracer(t *testing.T) {
+	bc := compileString(t, "f := func(n int) {\nn * 2\n}\nf(3) + f(4)")
+
+	var out bytes.Buffer
+	vm := NewVM(bc)
+	vm.SetTracer(NewTextTracer(&out, bc, "f"))
+	if err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	want := `f             2  0000 OpGetLocal 0                   [func f, 3]
+f             2  0002 OpConstant 0         ; 2       [func f, 3, 3]
+f             2  0005 OpMult                         [.. 3, 3, 2]
+f             1  0006 OpReturnValue                  [func f, 3, 6]
+`
+	if got := out.String(); !strings.HasPrefix(got, want) || strings.Count(got, "\n") != 8 {
+		t.Errorf("want\n%s\ngot\n%s", want, got)
+	}
+
+	out.Reset()
+	vm = NewVM(bc)
+	vm.SetTracer(NewJSONTracer(&out, bc))
+	if err := vm.Run(); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 17 {
+		t.Fatalf("want 17 instructions, got %d", len(lines))
+	}
+	var event traceEvent
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &event); err != nil {
+		t.Fatal(err)
+	}
+	want2 := traceEvent{Func: "<main>", Line: 4, PC: 22, Op: "OpAdd", Height: 2, Stack: []string{"6", "8"}}
+	if !reflect.DeepEqual(event, want2) {
+		t.Errorf("want %+v, got %+v", want2, event)
+	}
+}
+
+func TestVM_TailCall(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`sum := func(n, acc) {
+	if n == 0 { acc } else { sum(n - 1, acc + n) }
+}
+sum(1000000, 0)`, "500000500000"},
+		{`count := func(n, acc) { n == 0 ? acc : count(n - 1, acc + 1) }
+count(100000, 0)`, "100000"},
+		{`odd := nil
+even := func(n) { if n == 0 { true } else { odd(n - 1) } }
+odd = func(n) { if n == 0 { false } else { even(n - 1) } }
+[even(100001), odd(100001)]`, "[false, true]"},
+		{`last := func(a) { len(a) }
+last([1, 2])`, "2"},
+	}
+	for _, tt := range tests {
+		bc := compileString(t, tt.input)
+		vm := NewVM(bc)
+		// <main> and the one frame every call reuses
+		vm.SetLimits(Limits{MaxCallDepth: 2})
+		if err := vm.Run(); err != nil {
+			t.Errorf("%q: %v", tt.input, err)
+			continue
+		}
+		if got := vm.LastPopped().String(); got != tt.want {
+			t.Errorf("%q: want %s, got %s", tt.input, tt.want, got)
+		}
+
+		rvm := NewRegisterVM(bc)
+		if err := rvm.Run(); err != nil || len(rvm.frames) != 0 {
+			t.Errorf("%q: register VM %v with %d frames", tt.input, err, len(rvm.frames))
+		} else if got := rvm.LastPopped().String(); got != tt.want {
+			t.Errorf("%q: register VM %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestCompiler_TailCall(t *testing.T) {
+	bc := compileString(t, `g := func(n) { n }
+f := func(n) {
+	x := g(n)
+	if n > 0 { g(n) + 1 } else { g(n) }
+}
+f(1)`)
+	var fn *CompiledFunction
+	for _, c := range bc.constants {
+		if f, ok := c.(*CompiledFunction); ok && f.Name == "f" {
+			fn = f
+		}
+	}
+	if got := strings.Count(fn.Instructions.String(), "OpTailCall"); got != 1 {
+		t.Errorf("%d tail calls in\n%s", got, fn.Instructions)
+	}
+	if got := strings.Count(fn.Instructions.String(), "OpCall"); got != 2 {
+		t.Errorf("%d calls in\n%s", got, fn.Instructions)
+	}
+	// <main> has no frame to reuse
+	if strings.Contains(bc.instructions.String(), "OpTailCall") {
+		t.Errorf("tail call in <main>\n%s", bc.instructions)
+	}
+
+	// the caller's frame is gone from the stack of an error
+	err := NewVM(compileString(t, `f := func(x) {
+	10 / x
+}
+g := func(a) {
+	f(a)
+}
+g(0)`)).Run()
+	re, ok := err.(*RuntimeError)
+	if !ok || !reflect.DeepEqual(re.Stack, []StackEntry{{"f", 2}, {"<main>", 7}}) {
+		t.Errorf("got %v", err)
+	}
 }
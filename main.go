@@ -1,42 +1,299 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"flag"
 	"fmt"
-	"io"
-	"log"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"stone/interpreter"
 )
 
 // the package name must be the same as foldername
+//
+//	ho [-f file] [-p]              compile and run a source file, without one start the REPL
+//	ho repl                        read, compile and run line by line
+//	ho build [-o out.hoc] [-p] file  compile a source file to bytecode
+//	ho run [-p] [-trace] file      run a source file or a .hoc file
+//	ho run -cpuprofile out.pprof -profile file  profile the calls, for go tool pprof and as text
+//	ho run -O file                 fold constants and leave out dead code, ho, build and disasm take -O too
+//	ho run -regvm file             run on the register VM prototype
+//	ho disasm [-p] file            print the bytecode of a source or .hoc file
+//	ho debug [-p] [-dap] file      debug a source file in the terminal, or for an editor over stdio
 func main() {
-	log.SetOutput(io.Discard)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "repl":
+			interpreter.StartREPL(os.Stdin, os.Stdout)
+			return
+		case "build":
+			os.Exit(build(os.Args[2:]))
+		case "run":
+			os.Exit(run(os.Args[2:]))
+		case "disasm":
+			os.Exit(disasm(os.Args[2:]))
+		case "debug":
+			os.Exit(debug(os.Args[2:]))
+		}
+	}
 
-	filename := flag.String("f", "sourcecode.txt", "the file containing source code")
+	filename := flag.String("f", "", "the file containing source code")
 	productive := flag.Bool("p", false, "the compiler would ignore hope block if this variable is true")
+	optimize := flag.Bool("O", false, "fold constants and leave out dead code")
 	flag.Parse()
+	if *filename == "" {
+		interpreter.StartREPL(os.Stdin, os.Stdout)
+		return
+	}
+
+	bc, ok := compileFile(*filename, *productive, *optimize)
+	if !ok {
+		os.Exit(1)
+	}
+	os.Exit(runBytecode(bc, nil))
+}
+
+func build(args []string) int {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	out := flags.String("o", "", "the output file, the source file with .hoc by default")
+	productive := flags.Bool("p", false, "leave the hope blocks out")
+	strip := flags.Bool("s", false, "leave out function names and line tables")
+	optimize := flags.Bool("O", false, "fold constants and leave out dead code")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: ho build [-o out.hoc] [-p] [-s] [-O] file")
+		return 2
+	}
+	filename := flags.Arg(0)
+	if *out == "" {
+		*out = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".hoc"
+	}
 
-	input, err := os.ReadFile(*filename)
+	bc, ok := compileFile(filename, *productive, *optimize)
+	if !ok {
+		return 1
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	err = interpreter.WriteBytecode(f, bc, !*strip)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
+		return 1
 	}
+	return 0
+}
 
-	in := strings.NewReader(string(input))
-	lexer := interpreter.NewLexer(in)
-	parser := interpreter.NewParser(lexer)
-	node, err := parser.Parse(nil)
+func run(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	productive := flags.Bool("p", false, "the compiler would ignore hope block if this variable is true")
+	optimize := flags.Bool("O", false, "fold constants and leave out dead code")
+	trace := flags.Bool("trace", false, "print every instruction executed to stderr")
+	traceFormat := flags.String("trace-format", "text", "text, or json for one object per line")
+	traceFunc := flags.String("trace-func", "", "trace only these functions, separated by commas")
+	cpuprofile := flags.String("cpuprofile", "", "write a pprof profile of the calls to this file")
+	profile := flags.Bool("profile", false, "print the calls, instructions and time of every function to stderr")
+	regvm := flags.Bool("regvm", false, "run on the register VM prototype")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: ho run [-p] [-O] [-regvm] [-trace] [-trace-format text|json] [-trace-func f,g] [-cpuprofile out.pprof] [-profile] file")
+		return 2
+	}
+	if *trace && (*cpuprofile != "" || *profile) {
+		fmt.Fprintln(os.Stderr, "-trace can't be used with -cpuprofile or -profile")
+		return 2
+	}
+	if *regvm && (*trace || *cpuprofile != "" || *profile) {
+		fmt.Fprintln(os.Stderr, "the register VM can't trace or profile")
+		return 2
+	}
+	bc, ok := loadFile(flags.Arg(0), *productive, *optimize)
+	if !ok {
+		return 1
+	}
+	if *regvm {
+		vm := interpreter.NewRegisterVM(bc)
+		if err := vm.Run(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println(vm.LastPopped())
+		return 0
+	}
+	if *cpuprofile != "" || *profile {
+		return runProfiled(bc, flags.Arg(0), *cpuprofile, *profile)
+	}
+	if !*trace {
+		return runBytecode(bc, nil)
+	}
+
+	var funcs []string
+	if *traceFunc != "" {
+		funcs = strings.Split(*traceFunc, ",")
+	}
+	w := bufio.NewWriter(os.Stderr)
+	defer w.Flush()
+	var tracer *interpreter.StreamTracer
+	switch *traceFormat {
+	case "text":
+		tracer = interpreter.NewTextTracer(w, bc, funcs...)
+	case "json":
+		tracer = interpreter.NewJSONTracer(w, bc, funcs...)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown trace format %s\n", *traceFormat)
+		return 2
+	}
+	return runBytecode(bc, tracer)
+}
+
+// the profile is written even if the program fails
+func runProfiled(bc interpreter.Bytecode, filename string, cpuprofile string, summary bool) int {
+	profiler := interpreter.NewProfiler(filename)
+	code := runBytecode(bc, profiler)
+	profiler.Stop()
+	if summary {
+		profiler.WriteSummary(os.Stderr)
+	}
+	if cpuprofile == "" {
+		return code
+	}
+	f, err := os.Create(cpuprofile)
 	if err != nil {
-		log.Println(err)
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	err = profiler.WriteProfile(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
 	}
-	compiler := interpreter.NewCompiler(*productive)
-	compiler.Compile(node)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return code
+}
+
+func disasm(args []string) int {
+	flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+	productive := flags.Bool("p", false, "leave the hope blocks out")
+	optimize := flags.Bool("O", false, "fold constants and leave out dead code")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: ho disasm [-p] [-O] file")
+		return 2
+	}
+	bc, ok := loadFile(flags.Arg(0), *productive, *optimize)
+	if !ok {
+		return 1
+	}
+	if err := interpreter.Disassemble(os.Stdout, bc); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func debug(args []string) int {
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	productive := flags.Bool("p", false, "leave the hope blocks out")
+	dap := flags.Bool("dap", false, "serve the Debug Adapter Protocol over stdio, the client launches the program")
+	flags.Parse(args)
+	if *dap {
+		// the protocol owns stdout, whatever else is printed goes to stderr
+		out := os.Stdout
+		os.Stdout = os.Stderr
+		if err := interpreter.ServeDAP(os.Stdin, out); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: ho debug [-p] file | ho debug -dap")
+		return 2
+	}
+	filename := flags.Arg(0)
+	source, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	bc, ok := compileFile(filename, *productive, false)
+	if !ok {
+		return 1
+	}
+	interpreter.StartDebugger(interpreter.NewDebugger(bc), filename, string(source), os.Stdin, os.Stdout)
+	return 0
+}
+
+// reads a .hoc file, or compiles a source file
+func loadFile(filename string, productive, optimize bool) (interpreter.Bytecode, bool) {
+	input, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return interpreter.Bytecode{}, false
+	}
+	// precompiled, skip the compiler
+	if interpreter.IsHoc(input) {
+		bc, err := interpreter.ReadBytecode(bytes.NewReader(input))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", filename, err)
+			return interpreter.Bytecode{}, false
+		}
+		return bc, true
+	}
+	return compileFile(filename, productive, optimize)
+}
 
-	vm := interpreter.NewVM(compiler.Bytecode())
-	vm.Run()
+// reports what is wrong with the source to stderr
+func compileFile(filename string, productive, optimize bool) (interpreter.Bytecode, bool) {
+	input, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return interpreter.Bytecode{}, false
+	}
 
+	in := strings.NewReader(string(input))
+	lexer := interpreter.NewFileLexer(filename, in)
+	parser := interpreter.NewParser(lexer)
+	node, diags := parser.Parse(nil)
+	if len(diags) == 0 {
+		diags = interpreter.NewTypeChecker().Check(node)
+	}
+	if len(diags) > 0 {
+		for _, d := range diags {
+			fmt.Fprintf(os.Stderr, "%s:%d:%d: %s\n", filename, d.Line, d.Column, d.Message)
+		}
+		return interpreter.Bytecode{}, false
+	}
+	compiler := interpreter.NewCompiler(productive)
+	compiler.SetOptimize(optimize)
+	if err := compiler.Compile(node); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return interpreter.Bytecode{}, false
+	}
+	return compiler.Bytecode(), true
+}
+
+func runBytecode(bc interpreter.Bytecode, tracer interpreter.Tracer) int {
+	vm := interpreter.NewVM(bc)
+	if tracer != nil {
+		vm.SetTracer(tracer)
+	}
+	if err := vm.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(vm.LastPopped())
+	return 0
 }
 
 // //================== test lexer